@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 	"log"
@@ -12,7 +13,9 @@ import (
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/widget"
+	"github.com/reagin/double_ratchet/contact"
 	"github.com/reagin/double_ratchet/core"
+	"github.com/reagin/double_ratchet/utils"
 )
 
 func init() {
@@ -20,8 +23,17 @@ func init() {
 	remotePort = "8080"
 	localAddress = "127.0.0.1:" + localPort
 	listenAddress = "0.0.0.0:" + localPort
+	keyPair, err := utils.LoadIdentityKey(identityKeyPath)
+	if err != nil {
+		log.Printf("⚠️ 读取身份密钥失败，使用临时身份密钥: %s\n", err.Error())
+		keyPair = utils.NewDiffeHellmanKeyPair()
+	}
+	identityKey = keyPair
+	if contactBook, err = contact.NewBook(contactBookPath); err != nil {
+		log.Fatalf("🤯 读取联系人列表失败: %s\n", err.Error())
+	}
 	server = core.NewServer(listenAddress)
-	client = core.NewClient(localAddress, remoteAddress+":"+remotePort)
+	client = core.NewClient(localAddress, remoteEndpoint(remoteAddress, remotePort))
 }
 
 func StartDoubleRatchet() {
@@ -112,8 +124,41 @@ func StartDoubleRatchet() {
 	})
 	// 创建设置按钮
 	settingButton := widget.NewButton("Setting", func() {
+		current := loadConfig()
 		remoteEntry := widget.NewEntry()
-		remoteEntry.SetText(remoteAddress)
+		remoteEntry.SetText(current.remoteAddress)
+		// 对等模式下双方同时监听与拨号，无需区分客户端与服务端
+		peerCheck := widget.NewCheck("", nil)
+		peerCheck.SetChecked(current.runMode == PeerMode)
+		// Noise 握手需要双方同时开启
+		noiseCheck := widget.NewCheck("", nil)
+		noiseCheck.SetChecked(current.handshakeMode == core.NoiseHandshake)
+		// 安全码基于双方的身份公钥，需要 Noise 握手认证对端身份
+		safetyButton := widget.NewButton("Show", func() {
+			showSafetyNumberDialog(myWindow)
+		})
+		// 双方输入相同的配对码，为空时不执行配对
+		pairingEntry := widget.NewEntry()
+		pairingEntry.SetText(current.pairingCode)
+		pairingEntry.SetPlaceHolder("7-guitar-revenge")
+		generateButton := widget.NewButton("New", func() {
+			pairingEntry.SetText(utils.NewPairingCode())
+		})
+		contactsButton := widget.NewButton("Manage", func() {
+			showContactsDialog(myWindow)
+		})
+		// 设置用户名后定期在目录服务注册本机地址，远程地址可以填写 @用户名
+		directoryEntry := widget.NewEntry()
+		directoryEntry.SetText(directoryAddress)
+		directoryEntry.SetPlaceHolder("127.0.0.1:7300")
+		usernameEntry := widget.NewEntry()
+		usernameEntry.SetText(username)
+		// 设置密钥透明日志后，通过用户名查询到的身份公钥还需要与日志中的绑定一致
+		transparencyEntry := widget.NewEntry()
+		transparencyEntry.SetText(transparencyAddress)
+		transparencyEntry.SetPlaceHolder("127.0.0.1:7400")
+		logKeyEntry := widget.NewEntry()
+		logKeyEntry.SetText(transparencyKey)
 
 		form := dialog.NewForm(
 			"Setting",
@@ -121,31 +166,97 @@ func StartDoubleRatchet() {
 			"Cancel",
 			[]*widget.FormItem{
 				widget.NewFormItem("Remote Address", remoteEntry),
+				widget.NewFormItem("Peer Mode", peerCheck),
+				widget.NewFormItem("Noise Handshake", noiseCheck),
+				widget.NewFormItem("Pairing Code", container.NewBorder(nil, nil, nil, generateButton, pairingEntry)),
+				widget.NewFormItem("Safety Number", safetyButton),
+				widget.NewFormItem("Contacts", contactsButton),
+				widget.NewFormItem("Directory", directoryEntry),
+				widget.NewFormItem("Username", usernameEntry),
+				widget.NewFormItem("Transparency Log", transparencyEntry),
+				widget.NewFormItem("Log Key", logKeyEntry),
 			},
 			func(confirm bool) {
 				if confirm {
-					if remoteEntry.Text == remoteAddress {
-						return
+					register := false
+					if transparencyEntry.Text != transparencyAddress || logKeyEntry.Text != transparencyKey {
+						client, err := newTransparencyClient(transparencyEntry.Text, logKeyEntry.Text)
+						if err != nil {
+							dialog.ShowError(err, myWindow)
+							return
+						}
+						transparencyAddress, transparencyKey, transparencyClient = transparencyEntry.Text, logKeyEntry.Text, client
+						register = true
+					}
+					if directoryEntry.Text != directoryAddress || usernameEntry.Text != username {
+						directoryAddress = directoryEntry.Text
+						username = usernameEntry.Text
+						register = true
+						startAdvertiser()
 					}
-					if remoteAddress != "" && remoteEntry.Text == "" {
-						runMode = ServerMode
-					} else {
-						runMode = ClientMode
+					if register {
+						startRegistration()
+					}
+					remoteText, isPeer, isNoise, code := remoteEntry.Text, peerCheck.Checked, noiseCheck.Checked, pairingEntry.Text
+					// 只有 Noise 握手能够认证目录服务给出的身份公钥
+					if isUsername(remoteText) && !isNoise {
+						dialog.ShowError(ErrNoiseRequired, myWindow)
+						return
 					}
-					remoteAddress = remoteEntry.Text
-					isChange <- true
+					// 查询目录服务需要网络请求，在后台完成后再切换会话；
+					// 后台协程只在互斥锁内修改会话配置，由状态协程按新的配置重建会话
+					lookup := newDirectoryClient()
+					go func() {
+						remote, identity, err := resolveRemote(lookup, remoteText)
+						if err != nil {
+							// fyne v2.5 的对话框可以在任意协程中显示
+							dialog.ShowError(err, myWindow)
+							return
+						}
+						changeConfig(func() bool {
+							if remote == remoteAddress && bytes.Equal(identity, remoteIdentity) && isPeer == (runMode == PeerMode) &&
+								isNoise == (handshakeMode == core.NoiseHandshake) && code == pairingCode {
+								return false
+							}
+							pairingCode = code
+							if isNoise {
+								handshakeMode = core.NoiseHandshake
+							} else {
+								handshakeMode = core.ClassicHandshake
+							}
+							if remote == "" {
+								runMode = ServerMode
+							} else if isPeer {
+								runMode = PeerMode
+							} else {
+								runMode = ClientMode
+							}
+							remoteAddress = remote
+							remoteIdentity = identity
+							remoteFingerprint = ""
+							return true
+						})
+					}()
 				}
 			},
 			myWindow,
 		)
 
-		form.Resize(fyne.NewSize(300, 160))
+		form.Resize(fyne.NewSize(360, 360))
 		form.Show()
 	})
+	// 创建验证按钮
+	verifyButton := widget.NewButton("Verify", func() {
+		showVerifyDialog(myWindow)
+	})
+	// 创建附近节点按钮
+	nearbyButton := widget.NewButton("Nearby", func() {
+		showNearbyDialog(myWindow)
+	})
 	var buttonContainer *fyne.Container
-	var buttonLayout = layout.NewGridWrapLayout(fyne.NewSize(140, 50))
+	var buttonLayout = layout.NewGridWrapLayout(fyne.NewSize(112, 50))
 	// 设置按钮容器
-	buttonContainer = container.New(buttonLayout, fileButton, sendButton, settingButton)
+	buttonContainer = container.New(buttonLayout, fileButton, sendButton, settingButton, verifyButton, nearbyButton)
 	// 设置底部容器
 	bottomContainer := container.NewBorder(nil, nil, nil, buttonContainer, input)
 	// 设置主界面容器
@@ -154,19 +265,51 @@ func StartDoubleRatchet() {
 	// 启动协程更新状态
 	go func() {
 		for {
-			switch runMode {
+			// 切换模式前停止之前的实例，避免旧的服务端与新的实例共用监听端口后分走连接
+			var stopCurrent func()
+			config := loadConfig()
+			switch config.runMode {
 			case ClientMode:
-				client = core.NewClient(localAddress, remoteAddress+":"+remotePort)
+				client = core.NewClient(localAddress, remoteEndpoint(config.remoteAddress, remotePort))
+				client.Handshake.Mode = config.handshakeMode
+				client.Handshake.IdentityKey = identityKey
+				client.Handshake.PairingCode = config.pairingCode
+				client.Handshake.RemoteIdentity = config.remoteIdentity
+				client.Handshake.CheckIdentity = checkContactIdentity(myWindow, config.remoteFingerprint)
 				sendChannel = client.SendChannel
 				recvChannel = client.RecvChannel
-				go client.StartClient()
+				peerChannel = nil
+				stopCurrent = client.StopClient
+				client.StartClient()
 			case ServerMode:
 				server = core.NewServer(listenAddress)
+				server.Handshake.Mode = config.handshakeMode
+				server.Handshake.IdentityKey = identityKey
+				server.Handshake.PairingCode = config.pairingCode
+				server.Handshake.CheckIdentity = checkContactIdentity(myWindow, "")
 				sendChannel = server.SendChannel
-				recvChannel = server.RecvChannel
-				go server.StartServer()
+				recvChannel = nil
+				peerChannel = server.RecvChannel
+				stopCurrent = server.StopServer
+				server.StartServer()
+			case PeerMode:
+				// 双方使用相同的端口，互相拨号对方的监听端口
+				peer = core.NewPeer(listenAddress, remoteEndpoint(config.remoteAddress, localPort))
+				peer.Handshake.Mode = config.handshakeMode
+				peer.Handshake.IdentityKey = identityKey
+				peer.Handshake.PairingCode = config.pairingCode
+				peer.Handshake.RemoteIdentity = config.remoteIdentity
+				peer.Handshake.CheckIdentity = checkContactIdentity(myWindow, config.remoteFingerprint)
+				sendChannel = peer.SendChannel
+				recvChannel = peer.RecvChannel
+				peerChannel = nil
+				stopCurrent = peer.StopPeer
+				peer.StartPeer()
 			}
 			<-isChange
+			if stopCurrent != nil {
+				stopCurrent()
+			}
 			dataList = dataList[:0]
 			chatList.Refresh()
 		}
@@ -180,6 +323,8 @@ func StartDoubleRatchet() {
 			var messageBytes []byte
 			select {
 			case messageBytes = <-recvChannel:
+			case peerMessage := <-peerChannel:
+				messageBytes = peerMessage.Message
 			default:
 				continue // 如果没有新消息，继续循环
 			}
@@ -213,6 +358,10 @@ func StartDoubleRatchet() {
 		}
 	}()
 
+	// 在局域网中公布本机并浏览附近的节点
+	startAdvertiser()
+	startBrowser()
+
 	myWindow.SetContent(mainContainer)
 	myWindow.ShowAndRun()
 }
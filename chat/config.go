@@ -1,31 +1,82 @@
 package chat
 
-import "github.com/reagin/double_ratchet/core"
+import (
+	"sync"
+
+	"github.com/reagin/double_ratchet/contact"
+	"github.com/reagin/double_ratchet/core"
+	"github.com/reagin/double_ratchet/utils"
+)
 
 const (
 	FileType = iota
 	TextType
 	ClientMode
 	ServerMode
+	PeerMode
 )
 
+// 长期身份密钥的保存路径，重启后安全码保持不变
+const identityKeyPath = "./identity.key"
+
+// 联系人列表的保存路径
+const contactBookPath = "./contacts.json"
+
 var (
 	localPort     string
 	remotePort    string
 	localAddress  string
 	listenAddress string
-	remoteAddress string
 	sendChannel   chan []byte
 	recvChannel   chan []byte
+	peerChannel   chan *core.PeerMessage
 )
 
 var client *core.Client
 var server *core.Server
+var peer *core.Peer
+var identityKey *utils.DiffeHellmanKeyPair
+var contactBook *contact.Book
 var dataList []*Message
 
+// 会话配置由 UI 协程与解析用户名的协程修改、状态协程读取，需要在 configMutex 内访问
+var configMutex sync.Mutex
 var runMode = ServerMode
+var remoteAddress string
+var handshakeMode = core.ClassicHandshake
+var pairingCode string
+var remoteIdentity []byte    // 目录服务给出的对端身份公钥，非空时握手认证的对端身份必须与之一致
+var remoteFingerprint string // 附近节点公布的身份指纹，非空时对端身份公钥的指纹必须与之一致
 var isChange = make(chan bool)
 
+// 会话配置的快照
+type sessionConfig struct {
+	runMode           int
+	handshakeMode     int
+	pairingCode       string
+	remoteAddress     string
+	remoteIdentity    []byte
+	remoteFingerprint string
+}
+
+// 读取当前的会话配置
+func loadConfig() sessionConfig {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	return sessionConfig{runMode, handshakeMode, pairingCode, remoteAddress, remoteIdentity, remoteFingerprint}
+}
+
+// 在互斥锁内修改会话配置，update 返回 true 时通知状态协程按新的配置切换会话
+func changeConfig(update func() bool) {
+	configMutex.Lock()
+	isChanged := update()
+	configMutex.Unlock()
+	if isChanged {
+		isChange <- true
+	}
+}
+
 type Message struct {
 	Type    int
 	IsSelf  bool
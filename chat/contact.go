@@ -0,0 +1,191 @@
+package chat
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/reagin/double_ratchet/contact"
+	"github.com/reagin/double_ratchet/core"
+	"github.com/reagin/double_ratchet/utils"
+)
+
+func contactStatusText(status int) string {
+	switch status {
+	case contact.Unverified:
+		return "Unverified"
+	case contact.Verified:
+		return "Verified"
+	case contact.KeyChanged:
+		return "Key Changed!"
+	default:
+		return "Unknown"
+	}
+}
+
+// 返回会话对应的联系人：只处理认证过身份的会话，先按身份公钥查找，再按完整的地址查找，
+// 未知的对端以地址作为昵称加入联系人列表；昵称已被其他联系人占用时不合并，返回 nil
+func sessionContact(session *core.Session) *contact.Contact {
+	if len(session.RemoteIdentity) == 0 {
+		return nil
+	}
+	if known := contactBook.FindByIdentity(session.RemoteIdentity); known != nil {
+		return known
+	}
+	if known := contactBook.FindByAddress(session.RemoteAddress); known != nil {
+		return known
+	}
+	if err := contactBook.Add(session.RemoteAddress, session.RemoteAddress); err != nil {
+		log.Printf("❌ 添加联系人失败: %s\n", err.Error())
+		return nil
+	}
+	return contactBook.Get(session.RemoteAddress)
+}
+
+// 在握手完成后检查对端身份公钥，公钥变化时弹出警告；
+// fingerprint 非空时对端身份公钥的指纹必须与之一致
+func checkContactIdentity(window fyne.Window, fingerprint string) func(session *core.Session) error {
+	return func(session *core.Session) error {
+		if fingerprint != "" && subtle.ConstantTimeCompare([]byte(utils.Fingerprint(session.RemoteIdentity)), []byte(fingerprint)) != 1 {
+			dialog.ShowError(ErrFingerprintMismatch, window)
+			return ErrFingerprintMismatch
+		}
+
+		known := sessionContact(session)
+		if known == nil {
+			return nil
+		}
+
+		status, err := contactBook.CheckIdentity(known.Nickname, session.RemoteIdentity)
+		switch {
+		case errors.Is(err, contact.ErrKeyChanged):
+			dialog.ShowError(fmt.Errorf("the identity key of %s has changed, the session was blocked", known.Nickname), window)
+			return err
+		case err != nil:
+			log.Printf("❌ 保存联系人失败: %s\n", err.Error())
+		}
+
+		switch status {
+		case contact.Verified:
+			// 已验证过的身份公钥无需再次比对
+			session.Verify(true)
+		case contact.KeyChanged:
+			dialog.ShowInformation("Warning", "The identity key of "+known.Nickname+" has changed!\nVerify the safety number before trusting this session.", window)
+		}
+		return nil
+	}
+}
+
+// 会话通过人工验证后，同时将对应联系人的身份公钥标记为已验证
+func markContactVerified(session *core.Session) {
+	if len(session.RemoteIdentity) == 0 {
+		return
+	}
+	if known := sessionContact(session); known != nil {
+		if err := contactBook.MarkVerified(known.Nickname, session.RemoteIdentity); err != nil {
+			log.Printf("❌ 标记联系人已验证失败: %s\n", err.Error())
+		}
+	}
+}
+
+// 管理联系人：添加、删除、连接，并展示每个联系人的验证状态
+func showContactsDialog(window fyne.Window) {
+	contacts := contactBook.Contacts()
+	selected := -1
+
+	contactList := widget.NewList(
+		func() int {
+			return len(contacts)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("Template Text")
+		},
+		func(lii widget.ListItemID, co fyne.CanvasObject) {
+			item := contacts[lii]
+			text := item.Nickname + "  " + fmt.Sprint(item.Addresses) + "  " + contactStatusText(item.Status)
+			if len(item.IdentityKey) > 0 {
+				text += "  " + utils.Fingerprint(item.IdentityKey)[:10]
+			}
+			co.(*widget.Label).SetText(text)
+		},
+	)
+	contactList.OnSelected = func(id widget.ListItemID) {
+		selected = id
+	}
+	refresh := func() {
+		contacts = contactBook.Contacts()
+		selected = -1
+		contactList.UnselectAll()
+		contactList.Refresh()
+	}
+
+	nicknameEntry := widget.NewEntry()
+	nicknameEntry.SetPlaceHolder("Nickname")
+	addressEntry := widget.NewEntry()
+	addressEntry.SetPlaceHolder("Address")
+	addButton := widget.NewButton("Add", func() {
+		if nicknameEntry.Text == "" || addressEntry.Text == "" {
+			return
+		}
+		if err := contactBook.Add(nicknameEntry.Text, addressEntry.Text); err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		nicknameEntry.SetText("")
+		addressEntry.SetText("")
+		refresh()
+	})
+
+	var contactsDialog dialog.Dialog
+	connectButton := widget.NewButton("Connect", func() {
+		if selected < 0 || len(contacts[selected].Addresses) == 0 {
+			return
+		}
+		address := contacts[selected].Addresses[0]
+		contactsDialog.Hide()
+		changeConfig(func() bool {
+			remoteAddress = address
+			remoteIdentity = nil
+			remoteFingerprint = ""
+			if runMode != PeerMode {
+				runMode = ClientMode
+			}
+			return true
+		})
+	})
+	removeButton := widget.NewButton("Remove", func() {
+		if selected < 0 {
+			return
+		}
+		if err := contactBook.Remove(contacts[selected].Nickname); err != nil {
+			dialog.ShowError(err, window)
+		}
+		refresh()
+	})
+	// 默认仅警告，开启后拒绝身份公钥发生变化的会话
+	blockCheck := widget.NewCheck("Block sessions when a key changes", func(checked bool) {
+		if err := contactBook.SetBlock(checked); err != nil {
+			dialog.ShowError(err, window)
+		}
+	})
+	blockCheck.SetChecked(contactBook.Blocked())
+
+	content := container.NewBorder(
+		nil,
+		container.NewVBox(
+			container.NewGridWithColumns(3, nicknameEntry, addressEntry, addButton),
+			container.NewGridWithColumns(2, connectButton, removeButton),
+			blockCheck,
+		),
+		nil, nil,
+		contactList,
+	)
+	contactsDialog = dialog.NewCustom("Contacts", "Close", content, window)
+	contactsDialog.Resize(fyne.NewSize(560, 400))
+	contactsDialog.Show()
+}
@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/reagin/double_ratchet/directory"
+	"github.com/reagin/double_ratchet/transparency"
+)
+
+// 目录记录的有效期，注册后每隔一半有效期刷新一次
+const directoryTTL = 10 * time.Minute
+
+var ErrNoEndpoint = errors.New("该用户没有可以连接的地址")
+var ErrNoiseRequired = errors.New("需要开启 Noise 握手以认证对方身份")
+var ErrLogKey = errors.New("密钥透明日志公钥格式错误")
+
+var directoryAddress string
+var username string
+var registerStop chan bool
+
+// 设置了密钥透明日志时，目录服务返回的身份公钥必须与日志中的绑定一致；
+// 日志客户端保存已验证的树头，更换日志设置前一直复用
+var transparencyAddress string
+var transparencyKey string
+var transparencyClient *transparency.Client
+
+// 根据日志地址与十六进制的日志公钥创建日志客户端，地址为空时不使用日志
+func newTransparencyClient(address, logKey string) (*transparency.Client, error) {
+	if address == "" {
+		return nil, nil
+	}
+	keyBytes, err := hex.DecodeString(logKey)
+	if err != nil || len(keyBytes) != 32 {
+		return nil, ErrLogKey
+	}
+	return transparency.NewClient(address, keyBytes, identityKey), nil
+}
+
+// 使用当前的目录服务与日志设置创建目录客户端，需要在修改设置的 goroutine 中调用
+func newDirectoryClient() *directory.Client {
+	client := directory.NewClient(directoryAddress, identityKey)
+	client.Transparency = transparencyClient
+	return client
+}
+
+// 以 @ 开头的远程地址视为用户名，通过目录服务查询对方当前的地址与身份公钥；
+// 返回的身份公钥用于固定对端身份，直接填写的地址没有已知的身份公钥
+func resolveRemote(client *directory.Client, address string) (string, []byte, error) {
+	if !isUsername(address) {
+		return address, nil, nil
+	}
+	entry, err := client.Lookup(strings.TrimPrefix(address, "@"))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(entry.Endpoints) == 0 {
+		return "", nil, ErrNoEndpoint
+	}
+	return entry.Endpoints[0], entry.IdentityKey, nil
+}
+
+// 远程地址是否为需要通过目录服务查询的用户名
+func isUsername(address string) bool {
+	return strings.HasPrefix(address, "@")
+}
+
+// 远程地址不带端口时使用默认端口
+func remoteEndpoint(address, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(address, defaultPort)
+}
+
+// 停止之前的注册，并在设置了目录服务与用户名时定期注册自己的地址
+func startRegistration() {
+	if registerStop != nil {
+		close(registerStop)
+		registerStop = nil
+	}
+	if directoryAddress == "" || username == "" {
+		return
+	}
+
+	stopChan := make(chan bool)
+	registerStop = stopChan
+	client := newDirectoryClient()
+	name := username
+	go func() {
+		ticker := time.NewTicker(directoryTTL / 2)
+		defer ticker.Stop()
+		for {
+			if endpoint, err := localEndpoint(client.ServerAddress); err != nil {
+				log.Printf("❌ 获取本机地址失败: %s\n", err.Error())
+			} else if err := client.Register(name, []string{endpoint}, directoryTTL); err != nil {
+				log.Printf("❌ 注册用户名 %s 失败: %s\n", name, err.Error())
+			} else {
+				log.Printf("📇 已在目录服务注册用户名 %s: %s\n", name, endpoint)
+			}
+			// 对方通过日志验证目录服务返回的身份公钥，绑定没有变化时日志不会重复追加
+			if client.Transparency != nil {
+				if _, err := client.Transparency.Publish(name, nil); err != nil {
+					log.Printf("❌ 发布用户名 %s 到密钥透明日志失败: %s\n", name, err.Error())
+				}
+			}
+
+			select {
+			case <-stopChan:
+				client.Unregister(name)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// 使用连接目录服务时的本机地址，与监听端口组成对方可以连接的地址
+func localEndpoint(serverAddress string) (string, error) {
+	connect, err := net.Dial("udp", serverAddress)
+	if err != nil {
+		return "", err
+	}
+	defer connect.Close()
+	host, _, err := net.SplitHostPort(connect.LocalAddr().String())
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, localPort), nil
+}
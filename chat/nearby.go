@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/reagin/double_ratchet/core"
+	"github.com/reagin/double_ratchet/discovery"
+	"github.com/reagin/double_ratchet/utils"
+)
+
+var ErrFingerprintMismatch = errors.New("对端身份公钥的指纹与附近节点公布的不一致")
+
+var advertiser *discovery.Advertiser
+var browser *discovery.Browser
+
+// 停止之前的公布，以用户名 (未设置时使用主机名) 作为实例名在局域网中公布本机
+func startAdvertiser() {
+	if advertiser != nil {
+		advertiser.StopAdvertiser()
+		advertiser = nil
+	}
+	instance := username
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+
+	newAdvertiser, err := discovery.NewAdvertiser(instance, listenAddress, identityKey.PublicKey.Bytes())
+	if err != nil {
+		log.Printf("❌ 公布局域网服务失败: %s\n", err.Error())
+		return
+	}
+	if err := newAdvertiser.StartAdvertiser(); err != nil {
+		log.Printf("❌ 公布局域网服务失败: %s\n", err.Error())
+		return
+	}
+	advertiser = newAdvertiser
+}
+
+// 在后台浏览局域网中的节点，供附近节点列表使用
+func startBrowser() {
+	browser = discovery.NewBrowser()
+	if err := browser.StartBrowser(); err != nil {
+		log.Printf("❌ 浏览局域网服务失败: %s\n", err.Error())
+		browser = nil
+	}
+}
+
+// 返回局域网中发现的节点，不包括本机
+func nearbyPeers() []*discovery.Peer {
+	if browser == nil {
+		return nil
+	}
+	fingerprint := utils.Fingerprint(identityKey.PublicKey.Bytes())
+	peers := []*discovery.Peer{}
+	for _, item := range browser.Peers() {
+		if item.Fingerprint != fingerprint && item.Address() != "" {
+			peers = append(peers, item)
+		}
+	}
+	return peers
+}
+
+// 列出局域网中的节点，选中后直接连接；节点公布了身份指纹时，
+// 握手认证的对端身份公钥必须与该指纹一致
+func showNearbyDialog(window fyne.Window) {
+	peers := nearbyPeers()
+	var nearbyDialog dialog.Dialog
+	peerList := widget.NewList(
+		func() int {
+			return len(peers)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("Template Text")
+		},
+		func(lii widget.ListItemID, co fyne.CanvasObject) {
+			item := peers[lii]
+			text := item.Instance + "  " + item.Address()
+			if item.Fingerprint != "" {
+				text += "  " + item.Fingerprint[:min(10, len(item.Fingerprint))]
+			}
+			co.(*widget.Label).SetText(text)
+		},
+	)
+	peerList.OnSelected = func(id widget.ListItemID) {
+		if peers[id].Fingerprint != "" && loadConfig().handshakeMode != core.NoiseHandshake {
+			peerList.UnselectAll()
+			dialog.ShowError(ErrNoiseRequired, window)
+			return
+		}
+		address, fingerprint := peers[id].Address(), peers[id].Fingerprint
+		nearbyDialog.Hide()
+		changeConfig(func() bool {
+			remoteAddress = address
+			remoteIdentity = nil
+			remoteFingerprint = fingerprint
+			if runMode != PeerMode {
+				runMode = ClientMode
+			}
+			return true
+		})
+	}
+	refreshButton := widget.NewButton("Refresh", func() {
+		peers = nearbyPeers()
+		peerList.UnselectAll()
+		peerList.Refresh()
+	})
+
+	content := container.NewBorder(nil, refreshButton, nil, nil, peerList)
+	nearbyDialog = dialog.NewCustom("Nearby", "Close", content, window)
+	nearbyDialog.Resize(fyne.NewSize(560, 400))
+	nearbyDialog.Show()
+}
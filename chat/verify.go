@@ -0,0 +1,199 @@
+package chat
+
+import (
+	"log"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/reagin/double_ratchet/core"
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 返回当前运行模式下已建立的会话
+func currentSessions() []*core.Session {
+	sessions := []*core.Session{}
+	switch loadConfig().runMode {
+	case ClientMode:
+		if client != nil && client.Session() != nil {
+			sessions = append(sessions, client.Session())
+		}
+	case ServerMode:
+		if server != nil {
+			for _, peerID := range server.Peers() {
+				if session := server.Session(peerID); session != nil {
+					sessions = append(sessions, session)
+				}
+			}
+		}
+	case PeerMode:
+		if peer != nil && peer.Session() != nil {
+			sessions = append(sessions, peer.Session())
+		}
+	}
+	return sessions
+}
+
+func verifyStatusText(status int) string {
+	switch status {
+	case core.Verified:
+		return "Verified"
+	case core.Compromised:
+		return "Compromised"
+	default:
+		return "Unverified"
+	}
+}
+
+// 以 "对端标识 (地址)" 的形式列出会话，选中后回调对应的会话
+func newSessionSelect(sessions []*core.Session, onSelected func(*core.Session)) *widget.Select {
+	options := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		options = append(options, session.PeerID+" ("+session.RemoteAddress+")")
+	}
+	sessionSelect := widget.NewSelect(options, func(option string) {
+		for i := range options {
+			if options[i] == option {
+				onSelected(sessions[i])
+			}
+		}
+	})
+	sessionSelect.SetSelectedIndex(0)
+	return sessionSelect
+}
+
+// 展示短认证字符串，由用户与对方比对后标记会话是否可信
+func showVerifyDialog(window fyne.Window) {
+	sessions := currentSessions()
+	if len(sessions) == 0 {
+		dialog.ShowInformation("Verify", "No established session yet.", window)
+		return
+	}
+
+	selected := sessions[0]
+	sasLabel := widget.NewLabel("")
+	statusLabel := widget.NewLabel("")
+	refresh := func() {
+		sasLabel.SetText(selected.SAS())
+		statusLabel.SetText(verifyStatusText(selected.VerifyStatus()))
+	}
+	refresh()
+
+	peerSelect := newSessionSelect(sessions, func(session *core.Session) {
+		selected = session
+		refresh()
+	})
+
+	var verifyDialog dialog.Dialog
+	matchButton := widget.NewButton("Match", func() {
+		selected.Verify(true)
+		markContactVerified(selected)
+		verifyDialog.Hide()
+	})
+	mismatchButton := widget.NewButton("Mismatch", func() {
+		selected.Verify(false)
+		verifyDialog.Hide()
+		dialog.ShowInformation("Verify", "The session was marked as compromised and has been closed.", window)
+	})
+
+	content := container.NewVBox(
+		widget.NewLabel("Compare this code with your peer over a trusted channel."),
+		peerSelect,
+		widget.NewForm(
+			widget.NewFormItem("Code", sasLabel),
+			widget.NewFormItem("Status", statusLabel),
+		),
+		container.NewGridWithColumns(2, matchButton, mismatchButton),
+	)
+	verifyDialog = dialog.NewCustom("Verify", "Close", content, window)
+	verifyDialog.Show()
+}
+
+// 展示双方身份公钥的安全码，可导出为二维码图片或与对方导出的图片比对，
+// 比对一致后会话被标记为已验证
+func showSafetyNumberDialog(window fyne.Window) {
+	sessions := []*core.Session{}
+	for _, session := range currentSessions() {
+		if session.SafetyNumber() != "" {
+			sessions = append(sessions, session)
+		}
+	}
+	if len(sessions) == 0 {
+		dialog.ShowInformation("Safety Number", "Safety numbers require an established Noise handshake.", window)
+		return
+	}
+
+	selected := sessions[0]
+	numberLabel := widget.NewLabel("")
+	numberLabel.Wrapping = fyne.TextWrapWord
+	statusLabel := widget.NewLabel("")
+	refresh := func() {
+		numberLabel.SetText(utils.FormatSafetyNumber(selected.SafetyNumber()))
+		statusLabel.SetText(verifyStatusText(selected.VerifyStatus()))
+	}
+	refresh()
+
+	peerSelect := newSessionSelect(sessions, func(session *core.Session) {
+		selected = session
+		refresh()
+	})
+
+	exportButton := widget.NewButton("Export QR", func() {
+		dialog.ShowFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil || writer == nil {
+				return
+			}
+			defer writer.Close()
+
+			pngBytes, err := utils.SafetyNumberQRCode(selected.SafetyNumber(), 256)
+			if err == nil {
+				_, err = writer.Write(pngBytes)
+			}
+			if err != nil {
+				log.Printf("❌ 导出安全码二维码失败: %s\n", err.Error())
+				dialog.ShowError(err, window)
+				return
+			}
+			log.Printf("✅ 安全码二维码已导出: %s\n", writer.URI().Path())
+		}, window)
+	})
+	compareButton := widget.NewButton("Compare Image", func() {
+		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil || reader == nil {
+				return
+			}
+			defer reader.Close()
+
+			isMatched, err := utils.CompareSafetyNumberQRCode(reader, selected.SafetyNumber())
+			if err != nil {
+				log.Printf("❌ 读取安全码二维码失败: %s\n", err.Error())
+				dialog.ShowError(err, window)
+				return
+			}
+			selected.Verify(isMatched)
+			if isMatched {
+				markContactVerified(selected)
+			}
+			refresh()
+			if isMatched {
+				dialog.ShowInformation("Safety Number", "Safety numbers match, the session is verified.", window)
+			} else {
+				dialog.ShowInformation("Safety Number", "Safety numbers differ! The session was marked as compromised and has been closed.", window)
+			}
+		}, window)
+	})
+
+	content := container.NewVBox(
+		widget.NewLabel("Compare these numbers with your peer, or exchange the QR images."),
+		peerSelect,
+		widget.NewForm(
+			widget.NewFormItem("Number", numberLabel),
+			widget.NewFormItem("Status", statusLabel),
+		),
+		container.NewGridWithColumns(2, exportButton, compareButton),
+	)
+	safetyDialog := dialog.NewCustom("Safety Number", "Close", content, window)
+	safetyDialog.Resize(fyne.NewSize(420, 300))
+	safetyDialog.Show()
+}
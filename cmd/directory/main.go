@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/reagin/double_ratchet/directory"
+)
+
+func main() {
+	listenAddress := flag.String("listen", "0.0.0.0:7300", "目录服务监听地址")
+	storeDirectory := flag.String("store", "", "目录记录的保存目录，为空时保存在内存中")
+	difficulty := flag.Int("difficulty", 20, "注册所需的工作量证明难度 (前导零比特数)")
+	maxTTL := flag.Duration("max-ttl", 24*time.Hour, "记录的最长有效期")
+	flag.Parse()
+
+	var store directory.Store = directory.NewMemoryStore()
+	if *storeDirectory != "" {
+		fileStore, err := directory.NewFileStore(*storeDirectory)
+		if err != nil {
+			log.Fatalf("❌ 打开目录记录的保存目录失败: %s\n", err.Error())
+		}
+		store = fileStore
+	}
+	server := directory.NewServer(*listenAddress, store)
+	server.Difficulty = *difficulty
+	server.MaxTTL = *maxTTL
+	if err := server.StartServer(); err != nil {
+		log.Fatalf("❌ 启动目录服务失败: %s\n", err.Error())
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	<-signalChan
+	server.StopServer()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/reagin/double_ratchet/prekey"
+)
+
+func main() {
+	listenAddress := flag.String("listen", "0.0.0.0:7100", "预密钥服务监听地址")
+	storeDirectory := flag.String("store", "./prekeys", "预密钥的保存目录")
+	lowWatermark := flag.Int("watermark", 10, "一次性预密钥少于该数量时通知补充")
+	flag.Parse()
+
+	store, err := prekey.NewFileStore(*storeDirectory)
+	if err != nil {
+		log.Fatalf("❌ 打开预密钥目录失败: %s\n", err.Error())
+	}
+	server := prekey.NewServer(*listenAddress, store)
+	server.LowWatermark = *lowWatermark
+	if err := server.StartServer(); err != nil {
+		log.Fatalf("❌ 启动预密钥服务失败: %s\n", err.Error())
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	<-signalChan
+	server.StopServer()
+}
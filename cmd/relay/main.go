@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/reagin/double_ratchet/relay"
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 可以重复指定的 -peer 与 -circuit-target 参数
+type peerFlags []string
+
+func (peers *peerFlags) String() string {
+	return strings.Join(*peers, ",")
+}
+
+func (peers *peerFlags) Set(value string) error {
+	*peers = append(*peers, value)
+	return nil
+}
+
+func main() {
+	listenAddress := flag.String("listen", "0.0.0.0:7200", "中继服务监听地址")
+	storeDirectory := flag.String("store", "", "邮箱的保存目录，为空时保存在内存中")
+	maxMessages := flag.Int("max-messages", 1000, "每个邮箱最多保存的消息数量")
+	maxBytes := flag.Int("max-bytes", 16<<20, "每个邮箱最多保存的密文字节数")
+	ttl := flag.Duration("ttl", 7*24*time.Hour, "消息的默认保存时间")
+	host := flag.String("host", "", "本中继对外的地址，为空时不与其他中继互通")
+	keyPath := flag.String("key", "./relay.key", "中继身份密钥的保存路径")
+	upstream := flag.String("upstream", "", "目的中继不是直连中继时，消息交给该中继转发")
+	circuit := flag.Bool("circuit", false, "允许作为洋葱线路中的一跳")
+	var peers, circuitTargets peerFlags
+	flag.Var(&peers, "peer", "互通的中继，格式为 地址=身份公钥，可以重复指定")
+	flag.Var(&circuitTargets, "circuit-target", "洋葱线路允许连接的中继或对端地址，可以重复指定")
+	flag.Parse()
+
+	var store relay.Store = relay.NewMemoryStore()
+	if *storeDirectory != "" {
+		fileStore, err := relay.NewFileStore(*storeDirectory)
+		if err != nil {
+			log.Fatalf("❌ 打开邮箱目录失败: %s\n", err.Error())
+		}
+		store = fileStore
+	}
+	server := relay.NewServer(*listenAddress, store)
+	server.MaxMessages = *maxMessages
+	server.MaxBytes = *maxBytes
+	server.DefaultTTL = *ttl
+	if *host != "" || *circuit {
+		identityKey, err := utils.LoadIdentityKey(*keyPath)
+		if err != nil {
+			log.Fatalf("❌ 读取中继身份密钥失败: %s\n", err.Error())
+		}
+		server.Host = *host
+		server.IdentityKey = identityKey
+		server.Upstream = *upstream
+		server.AllowCircuits = *circuit
+		server.CircuitTargets = circuitTargets
+		log.Printf("🔑 中继身份公钥: %s\n", hex.EncodeToString(identityKey.PublicKey.Bytes()))
+	}
+	for _, peer := range peers {
+		peerHost, peerKey, _ := strings.Cut(peer, "=")
+		keyBytes, err := hex.DecodeString(peerKey)
+		if err == nil {
+			err = server.AddPeer(peerHost, keyBytes)
+		}
+		if err != nil {
+			log.Fatalf("❌ 中继 %s 的身份公钥无效: %s\n", peerHost, err.Error())
+		}
+	}
+	if err := server.StartServer(); err != nil {
+		log.Fatalf("❌ 启动中继服务失败: %s\n", err.Error())
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	<-signalChan
+	server.StopServer()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/reagin/double_ratchet/rendezvous"
+)
+
+func main() {
+	listenAddress := flag.String("listen", "0.0.0.0:7000", "rendezvous 服务监听地址")
+	flag.Parse()
+
+	server := rendezvous.NewServer(*listenAddress)
+	if err := server.StartServer(); err != nil {
+		log.Fatalf("❌ 启动 rendezvous 服务失败: %s\n", err.Error())
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	<-signalChan
+	server.StopServer()
+}
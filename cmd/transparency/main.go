@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/reagin/double_ratchet/transparency"
+	"github.com/reagin/double_ratchet/utils"
+)
+
+func main() {
+	listenAddress := flag.String("listen", "0.0.0.0:7400", "密钥透明日志监听地址")
+	storePath := flag.String("store", "", "日志叶子的保存文件，为空时保存在内存中")
+	keyPath := flag.String("key", "./log.key", "签名树头使用的密钥的保存路径")
+	flag.Parse()
+
+	logKey, err := utils.LoadIdentityKey(*keyPath)
+	if err != nil {
+		log.Fatalf("❌ 读取日志签名密钥失败: %s\n", err.Error())
+	}
+	var store transparency.Store = transparency.NewMemoryStore()
+	if *storePath != "" {
+		store = transparency.NewFileStore(*storePath)
+	}
+	server := transparency.NewServer(*listenAddress, store, logKey)
+	if err := server.StartServer(); err != nil {
+		log.Fatalf("❌ 启动密钥透明日志失败: %s\n", err.Error())
+	}
+	log.Printf("🔑 日志公钥: %s\n", hex.EncodeToString(logKey.PublicKey.Bytes()))
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	<-signalChan
+	server.StopServer()
+}
@@ -0,0 +1,254 @@
+package contact
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	Unknown    = iota // 尚未记录对端的身份公钥
+	Unverified        // 首次使用时记录了身份公钥，尚未人工验证
+	Verified          // 已通过安全码或短认证字符串验证
+	KeyChanged        // 身份公钥与记录不一致，需要重新验证
+)
+
+var ErrContactExists = errors.New("联系人已存在")
+var ErrContactNotFound = errors.New("联系人不存在")
+var ErrKeyChanged = errors.New("联系人的身份公钥发生了变化")
+
+// 联系人信息，IdentityKey 为首次使用时固定的身份公钥
+type Contact struct {
+	Nickname    string
+	Addresses   []string
+	IdentityKey []byte
+	Status      int
+	FirstSeen   time.Time
+	LastSeen    time.Time
+}
+
+// 保存在 JSON 文件中的联系人列表，身份公钥采用首次使用即信任 (TOFU) 的方式固定；
+// 只有对端身份经过认证的会话 (Noise 握手) 才会出示身份公钥，经典握手和配对码握手
+// 的会话无法按身份公钥匹配联系人，也不受 TOFU 保护
+type Book struct {
+	mutex    sync.Mutex
+	path     string
+	contacts map[string]*Contact
+	block    bool // 身份公钥变化时拒绝会话，否则仅发出警告并更新公钥
+}
+
+// 联系人文件的格式
+type bookFile struct {
+	Block    bool
+	Contacts []*Contact
+}
+
+// 打开联系人文件，文件不存在时创建空的联系人列表
+func NewBook(path string) (*Book, error) {
+	book := &Book{
+		path:     path,
+		contacts: make(map[string]*Contact),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return book, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file := bookFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	book.block = file.Block
+	for _, contact := range file.Contacts {
+		book.contacts[contact.Nickname] = contact
+	}
+	return book, nil
+}
+
+func (book *Book) Add(nickname string, addresses ...string) error {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	if _, ok := book.contacts[nickname]; ok {
+		return ErrContactExists
+	}
+	book.contacts[nickname] = &Contact{
+		Nickname:  nickname,
+		Addresses: addresses,
+		Status:    Unknown,
+	}
+	return book.save()
+}
+
+func (book *Book) Remove(nickname string) error {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	if _, ok := book.contacts[nickname]; !ok {
+		return ErrContactNotFound
+	}
+	delete(book.contacts, nickname)
+	return book.save()
+}
+
+// 返回联系人的副本，不存在时返回 nil
+func (book *Book) Get(nickname string) *Contact {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	if contact, ok := book.contacts[nickname]; ok {
+		return contact.clone()
+	}
+	return nil
+}
+
+// 按昵称排序返回所有联系人的副本
+func (book *Book) Contacts() []*Contact {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	contacts := make([]*Contact, 0, len(book.contacts))
+	for _, contact := range book.contacts {
+		contacts = append(contacts, contact.clone())
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].Nickname < contacts[j].Nickname
+	})
+	return contacts
+}
+
+// 根据地址查找联系人，主机与端口都必须一致；
+// 同一主机上可能运行着多个不同身份的节点，只比较主机会把它们当作同一联系人
+func (book *Book) FindByAddress(address string) *Contact {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	for _, contact := range book.contacts {
+		for _, known := range contact.Addresses {
+			if known == address {
+				return contact.clone()
+			}
+		}
+	}
+	return nil
+}
+
+// 根据身份公钥查找联系人，同一身份从不同地址连接时仍能对应到同一联系人
+func (book *Book) FindByIdentity(identityKey []byte) *Contact {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	if len(identityKey) == 0 {
+		return nil
+	}
+	for _, contact := range book.contacts {
+		if bytes.Equal(contact.IdentityKey, identityKey) {
+			return contact.clone()
+		}
+	}
+	return nil
+}
+
+func (book *Book) Blocked() bool {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	return book.block
+}
+
+// 设置身份公钥变化时是否拒绝会话，并写入联系人文件
+func (book *Book) SetBlock(block bool) error {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	book.block = block
+	return book.save()
+}
+
+// 检查联系人出示的身份公钥：首次出现时固定该公钥，与记录一致时通过，
+// 不一致时发出警告，开启 SetBlock 时返回 ErrKeyChanged 拒绝会话
+func (book *Book) CheckIdentity(nickname string, identityKey []byte) (int, error) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	contact, ok := book.contacts[nickname]
+	if !ok {
+		return Unknown, ErrContactNotFound
+	}
+	contact.LastSeen = time.Now()
+
+	switch {
+	case len(contact.IdentityKey) == 0:
+		log.Printf("📌 首次记录联系人 %s 的身份公钥\n", nickname)
+		contact.IdentityKey = append([]byte(nil), identityKey...)
+		contact.Status = Unverified
+		contact.FirstSeen = contact.LastSeen
+	case !bytes.Equal(contact.IdentityKey, identityKey):
+		log.Printf("🚨 警告: 联系人 %s 的身份公钥发生了变化，可能存在中间人攻击!\n", nickname)
+		if book.block {
+			contact.Status = KeyChanged
+			if err := book.save(); err != nil {
+				return KeyChanged, err
+			}
+			return KeyChanged, ErrKeyChanged
+		}
+		// 接受新的公钥，但在重新验证之前一直保持警告状态
+		contact.IdentityKey = append([]byte(nil), identityKey...)
+		contact.Status = KeyChanged
+	}
+
+	return contact.Status, book.save()
+}
+
+// 将联系人标记为已验证，identityKey 必须与固定的公钥一致
+func (book *Book) MarkVerified(nickname string, identityKey []byte) error {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	contact, ok := book.contacts[nickname]
+	if !ok {
+		return ErrContactNotFound
+	}
+	if !bytes.Equal(contact.IdentityKey, identityKey) {
+		return ErrKeyChanged
+	}
+	contact.Status = Verified
+	return book.save()
+}
+
+// 先写入临时文件再重命名，避免写入中断导致联系人文件损坏
+func (book *Book) save() error {
+	contacts := make([]*Contact, 0, len(book.contacts))
+	for _, contact := range book.contacts {
+		contacts = append(contacts, contact)
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		return contacts[i].Nickname < contacts[j].Nickname
+	})
+
+	data, err := json.MarshalIndent(bookFile{Block: book.block, Contacts: contacts}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempPath := book.path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, book.path)
+}
+
+func (contact *Contact) clone() *Contact {
+	copied := *contact
+	copied.Addresses = append([]string(nil), contact.Addresses...)
+	copied.IdentityKey = append([]byte(nil), contact.IdentityKey...)
+	return &copied
+}
@@ -0,0 +1,134 @@
+package contact
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func newTestBook(t *testing.T) (*Book, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "contacts.json")
+	book, err := NewBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := book.Add("alice", "10.0.0.1:7000"); err != nil {
+		t.Fatal(err)
+	}
+	return book, path
+}
+
+func reopenBook(t *testing.T, path string) *Book {
+	t.Helper()
+
+	book, err := NewBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return book
+}
+
+// 首次出示的公钥被固定，之后相同的公钥通过，不同的公钥发出警告并替换
+func TestCheckIdentity(t *testing.T) {
+	book, path := newTestBook(t)
+	first, second := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	if status, err := book.CheckIdentity("alice", first); status != Unverified || err != nil {
+		t.Fatalf("first use: got status %d, err %v", status, err)
+	}
+	if status, err := book.CheckIdentity("alice", first); status != Unverified || err != nil {
+		t.Fatalf("same key: got status %d, err %v", status, err)
+	}
+	if status, err := book.CheckIdentity("alice", second); status != KeyChanged || err != nil {
+		t.Fatalf("changed key: got status %d, err %v", status, err)
+	}
+	if status, err := book.CheckIdentity("bob", first); status != Unknown || err != ErrContactNotFound {
+		t.Fatalf("unknown contact: got status %d, err %v", status, err)
+	}
+
+	contact := reopenBook(t, path).Get("alice")
+	if contact == nil || !bytes.Equal(contact.IdentityKey, second) || contact.Status != KeyChanged {
+		t.Fatalf("changed key was not saved: %+v", contact)
+	}
+}
+
+// 开启 SetBlock 后公钥变化时拒绝会话，固定的公钥保持不变，设置写入联系人文件
+func TestSetBlock(t *testing.T) {
+	book, path := newTestBook(t)
+	first, second := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	if err := book.SetBlock(true); err != nil {
+		t.Fatal(err)
+	}
+	if !book.Blocked() {
+		t.Fatal("block was not enabled")
+	}
+	if _, err := book.CheckIdentity("alice", first); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := book.CheckIdentity("alice", second); status != KeyChanged || err != ErrKeyChanged {
+		t.Fatalf("changed key: got status %d, err %v", status, err)
+	}
+
+	reopened := reopenBook(t, path)
+	if !reopened.Blocked() {
+		t.Fatal("block setting was not saved")
+	}
+	contact := reopened.Get("alice")
+	if contact == nil || !bytes.Equal(contact.IdentityKey, first) || contact.Status != KeyChanged {
+		t.Fatalf("pinned key was replaced: %+v", contact)
+	}
+
+	if err := reopened.SetBlock(false); err != nil {
+		t.Fatal(err)
+	}
+	if reopenBook(t, path).Blocked() {
+		t.Fatal("block setting was not cleared")
+	}
+}
+
+// 只有与固定公钥一致的身份才能被标记为已验证，已验证的状态在之后的检查中保持
+func TestMarkVerified(t *testing.T) {
+	book, path := newTestBook(t)
+	first, second := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	if _, err := book.CheckIdentity("alice", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.MarkVerified("alice", second); err != ErrKeyChanged {
+		t.Fatalf("wrong key: got %v, expected %v", err, ErrKeyChanged)
+	}
+	if err := book.MarkVerified("bob", first); err != ErrContactNotFound {
+		t.Fatalf("unknown contact: got %v, expected %v", err, ErrContactNotFound)
+	}
+	if err := book.MarkVerified("alice", first); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := book.CheckIdentity("alice", first); status != Verified || err != nil {
+		t.Fatalf("verified key: got status %d, err %v", status, err)
+	}
+	if contact := reopenBook(t, path).Get("alice"); contact == nil || contact.Status != Verified {
+		t.Fatalf("verified status was not saved: %+v", contact)
+	}
+
+	// 验证过的公钥发生变化时重新进入警告状态
+	if status, _ := book.CheckIdentity("alice", second); status != KeyChanged {
+		t.Fatalf("changed key after verification: got status %d", status)
+	}
+}
+
+// 地址的主机与端口都必须一致，同一主机上的其他端口不是同一联系人
+func TestFindByAddress(t *testing.T) {
+	book, _ := newTestBook(t)
+
+	if contact := book.FindByAddress("10.0.0.1:7000"); contact == nil || contact.Nickname != "alice" {
+		t.Fatalf("exact address: got %+v", contact)
+	}
+	for _, address := range []string{"10.0.0.1", "10.0.0.1:7001", "10.0.0.2:7000"} {
+		if contact := book.FindByAddress(address); contact != nil {
+			t.Fatalf("%s matched %s", address, contact.Nickname)
+		}
+	}
+}
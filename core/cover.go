@@ -0,0 +1,203 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	CoverConstant = iota // 以固定间隔发送帧
+	CoverPoisson         // 发送间隔服从指数分布，即按泊松过程发送帧
+)
+
+const (
+	coverHeaderSize = 2       // 帧内数据长度字段的字节数
+	coverMaxPending = 1 << 20 // 等待发送的队列的最大字节数
+)
+
+var ErrCoverConfig = errors.New("掩护流量的帧大小或带宽预算无效")
+var ErrCoverFrame = errors.New("掩护流量帧认证失败")
+var ErrCoverClosed = errors.New("掩护流量层已关闭")
+
+// 掩护流量配置，双方需要使用相同的帧大小
+type CoverConfig struct {
+	Mode      int // CoverConstant 或 CoverPoisson
+	FrameSize int // 每个帧在线路上的字节数
+	Bandwidth int // 每个方向的带宽预算 (字节/秒)，决定发送帧的平均速率
+}
+
+// 会话建立后替代原连接的掩护流量层：写入的数据进入队列，
+// 由调度器按配置的速率填入固定大小的加密帧，队列为空时发送空帧，接收方认证后丢弃空帧；
+// 队列达到 coverMaxPending 后写入阻塞，直到调度器取走数据，发送速率受带宽预算限制
+type coverConn struct {
+	reader     io.Reader
+	writer     io.Writer
+	config     *CoverConfig
+	send       cipher.AEAD
+	recv       cipher.AEAD
+	sendNonce  uint64
+	recvNonce  uint64
+	mutex      sync.Mutex
+	drained    *sync.Cond // 调度器取走数据或连接关闭时通知阻塞的写入
+	closed     bool
+	pending    []byte
+	readBuffer []byte
+}
+
+func NewCoverConfig(bandwidth int) *CoverConfig {
+	return &CoverConfig{
+		Mode:      CoverConstant,
+		FrameSize: 512,
+		Bandwidth: bandwidth,
+	}
+}
+
+// 两个帧之间的平均间隔为 FrameSize / Bandwidth 秒
+func (config *CoverConfig) nextInterval() time.Duration {
+	interval := float64(config.FrameSize) / float64(config.Bandwidth) * float64(time.Second)
+	if config.Mode == CoverPoisson {
+		interval *= rand.ExpFloat64()
+	}
+	return time.Duration(interval)
+}
+
+// secret 为握手导出的掩护流量层密钥，由此派生两个方向的帧密钥；
+// reader 为握手使用的 bufio.Reader，其中可能已经缓存了对端的第一个帧
+func newCoverConn(reader io.Reader, writer io.Writer, config *CoverConfig, secret []byte, isInitiator bool) (*coverConn, error) {
+	if config.Bandwidth <= 0 || config.FrameSize <= coverHeaderSize+16 || config.FrameSize > 0xffff {
+		return nil, ErrCoverConfig
+	}
+	initiatorKey, responderKey := utils.DevirateChainKey(secret, []byte("DoubleRatchetDemo cover"))
+	if !isInitiator {
+		initiatorKey, responderKey = responderKey, initiatorKey
+	}
+	send, err := newCoverAEAD(initiatorKey)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := newCoverAEAD(responderKey)
+	if err != nil {
+		return nil, err
+	}
+	conn := &coverConn{
+		reader: reader,
+		writer: writer,
+		config: config,
+		send:   send,
+		recv:   recv,
+	}
+	conn.drained = sync.NewCond(&conn.mutex)
+	return conn, nil
+}
+
+func newCoverAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func coverNonce(counter *uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], *counter)
+	*counter++
+	return nonce
+}
+
+// 写入的数据只进入队列，实际发送由 handleCover 按速率完成；
+// 队列已满时等待调度器取走数据，连接关闭后返回 ErrCoverClosed
+func (conn *coverConn) Write(b []byte) (int, error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	written := 0
+	for written < len(b) {
+		for !conn.closed && len(conn.pending) >= coverMaxPending {
+			conn.drained.Wait()
+		}
+		if conn.closed {
+			return written, ErrCoverClosed
+		}
+		n := min(coverMaxPending-len(conn.pending), len(b)-written)
+		conn.pending = append(conn.pending, b[written:written+n]...)
+		written += n
+	}
+	return written, nil
+}
+
+// 唤醒所有阻塞的写入，之后的写入立即失败
+func (conn *coverConn) close() {
+	conn.mutex.Lock()
+	conn.closed = true
+	conn.mutex.Unlock()
+	conn.drained.Broadcast()
+}
+
+// 读取固定大小的帧，认证后丢弃空帧
+func (conn *coverConn) Read(b []byte) (int, error) {
+	for len(conn.readBuffer) == 0 {
+		frame := make([]byte, conn.config.FrameSize)
+		if _, err := io.ReadFull(conn.reader, frame); err != nil {
+			return 0, err
+		}
+		plaintext, err := conn.recv.Open(nil, coverNonce(&conn.recvNonce), frame, nil)
+		if err != nil {
+			return 0, ErrCoverFrame
+		}
+		length := int(binary.BigEndian.Uint16(plaintext))
+		if coverHeaderSize+length > len(plaintext) {
+			return 0, ErrCoverFrame
+		}
+		conn.readBuffer = plaintext[coverHeaderSize : coverHeaderSize+length]
+	}
+	n := copy(b, conn.readBuffer)
+	conn.readBuffer = conn.readBuffer[n:]
+	return n, nil
+}
+
+// 从队列取出至多一个帧的数据并加密，队列为空时得到空帧
+func (conn *coverConn) nextFrame() []byte {
+	conn.mutex.Lock()
+	capacity := conn.config.FrameSize - conn.send.Overhead() - coverHeaderSize
+	data := conn.pending[:min(capacity, len(conn.pending))]
+	conn.pending = conn.pending[len(data):]
+	conn.mutex.Unlock()
+	conn.drained.Broadcast()
+
+	plaintext := make([]byte, conn.config.FrameSize-conn.send.Overhead())
+	binary.BigEndian.PutUint16(plaintext, uint16(len(data)))
+	copy(plaintext[coverHeaderSize:], data)
+	return conn.send.Seal(nil, coverNonce(&conn.sendNonce), plaintext, nil)
+}
+
+// 按配置的速率持续发送帧，直到会话结束
+func (session *Session) handleCover(conn *coverConn) {
+	defer session.waitGroup.Done()
+	defer conn.close()
+
+	timer := time.NewTimer(conn.config.nextInterval())
+	defer timer.Stop()
+	for {
+		select {
+		case <-session.stopChan:
+			return
+		case <-timer.C:
+		}
+		if _, err := conn.writer.Write(conn.nextFrame()); err != nil {
+			log.Printf("🤯 发送掩护流量失败: %s\n", err.Error())
+			go session.Stop()
+			return
+		}
+		timer.Reset(conn.config.nextInterval())
+	}
+}
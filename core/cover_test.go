@@ -0,0 +1,86 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func newTestCoverPair(t *testing.T) (*coverConn, *coverConn) {
+	t.Helper()
+
+	secret := bytes.Repeat([]byte{1}, 32)
+	reader, writer := io.Pipe()
+	t.Cleanup(func() { reader.Close() })
+	config := NewCoverConfig(1 << 20)
+	initiator, err := newCoverConn(nil, writer, config, secret, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, err := newCoverConn(reader, nil, config, secret, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return initiator, responder
+}
+
+func TestCoverRoundTrip(t *testing.T) {
+	initiator, responder := newTestCoverPair(t)
+
+	message := []byte("hello cover")
+	if _, err := initiator.Write(message); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		initiator.writer.Write(initiator.nextFrame())
+		initiator.writer.Write(initiator.nextFrame())
+	}()
+	received := make([]byte, len(message))
+	if _, err := io.ReadFull(responder, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, message) {
+		t.Fatalf("收到 %q，期望 %q", received, message)
+	}
+}
+
+func TestCoverBackpressure(t *testing.T) {
+	initiator, _ := newTestCoverPair(t)
+
+	if _, err := initiator.Write(make([]byte, coverMaxPending)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := initiator.Write([]byte("blocked"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("队列已满时写入没有阻塞: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	initiator.nextFrame()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	initiator.mutex.Lock()
+	pending := len(initiator.pending)
+	initiator.mutex.Unlock()
+	if pending > coverMaxPending {
+		t.Fatalf("队列长度 %d 超过上限 %d", pending, coverMaxPending)
+	}
+
+	go func() {
+		_, err := initiator.Write(make([]byte, coverMaxPending))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	initiator.close()
+	if err := <-done; !errors.Is(err, ErrCoverClosed) {
+		t.Fatalf("关闭后写入返回 %v，期望 %v", err, ErrCoverClosed)
+	}
+}
@@ -0,0 +1,351 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	ClassicHandshake = iota // 先承诺后公开的未认证公钥交换
+	NoiseHandshake          // Noise XX 握手，已知对端身份公钥时使用 IK 握手
+)
+
+// 握手帧的最大长度，握手完成前不为对端声明的任意长度分配内存
+const maxHandshakeFrame = 64 << 10
+
+// Noise 握手的序言，绑定本协议避免握手记录被挪作他用
+var noisePrologue = []byte("DoubleRatchetDemo")
+
+// Noise 发起方的第一帧以模式标识开头，便于响应方选择对应的握手模式
+var noisePatternIDs = map[string]byte{
+	utils.NoiseXX: 1,
+	utils.NoiseIK: 2,
+}
+
+var ErrHandshakeMode = errors.New("对端使用了不同的握手模式")
+var ErrKeyConfirmation = errors.New("密钥确认失败，双方得到的 RootChain 不一致")
+var ErrProtocolViolation = errors.New("对端违反协议")
+var ErrKeyCommitment = errors.New("对端公开的公钥与承诺值不一致")
+var ErrRemoteIdentity = errors.New("对端的身份公钥与预期不一致")
+
+// 会话建立时使用的握手配置
+type HandshakeConfig struct {
+	Mode           int                        // ClassicHandshake 或 NoiseHandshake
+	IdentityKey    *utils.DiffeHellmanKeyPair // 长期身份密钥，作为 Noise 握手的静态密钥
+	RemoteIdentity []byte                     // 已知的对端身份公钥，发起方据此使用 IK 握手，对端身份不一致时握手失败
+	PairingCode    string                     // 双方约定的配对码，非空时在握手后执行 CPace 配对
+	PSK            []byte                     // 带外分发的预共享密钥，非空时混入 RootChain
+	Cover          *CoverConfig               // 非 nil 时以固定速率发送掩护流量，双方需要同时启用
+	// 握手认证对端身份后调用，返回错误时拒绝建立会话
+	CheckIdentity func(session *Session) error
+}
+
+func NewHandshakeConfig() HandshakeConfig {
+	return HandshakeConfig{
+		Mode:        ClassicHandshake,
+		IdentityKey: utils.NewDiffeHellmanKeyPair(),
+	}
+}
+
+// 发送握手帧并记入握手记录
+func (session *Session) writeHandshakeFrame(writer *bufio.Writer, data []byte) error {
+	session.absorbTranscript(data)
+	return writeFrame(writer, data)
+}
+
+// 接收握手帧并记入握手记录
+func (session *Session) readHandshakeFrame(reader *bufio.Reader) ([]byte, error) {
+	data, err := utils.DecodeMessageLimit(reader, maxHandshakeFrame)
+	if err != nil {
+		return nil, err
+	}
+	session.absorbTranscript(data)
+	return data, nil
+}
+
+// 双方按相同的顺序收发握手帧，因此得到相同的握手记录
+func (session *Session) absorbTranscript(data []byte) {
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(data)))
+	session.transcript.Write(length)
+	session.transcript.Write(data)
+}
+
+// 完成握手并设置初始 RootChain，返回后续棘轮使用的本地密钥对与对端公钥
+func (session *Session) handshake(reader *bufio.Reader, writer *bufio.Writer, ratchetState *utils.RatchetState) (*utils.DiffeHellmanKeyPair, *ecdh.PublicKey, error) {
+	var keyPair *utils.DiffeHellmanKeyPair
+	var remotePubKey *ecdh.PublicKey
+	var err error
+	if session.config.Mode == NoiseHandshake {
+		keyPair, remotePubKey, err = session.noiseHandshake(reader, writer, ratchetState)
+	} else {
+		keyPair, remotePubKey, err = session.classicHandshake(reader, writer, ratchetState)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// 响应方或经典握手无法由 IK 模式保证对端身份，在这里统一比较
+	if len(session.config.RemoteIdentity) > 0 && !bytes.Equal(session.RemoteIdentity, session.config.RemoteIdentity) {
+		return nil, nil, ErrRemoteIdentity
+	}
+
+	if session.config.PairingCode != "" {
+		if err := session.pairingHandshake(reader, writer, ratchetState); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(session.config.PSK) > 0 {
+		if err := session.pskHandshake(reader, writer, ratchetState); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := session.confirmKey(reader, writer, ratchetState); err != nil {
+		return nil, nil, err
+	}
+	if session.config.Cover != nil {
+		if session.coverSecret, err = session.exportKey(ratchetState, "cover"); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keyPair, remotePubKey, nil
+}
+
+// 交换两组公钥：第一组计算初始 RootChain，第二组作为后续棘轮使用的公钥。
+// 发起方先发送公钥的承诺值，响应方公开公钥后发起方再公开公钥，任何一方都无法在看到
+// 对端公钥后挑选自己的公钥，中间人因此无法穷举公钥使两端的短认证字符串相同
+func (session *Session) classicHandshake(reader *bufio.Reader, writer *bufio.Writer, ratchetState *utils.RatchetState) (*utils.DiffeHellmanKeyPair, *ecdh.PublicKey, error) {
+	// 生成 Diffe-Hellman 密钥对
+	rootKeyPair := utils.NewDiffeHellmanKeyPair()
+	keyPair := utils.NewDiffeHellmanKeyPair()
+	publicKeys := append(rootKeyPair.PublicKey.Bytes(), keyPair.PublicKey.Bytes()...)
+
+	var remoteKeys []byte
+	if session.isInitiator {
+		if err := session.writeHandshakeFrame(writer, keyCommitment(publicKeys)); err != nil {
+			return nil, nil, err
+		}
+		remote, err := session.readHandshakeFrame(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := session.writeHandshakeFrame(writer, publicKeys); err != nil {
+			return nil, nil, err
+		}
+		remoteKeys = remote
+	} else {
+		commitment, err := session.readHandshakeFrame(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		// 对端发送的数据不是承诺值时说明双方的握手模式不同
+		if len(commitment) != sha256.Size {
+			return nil, nil, ErrHandshakeMode
+		}
+		if err := session.writeHandshakeFrame(writer, publicKeys); err != nil {
+			return nil, nil, err
+		}
+		if remoteKeys, err = session.readHandshakeFrame(reader); err != nil {
+			return nil, nil, err
+		}
+		if !hmac.Equal(commitment, keyCommitment(remoteKeys)) {
+			return nil, nil, ErrKeyCommitment
+		}
+	}
+	if len(remoteKeys) != len(publicKeys) {
+		return nil, nil, ErrHandshakeMode
+	}
+
+	remoteRootKey, err := utils.BytesToPublicKey(remoteKeys[:32])
+	if err != nil {
+		return nil, nil, protocolViolation(err)
+	}
+	remotePubKey, err := utils.BytesToPublicKey(remoteKeys[32:])
+	if err != nil {
+		return nil, nil, protocolViolation(err)
+	}
+	// 计算共享密钥，结果为全零时 ECDH 返回错误
+	sharedSecret, err := rootKeyPair.PrivateKey.ECDH(remoteRootKey)
+	if err != nil {
+		return nil, nil, protocolViolation(err)
+	}
+	ratchetState.RootChain = sharedSecret
+
+	return keyPair, remotePubKey, nil
+}
+
+// 对公钥的承诺值
+func keyCommitment(publicKeys []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte("DoubleRatchetDemo key commitment"))
+	hash.Write(publicKeys)
+	return hash.Sum(nil)
+}
+
+// 发起方先发送再接收，响应方先接收再发送
+func (session *Session) exchangeHandshakeFrame(reader *bufio.Reader, writer *bufio.Writer, data []byte) ([]byte, error) {
+	if session.isInitiator {
+		if err := session.writeHandshakeFrame(writer, data); err != nil {
+			return nil, err
+		}
+	}
+
+	remoteData, err := session.readHandshakeFrame(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if !session.isInitiator {
+		if err := session.writeHandshakeFrame(writer, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return remoteData, nil
+}
+
+// 使用 Noise 握手认证双方身份，棘轮公钥随加密的握手负载一同交换
+func (session *Session) noiseHandshake(reader *bufio.Reader, writer *bufio.Writer, ratchetState *utils.RatchetState) (*utils.DiffeHellmanKeyPair, *ecdh.PublicKey, error) {
+	var noise *utils.NoiseHandshake
+	var err error
+	pattern := utils.NoiseXX
+
+	if session.isInitiator {
+		if len(session.config.RemoteIdentity) > 0 {
+			pattern = utils.NoiseIK
+		}
+		noise, err = utils.NewNoiseHandshake(pattern, true, noisePrologue, session.config.IdentityKey, session.config.RemoteIdentity)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	keyPair := utils.NewDiffeHellmanKeyPair()
+	var remotePubKey *ecdh.PublicKey
+	isFirstFrame := true
+
+	for noise == nil || !noise.IsComplete() {
+		if noise != nil && noise.IsMyTurn() {
+			// XX 的第一条消息尚未协商出密钥，不携带负载
+			var payload []byte
+			if !(pattern == utils.NoiseXX && isFirstFrame) {
+				payload = keyPair.PublicKey.Bytes()
+			}
+			message, err := noise.WriteMessage(payload)
+			if err != nil {
+				return nil, nil, err
+			}
+			if isFirstFrame {
+				message = append([]byte{noisePatternIDs[pattern]}, message...)
+			}
+			if err := session.writeHandshakeFrame(writer, message); err != nil {
+				return nil, nil, err
+			}
+			isFirstFrame = false
+			continue
+		}
+
+		message, err := session.readHandshakeFrame(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if noise == nil {
+			// 响应方根据第一帧的模式标识创建握手状态
+			if len(message) == 32 || len(message) == 0 {
+				return nil, nil, ErrHandshakeMode
+			}
+			pattern = ""
+			for name, id := range noisePatternIDs {
+				if id == message[0] {
+					pattern = name
+				}
+			}
+			noise, err = utils.NewNoiseHandshake(pattern, false, noisePrologue, session.config.IdentityKey, nil)
+			if err != nil {
+				return nil, nil, ErrHandshakeMode
+			}
+			message = message[1:]
+		}
+		isFirstFrame = false
+
+		payload, err := noise.ReadMessage(message)
+		if errors.Is(err, utils.ErrInvalidPublicKey) {
+			return nil, nil, protocolViolation(err)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(payload) > 0 {
+			if remotePubKey, err = utils.BytesToPublicKey(payload); err != nil {
+				return nil, nil, protocolViolation(err)
+			}
+		}
+	}
+	if remotePubKey == nil {
+		return nil, nil, utils.ErrNoiseMessage
+	}
+
+	// 握手哈希是公开的握手记录摘要，与只有双方知道的链密钥共同派生 RootChain
+	ratchetState.RootChain, _ = utils.DevirateChainKey(noise.ChainingKey(), noise.HandshakeHash())
+	session.RemoteIdentity = noise.RemoteStatic()
+
+	return keyPair, remotePubKey, nil
+}
+
+// 对端发送了无效的公钥或导致 ECDH 结果可被预测，握手随之终止
+func protocolViolation(err error) error {
+	return fmt.Errorf("%w: %w", ErrProtocolViolation, err)
+}
+
+// 握手的最后一轮：以 RootChain 派生的密钥对完整握手记录计算 MAC 并交换，
+// 双方确认得到了相同的 RootChain 后会话才算建立
+func (session *Session) confirmKey(reader *bufio.Reader, writer *bufio.Writer, ratchetState *utils.RatchetState) error {
+	confirmKey, err := hkdf.Key(sha256.New, ratchetState.RootChain, nil, "DoubleRatchetDemo key confirmation", 32)
+	if err != nil {
+		return err
+	}
+
+	isConfirmed, err := session.exchangeConfirm(reader, writer, confirmKey, session.transcript.Sum(nil), "key confirmation")
+	if err != nil {
+		return err
+	}
+	if !isConfirmed {
+		return ErrKeyConfirmation
+	}
+	return nil
+}
+
+// 由握手完成时的 RootChain 与握手记录导出供其他层使用的密钥，不同用途使用不同的标签，
+// 导出的密钥与之后的棘轮状态相互独立
+func (session *Session) exportKey(ratchetState *utils.RatchetState, label string) ([]byte, error) {
+	return hkdf.Key(sha256.New, ratchetState.RootChain, session.transcript.Sum(nil), "DoubleRatchetDemo exporter "+label, 32)
+}
+
+// 交换以 key 计算的确认消息，双方都发送后再校验，使两端都能得知确认失败
+func (session *Session) exchangeConfirm(reader *bufio.Reader, writer *bufio.Writer, key, sid []byte, label string) (bool, error) {
+	remoteConfirm, err := session.exchangeHandshakeFrame(reader, writer, confirmMAC(key, sid, label, session.isInitiator))
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal(remoteConfirm, confirmMAC(key, sid, label, !session.isInitiator)), nil
+}
+
+// 计算确认消息，发起方与响应方使用不同的标签避免反射攻击
+func confirmMAC(key, sid []byte, label string, isInitiator bool) []byte {
+	role := " responder"
+	if isInitiator {
+		role = " initiator"
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("DoubleRatchetDemo " + label + role))
+	mac.Write(sid)
+	return mac.Sum(nil)
+}
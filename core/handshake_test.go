@@ -0,0 +1,78 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 双方的 RootChain 不一致时密钥确认在两端都失败
+func TestKeyConfirmationMismatch(t *testing.T) {
+	initiatorConn, responderConn := pipeConnPair(t)
+	config := NewHandshakeConfig()
+
+	confirm := func(connect net.Conn, isInitiator bool, rootChain []byte, result chan error) {
+		session := newSession(connect, isInitiator, &config, nil, nil)
+		ratchetState := utils.NewRatchetState()
+		ratchetState.RootChain = rootChain
+		go func() {
+			result <- session.confirmKey(bufio.NewReader(connect), bufio.NewWriter(connect), ratchetState)
+		}()
+	}
+	initiatorResult := make(chan error, 1)
+	responderResult := make(chan error, 1)
+	confirm(initiatorConn, true, bytes.Repeat([]byte{0x01}, 32), initiatorResult)
+	confirm(responderConn, false, bytes.Repeat([]byte{0x02}, 32), responderResult)
+
+	if err := <-initiatorResult; err != ErrKeyConfirmation {
+		t.Fatalf("发起方返回 %v，期望 %v", err, ErrKeyConfirmation)
+	}
+	if err := <-responderResult; err != ErrKeyConfirmation {
+		t.Fatalf("响应方返回 %v，期望 %v", err, ErrKeyConfirmation)
+	}
+}
+
+// 客户端完成握手后以不同的 RootChain 发送确认消息，服务端拒绝会话并关闭连接
+func TestKeyConfirmationTearsDownSession(t *testing.T) {
+	transport := NewPipeTransport()
+	server := NewServer("server")
+	server.Transport = transport
+	server.StartServer()
+	defer server.StopServer()
+
+	var connect net.Conn
+	waitFor(t, func() bool {
+		var err error
+		connect, err = transport.Dial("client", "server")
+		return err == nil
+	})
+	defer connect.Close()
+	config := NewHandshakeConfig()
+	session := newSession(connect, true, &config, nil, nil)
+	reader := bufio.NewReader(connect)
+	writer := bufio.NewWriter(connect)
+	ratchetState := utils.NewRatchetState()
+	connect.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, _, err := session.classicHandshake(reader, writer, ratchetState); err != nil {
+		t.Fatal(err)
+	}
+	ratchetState.RootChain = bytes.Repeat([]byte{0xff}, 32)
+	if err := session.confirmKey(reader, writer, ratchetState); err != ErrKeyConfirmation {
+		t.Fatalf("客户端返回 %v，期望 %v", err, ErrKeyConfirmation)
+	}
+
+	// 服务端中止会话后连接被关闭，读取不会超时
+	if _, err := reader.ReadByte(); err == nil {
+		t.Fatal("密钥确认失败后连接仍可读取")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatal("密钥确认失败后服务端没有关闭连接")
+	}
+	if len(server.Peers()) != 0 {
+		t.Fatal("密钥确认失败后服务端仍保留会话")
+	}
+}
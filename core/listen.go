@@ -30,7 +30,12 @@ func startSendListener(isStop chan bool, wg *sync.WaitGroup, sendChannel chan []
 			case utils.Sender:
 				// Sender: 如果SendCount等于RecvCount，则推进RootChain
 				if ratchetState.SendCount == ratchetState.RecvCount {
-					sharedSecret, _ := keyPair.PrivateKey.ECDH(*remotePubKey)
+					sharedSecret, err := keyPair.PrivateKey.ECDH(*remotePubKey)
+					if err != nil {
+						log.Printf("🚨 对端违反协议: %s\n", err.Error())
+						ratchetState.Mutex.Unlock()
+						return
+					}
 					leftKey, rightKey := utils.DevirateChainKey(ratchetState.RootChain, sharedSecret)
 					// 迭代RootChain
 					ratchetState.RootChain = leftKey
@@ -47,6 +52,7 @@ func startSendListener(isStop chan bool, wg *sync.WaitGroup, sendChannel chan []
 				nonce, ciphertext, err := utils.EncryptAESGCM(keyChain.MessageKey[keyChain.Count], message)
 				if err != nil {
 					log.Printf("❌ 加密信息失败: %x\n", message)
+					ratchetState.Mutex.Unlock()
 					return
 				}
 				// 组织棘轮信息结构
@@ -60,6 +66,7 @@ func startSendListener(isStop chan bool, wg *sync.WaitGroup, sendChannel chan []
 				message, _ = utils.EncodeMessage(message)
 				if _, err := writer.Write(message); err != nil {
 					log.Printf("🤯 发送信息失败: %s\n", err.Error())
+					ratchetState.Mutex.Unlock()
 					return
 				}
 				writer.Flush()
@@ -69,7 +76,12 @@ func startSendListener(isStop chan bool, wg *sync.WaitGroup, sendChannel chan []
 					// 更新DiffeHellman密钥对
 					keyPair.UpdateKeyPair()
 
-					sharedSecret, _ := keyPair.PrivateKey.ECDH(*remotePubKey)
+					sharedSecret, err := keyPair.PrivateKey.ECDH(*remotePubKey)
+					if err != nil {
+						log.Printf("🚨 对端违反协议: %s\n", err.Error())
+						ratchetState.Mutex.Unlock()
+						return
+					}
 					leftKey, rightKey := utils.DevirateChainKey(ratchetState.RootChain, sharedSecret)
 					// 迭代RootChain
 					ratchetState.RootChain = leftKey
@@ -86,6 +98,7 @@ func startSendListener(isStop chan bool, wg *sync.WaitGroup, sendChannel chan []
 				nonce, ciphertext, err := utils.EncryptAESGCM(keyChain.MessageKey[keyChain.Count], message)
 				if err != nil {
 					log.Printf("❌ 加密信息失败: %x\n", message)
+					ratchetState.Mutex.Unlock()
 					return
 				}
 				// 组织棘轮信息结构
@@ -99,6 +112,7 @@ func startSendListener(isStop chan bool, wg *sync.WaitGroup, sendChannel chan []
 				message, _ = utils.EncodeMessage(message)
 				if _, err := writer.Write(message); err != nil {
 					log.Printf("🤯 发送信息失败: %s\n", err.Error())
+					ratchetState.Mutex.Unlock()
 					return
 				}
 				writer.Flush()
@@ -132,20 +146,33 @@ func startRecvListener(isStop chan bool, wg *sync.WaitGroup, recvChannel chan []
 				log.Printf("🤯 解析信息失败: %s\n", err.Error())
 				return
 			}
-			*remotePubKey = utils.BytesToPublicKey(ratchetMsg.PublicKey)
-
+			// 对端的棘轮公钥无效时视为违反协议，结束会话
+			remoteKey, err := utils.BytesToPublicKey(ratchetMsg.PublicKey)
+			if err != nil {
+				log.Printf("🚨 对端违反协议: %s\n", err.Error())
+				return
+			}
 			// 增加互斥锁，避免竞争
 			ratchetState.Mutex.Lock()
+			// 对端公钥与棘轮状态一同在互斥锁内更新，发送监听器在锁内读取
+			*remotePubKey = remoteKey
 			// 根据初始棘轮状态判断当前是 Sender 还是 Receiver
 			if ratchetState.SendCount < 0 && ratchetState.RecvCount < 0 {
 				ratchetState.RatchetType = utils.Receiver
 			}
 
+			var received []byte
+
 			switch ratchetState.RatchetType {
 			case utils.Receiver:
 				// Receiver: 如果SendCount等于RecvCount，则推进RootChain
 				if ratchetState.SendCount == ratchetState.RecvCount {
-					sharedSecret, _ := keyPair.PrivateKey.ECDH(*remotePubKey)
+					sharedSecret, err := keyPair.PrivateKey.ECDH(*remotePubKey)
+					if err != nil {
+						log.Printf("🚨 对端违反协议: %s\n", err.Error())
+						ratchetState.Mutex.Unlock()
+						return
+					}
 					leftKey, rightKey := utils.DevirateChainKey(ratchetState.RootChain, sharedSecret)
 					// 迭代RootChain
 					ratchetState.RootChain = leftKey
@@ -162,14 +189,19 @@ func startRecvListener(isStop chan bool, wg *sync.WaitGroup, recvChannel chan []
 				plaintext, err := utils.DecryptAESGCM(keyChain.MessageKey[ratchetMsg.Count], ratchetMsg.Nonce, ratchetMsg.Message)
 				if err != nil {
 					log.Printf("❌ 解密信息失败: %s\n", err.Error())
+					ratchetState.Mutex.Unlock()
 					return
 				}
-				// 利用通道传输数据
-				recvChannel <- plaintext
+				received = plaintext
 			case utils.Sender:
 				// Sender: 如果SendCount不等于RecvCount，则推进RootChain，并更新密钥对
 				if ratchetState.SendCount != ratchetState.RecvCount {
-					sharedSecret, _ := keyPair.PrivateKey.ECDH(*remotePubKey)
+					sharedSecret, err := keyPair.PrivateKey.ECDH(*remotePubKey)
+					if err != nil {
+						log.Printf("🚨 对端违反协议: %s\n", err.Error())
+						ratchetState.Mutex.Unlock()
+						return
+					}
 					leftKey, rightKey := utils.DevirateChainKey(ratchetState.RootChain, sharedSecret)
 					// 迭代RootChain
 					ratchetState.RootChain = leftKey
@@ -189,14 +221,22 @@ func startRecvListener(isStop chan bool, wg *sync.WaitGroup, recvChannel chan []
 				plaintext, err := utils.DecryptAESGCM(keyChain.MessageKey[ratchetMsg.Count], ratchetMsg.Nonce, ratchetMsg.Message)
 				if err != nil {
 					log.Printf("❌ 解密信息失败: %s\n", message)
+					ratchetState.Mutex.Unlock()
 					return
 				}
-				// 利用通道传输数据
-				recvChannel <- plaintext
+				received = plaintext
 			}
 
 			// 解除互斥锁
 			ratchetState.Mutex.Unlock()
+
+			// 释放互斥锁后再利用通道传输数据，接收方停止读取时不阻塞会话的关闭
+			select {
+			case recvChannel <- received:
+			case <-isStop:
+				log.Println("🛑 RecvListener 接收监听器退出")
+				return
+			}
 		}
 	}
 }
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+var ErrPairingCode = errors.New("配对码不一致，或连接遭到中间人攻击")
+
+// 使用配对码执行 CPace，将得到的会话密钥混入 RootChain。
+// 会话标识取自此前的握手记录，中间人与双方分别握手时只能逐次猜测配对码，
+// 猜测错误会使确认消息校验失败并中止握手
+func (session *Session) pairingHandshake(reader *bufio.Reader, writer *bufio.Writer, ratchetState *utils.RatchetState) error {
+	sid := session.transcript.Sum(nil)
+	password := []byte(utils.NormalizePairingCode(session.config.PairingCode))
+	cpace, err := utils.NewCPace(password, sid, session.isInitiator)
+	if err != nil {
+		return err
+	}
+
+	remoteShare, err := session.exchangeHandshakeFrame(reader, writer, cpace.Share())
+	if err != nil {
+		return err
+	}
+	sessionKey, err := cpace.Finish(remoteShare)
+	if err != nil {
+		return err
+	}
+
+	isConfirmed, err := session.exchangeConfirm(reader, writer, sessionKey, sid, "pairing")
+	if err != nil {
+		return err
+	}
+	if !isConfirmed {
+		return ErrPairingCode
+	}
+
+	ratchetState.RootChain, _ = utils.DevirateChainKey(ratchetState.RootChain, sessionKey)
+	return nil
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+// 通过管道传输层连接两个会话并同时执行握手，返回双方的会话与握手结果
+func handshakeOverPipe(t *testing.T, initiatorConfig, responderConfig *HandshakeConfig) (initiator, responder *Session, initiatorErr, responderErr error) {
+	t.Helper()
+
+	transport := NewPipeTransport()
+	listener, err := transport.Listen("responder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		connect, err := listener.Accept()
+		if err == nil {
+			responder = newSession(connect, false, responderConfig, make(chan []byte, 8), make(chan []byte, 8))
+			if err = responder.start(); err != nil {
+				responder.Stop()
+			}
+		}
+		accepted <- err
+	}()
+
+	connect, err := transport.Dial("initiator", "responder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initiator = newSession(connect, true, initiatorConfig, make(chan []byte, 8), make(chan []byte, 8))
+	// 握手失败时关闭连接，避免另一方阻塞在读取上
+	if initiatorErr = initiator.start(); initiatorErr != nil {
+		initiator.Stop()
+	}
+	responderErr = <-accepted
+	t.Cleanup(initiator.Stop)
+	if responder != nil {
+		t.Cleanup(responder.Stop)
+	}
+	return initiator, responder, initiatorErr, responderErr
+}
+
+func TestPairingCode(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		initiatorCode string
+		responderCode string
+		expected      error
+	}{
+		{"相同的配对码", "7-guitar-revenge", "7 Guitar REVENGE", nil},
+		{"不同的配对码", "7-guitar-revenge", "8-guitar-revenge", ErrPairingCode},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			initiatorConfig := NewHandshakeConfig()
+			initiatorConfig.PairingCode = test.initiatorCode
+			responderConfig := NewHandshakeConfig()
+			responderConfig.PairingCode = test.responderCode
+
+			initiator, responder, initiatorErr, responderErr := handshakeOverPipe(t, &initiatorConfig, &responderConfig)
+			if !errors.Is(initiatorErr, test.expected) || !errors.Is(responderErr, test.expected) {
+				t.Fatalf("握手结果 %v / %v，期望 %v", initiatorErr, responderErr, test.expected)
+			}
+			if test.expected != nil {
+				if initiator.Established() || responder.Established() {
+					t.Fatal("配对失败后会话仍被标记为已建立")
+				}
+				return
+			}
+
+			initiator.SendChannel <- []byte("paired")
+			if message := receive(t, responder.RecvChannel); string(message) != "paired" {
+				t.Fatalf("响应方收到 %q", message)
+			}
+			if initiator.SAS() != responder.SAS() {
+				t.Fatal("双方的短认证字符串不一致")
+			}
+		})
+	}
+}
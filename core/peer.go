@@ -0,0 +1,283 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	peerDialInterval     = 1 * time.Second        // 拨号失败后的重试间隔
+	peerTieBreakDelay    = 500 * time.Millisecond // 仲裁方等待优先连接的时间
+	peerNegotiateTimeout = 10 * time.Second       // 交换节点标识的超时时间
+)
+
+// 仲裁方选定连接后发送的确认信息
+var peerKeepFrame = []byte("KEEP")
+
+// 对等模式：同时监听本地端口并拨号对端，双方都连接成功时只保留一个会话
+type Peer struct {
+	stopChan      chan bool
+	stopOnce      sync.Once
+	waitGroup     sync.WaitGroup
+	nodeID        []byte
+	sessionMutex  sync.Mutex
+	session       *Session
+	Handshake     HandshakeConfig
+	Transport     Transport
+	LocalAddress  string
+	RemoteAddress string
+	SendChannel   chan []byte
+	RecvChannel   chan []byte
+}
+
+// 完成节点标识交换的候选连接
+type peerCandidate struct {
+	connect  net.Conn
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	isDialer bool
+	remoteID []byte
+}
+
+// 使用 bufio.Reader 读取数据后仍保留缓冲区中未读取的字节
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func NewPeer(localAddress, remoteAddress string) *Peer {
+	nodeID := make([]byte, 16)
+	rand.Read(nodeID)
+
+	return &Peer{
+		stopChan:      make(chan bool),
+		nodeID:        nodeID,
+		Handshake:     NewHandshakeConfig(),
+		Transport:     NewTCPTransport(),
+		LocalAddress:  localAddress,
+		RemoteAddress: remoteAddress,
+		SendChannel:   make(chan []byte, 8),
+		RecvChannel:   make(chan []byte, 8),
+	}
+}
+
+func (peer *Peer) StartPeer() {
+	peer.waitGroup.Add(1)
+	go peer.handlePeer()
+}
+
+func (peer *Peer) StopPeer() {
+	peer.stopOnce.Do(func() {
+		log.Println("🛑 停止对等节点中...")
+		close(peer.stopChan)
+		peer.waitGroup.Wait()
+
+		log.Println("✅ 对等节点完全退出")
+	})
+}
+
+// 返回与对端建立的会话，连接尚未建立时返回 nil
+func (peer *Peer) Session() *Session {
+	peer.sessionMutex.Lock()
+	defer peer.sessionMutex.Unlock()
+
+	return peer.session
+}
+
+func (peer *Peer) handlePeer() {
+	defer peer.waitGroup.Done()
+
+	// 无缓冲通道保证会话建立后不会再有候选连接滞留在通道中
+	candidates := make(chan *peerCandidate)
+	established := make(chan bool)
+
+	listener, err := peer.Transport.Listen(peer.LocalAddress)
+	if err != nil {
+		log.Printf("⚠️ 对等节点监听端口失败，仅主动拨号: %s\n", err.Error())
+	} else {
+		log.Printf("🎉 对等节点已开始监听: %s\n", peer.LocalAddress)
+		go peer.acceptLoop(listener, candidates, established)
+	}
+	go peer.dialLoop(candidates, established)
+
+	candidate := peer.selectCandidate(candidates)
+	// 停止监听与拨号，之后到达的候选连接会被直接关闭
+	close(established)
+	if listener != nil {
+		listener.Close()
+	}
+	if candidate == nil {
+		return
+	}
+	log.Printf("🎉 与对端 %s 成功建立连接\n", candidate.connect.RemoteAddr().String())
+
+	// 仲裁方作为密钥协商的发起方，TCP 同时打开时双方都是拨号方也不会冲突
+	connect := &bufferedConn{candidate.connect, candidate.reader}
+	isInitiator := bytes.Compare(peer.nodeID, candidate.remoteID) < 0
+	session := newSession(connect, isInitiator, &peer.Handshake, peer.SendChannel, peer.RecvChannel)
+	defer session.Stop()
+	if err := session.startUntil(peer.stopChan); err != nil {
+		log.Printf("🤯 与对端协商密钥失败: %s\n", err.Error())
+		return
+	}
+	peer.sessionMutex.Lock()
+	peer.session = session
+	peer.sessionMutex.Unlock()
+
+	select {
+	case <-peer.stopChan:
+	case <-session.Done():
+		log.Printf("🛑 与对端 %s 的连接已断开\n", session.RemoteAddress)
+	}
+}
+
+func (peer *Peer) acceptLoop(listener net.Listener, candidates chan *peerCandidate, established chan bool) {
+	for {
+		connect, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go peer.negotiate(connect, false, candidates, established)
+	}
+}
+
+// 持续拨号直到得到候选连接或会话已经建立
+func (peer *Peer) dialLoop(candidates chan *peerCandidate, established chan bool) {
+	for {
+		connect, err := peer.Transport.Dial(peer.LocalAddress, peer.RemoteAddress)
+		if err == nil && peer.negotiate(connect, true, candidates, established) {
+			return
+		}
+
+		select {
+		case <-established:
+			return
+		case <-time.After(peerDialInterval):
+		}
+	}
+}
+
+// 交换节点标识：拨号方先发送，接收方再回复；
+// 连接到监听端口但不发送节点标识的连接在超时后关闭，不会一直占用 goroutine
+func (peer *Peer) negotiate(connect net.Conn, isDialer bool, candidates chan *peerCandidate, established chan bool) bool {
+	reader := bufio.NewReader(connect)
+	writer := bufio.NewWriter(connect)
+	connect.SetDeadline(time.Now().Add(peerNegotiateTimeout))
+
+	if isDialer {
+		if err := writeFrame(writer, peer.nodeID); err != nil {
+			connect.Close()
+			return false
+		}
+	}
+	remoteID, err := utils.DecodeMessageLimit(reader, len(peer.nodeID))
+	if err != nil || bytes.Equal(remoteID, peer.nodeID) {
+		// 读取失败或连接到了自身
+		connect.Close()
+		return false
+	}
+	if !isDialer {
+		if err := writeFrame(writer, peer.nodeID); err != nil {
+			connect.Close()
+			return false
+		}
+	}
+	connect.SetDeadline(time.Time{})
+
+	select {
+	case candidates <- &peerCandidate{connect, reader, writer, isDialer, remoteID}:
+		return true
+	case <-established:
+		connect.Close()
+		return false
+	}
+}
+
+// 节点标识较小的一方作为仲裁方，优先保留由自己拨出的连接；
+// 另一方只接受收到仲裁方确认的连接
+func (peer *Peer) selectCandidate(candidates chan *peerCandidate) *peerCandidate {
+	var fallback *peerCandidate
+	var timeout <-chan time.Time
+	var pending []*peerCandidate
+	kept := make(chan *peerCandidate)
+	// 返回后等待确认的 goroutine 不再投递候选连接，直接关闭连接退出
+	done := make(chan bool)
+	defer close(done)
+
+	// 关闭除 selected 以外的所有候选连接
+	closeOthers := func(selected *peerCandidate) {
+		if fallback != nil && fallback != selected {
+			fallback.connect.Close()
+		}
+		for _, candidate := range pending {
+			if candidate != selected {
+				candidate.connect.Close()
+			}
+		}
+	}
+
+	for {
+		select {
+		case candidate := <-candidates:
+			if bytes.Compare(peer.nodeID, candidate.remoteID) > 0 {
+				// 等待仲裁方的确认，连接被关闭即视为未被选中
+				pending = append(pending, candidate)
+				go func() {
+					frame, err := utils.DecodeMessageLimit(candidate.reader, len(peerKeepFrame))
+					if err != nil || !bytes.Equal(frame, peerKeepFrame) {
+						candidate.connect.Close()
+						return
+					}
+					select {
+					case kept <- candidate:
+					case <-done:
+						candidate.connect.Close()
+					}
+				}()
+				continue
+			}
+			if !candidate.isDialer {
+				if fallback == nil {
+					fallback = candidate
+					timeout = time.After(peerTieBreakDelay)
+				} else {
+					candidate.connect.Close()
+				}
+				continue
+			}
+			if err := writeFrame(candidate.writer, peerKeepFrame); err != nil {
+				candidate.connect.Close()
+				continue
+			}
+			closeOthers(candidate)
+			return candidate
+		case <-timeout:
+			timeout = nil
+			if err := writeFrame(fallback.writer, peerKeepFrame); err != nil {
+				fallback.connect.Close()
+				fallback = nil
+				continue
+			}
+			selected := fallback
+			closeOthers(selected)
+			return selected
+		case candidate := <-kept:
+			closeOthers(candidate)
+			return candidate
+		case <-peer.stopChan:
+			closeOthers(nil)
+			return nil
+		}
+	}
+}
+
+func (conn *bufferedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// 双方同时监听并拨号对方时，仲裁只保留一条连接，双方的会话建立在同一条连接上
+func TestPeerSimultaneousDial(t *testing.T) {
+	for range 5 {
+		transport := NewPipeTransport()
+		alice := NewPeer("alice", "bob")
+		alice.Transport = transport
+		bob := NewPeer("bob", "alice")
+		bob.Transport = transport
+
+		alice.StartPeer()
+		bob.StartPeer()
+		waitFor(t, func() bool { return alice.Session() != nil && bob.Session() != nil })
+
+		alice.SendChannel <- []byte("from alice")
+		if message := receive(t, bob.RecvChannel); !bytes.Equal(message, []byte("from alice")) {
+			t.Fatalf("bob 收到 %q", message)
+		}
+		bob.SendChannel <- []byte("from bob")
+		if message := receive(t, alice.RecvChannel); !bytes.Equal(message, []byte("from bob")) {
+			t.Fatalf("alice 收到 %q", message)
+		}
+		if alice.Session().isInitiator == bob.Session().isInitiator {
+			t.Fatal("双方的会话角色相同")
+		}
+
+		alice.StopPeer()
+		bob.StopPeer()
+	}
+}
+
+// 连接到监听端口后不发送节点标识的连接不影响与真正对端建立会话
+func TestPeerIgnoresStrayConnection(t *testing.T) {
+	transport := NewPipeTransport()
+	alice := NewPeer("alice", "bob")
+	alice.Transport = transport
+	alice.StartPeer()
+	defer alice.StopPeer()
+
+	var stray interface{ Close() error }
+	waitFor(t, func() bool {
+		connect, err := transport.Dial("stray", "alice")
+		if err == nil {
+			stray = connect
+		}
+		return err == nil
+	})
+	defer stray.Close()
+
+	bob := NewPeer("bob", "alice")
+	bob.Transport = transport
+	bob.StartPeer()
+	defer bob.StopPeer()
+	waitFor(t, func() bool { return alice.Session() != nil && bob.Session() != nil })
+}
+
+func receive(t *testing.T, channel chan []byte) []byte {
+	t.Helper()
+
+	select {
+	case message := <-channel:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("接收信息超时")
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+var ErrPSKMismatch = errors.New("预共享密钥不一致，拒绝建立会话")
+
+// 将预共享密钥与 ECDH 得到的 RootChain 一同派生新的 RootChain，
+// 并交换确认消息，不知道预共享密钥的攻击者无法完成握手
+func (session *Session) pskHandshake(reader *bufio.Reader, writer *bufio.Writer, ratchetState *utils.RatchetState) error {
+	sid := session.transcript.Sum(nil)
+	rootChain, confirmKey := utils.DevirateChainKey(ratchetState.RootChain, session.config.PSK)
+
+	isConfirmed, err := session.exchangeConfirm(reader, writer, confirmKey, sid, "psk")
+	if err != nil {
+		return err
+	}
+	if !isConfirmed {
+		return ErrPSKMismatch
+	}
+
+	ratchetState.RootChain = rootChain
+	return nil
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 通过管道传输层建立一对连接
+func pipeConnPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+
+	transport := NewPipeTransport()
+	listener, err := transport.Listen("responder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		connect, _ := listener.Accept()
+		accepted <- connect
+	}()
+	initiator, err := transport.Dial("initiator", "responder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder := <-accepted
+	t.Cleanup(func() {
+		initiator.Close()
+		responder.Close()
+	})
+	return initiator, responder
+}
+
+// 双方从相同的 RootChain 出发执行预共享密钥握手，返回双方得到的 RootChain
+func runPSKHandshake(t *testing.T, rootChain, initiatorPSK, responderPSK []byte) ([]byte, []byte, error, error) {
+	t.Helper()
+
+	initiatorConn, responderConn := pipeConnPair(t)
+	run := func(connect net.Conn, isInitiator bool, psk []byte, result chan error) *utils.RatchetState {
+		config := NewHandshakeConfig()
+		config.PSK = psk
+		session := newSession(connect, isInitiator, &config, nil, nil)
+		ratchetState := utils.NewRatchetState()
+		ratchetState.RootChain = bytes.Clone(rootChain)
+		go func() {
+			result <- session.pskHandshake(bufio.NewReader(connect), bufio.NewWriter(connect), ratchetState)
+		}()
+		return ratchetState
+	}
+
+	initiatorResult := make(chan error, 1)
+	responderResult := make(chan error, 1)
+	initiatorState := run(initiatorConn, true, initiatorPSK, initiatorResult)
+	responderState := run(responderConn, false, responderPSK, responderResult)
+	initiatorErr, responderErr := <-initiatorResult, <-responderResult
+	return initiatorState.RootChain, responderState.RootChain, initiatorErr, responderErr
+}
+
+func TestPSKChangesRootChain(t *testing.T) {
+	rootChain := bytes.Repeat([]byte{0x42}, 32)
+	psk := bytes.Repeat([]byte{0x01}, 32)
+
+	initiatorRoot, responderRoot, initiatorErr, responderErr := runPSKHandshake(t, rootChain, psk, psk)
+	if initiatorErr != nil || responderErr != nil {
+		t.Fatalf("握手失败: %v / %v", initiatorErr, responderErr)
+	}
+	if !bytes.Equal(initiatorRoot, responderRoot) {
+		t.Fatal("双方的 RootChain 不一致")
+	}
+	if bytes.Equal(initiatorRoot, rootChain) {
+		t.Fatal("预共享密钥没有改变 RootChain")
+	}
+
+	otherRoot, _, _, _ := runPSKHandshake(t, rootChain, bytes.Repeat([]byte{0x02}, 32), bytes.Repeat([]byte{0x02}, 32))
+	if bytes.Equal(initiatorRoot, otherRoot) {
+		t.Fatal("不同的预共享密钥得到了相同的 RootChain")
+	}
+}
+
+func TestPSKMismatchAbortsHandshake(t *testing.T) {
+	rootChain := bytes.Repeat([]byte{0x42}, 32)
+	initiatorRoot, responderRoot, initiatorErr, responderErr := runPSKHandshake(t, rootChain, []byte("alpha"), []byte("bravo"))
+	if initiatorErr != ErrPSKMismatch || responderErr != ErrPSKMismatch {
+		t.Fatalf("握手结果 %v / %v，期望 %v", initiatorErr, responderErr, ErrPSKMismatch)
+	}
+	if !bytes.Equal(initiatorRoot, rootChain) || !bytes.Equal(responderRoot, rootChain) {
+		t.Fatal("确认失败后 RootChain 仍被修改")
+	}
+}
+
+// 完整握手中预共享密钥不一致时双方都中止会话，服务端不保留该对端
+func TestPSKMismatchAbortsSession(t *testing.T) {
+	initiatorConfig := NewHandshakeConfig()
+	initiatorConfig.PSK = []byte("alpha")
+	responderConfig := NewHandshakeConfig()
+	responderConfig.PSK = []byte("bravo")
+
+	initiator, responder, initiatorErr, responderErr := handshakeOverPipe(t, &initiatorConfig, &responderConfig)
+	if !errors.Is(initiatorErr, ErrPSKMismatch) || !errors.Is(responderErr, ErrPSKMismatch) {
+		t.Fatalf("握手结果 %v / %v，期望 %v", initiatorErr, responderErr, ErrPSKMismatch)
+	}
+	if initiator.Established() || responder.Established() {
+		t.Fatal("预共享密钥不一致时会话仍被标记为已建立")
+	}
+
+	server, client := startPipeServer(t, func(server *Server, client *Client) {
+		server.Handshake.PSK = []byte("alpha")
+		client.Handshake.PSK = []byte("bravo")
+	})
+	// 握手失败后客户端直接退出
+	exited := make(chan bool)
+	go func() {
+		client.waitGroup.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("客户端没有退出")
+	}
+	if client.Session() != nil || len(server.Peers()) != 0 {
+		t.Fatal("预共享密钥不一致时仍建立了会话")
+	}
+}
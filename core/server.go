@@ -1,134 +1,200 @@
-package core
-
-import (
-	"bufio"
-	"log"
-	"net"
-	"sync"
-	"time"
-
-	"github.com/libp2p/go-reuseport"
-	"github.com/reagin/double_ratchet/utils"
-)
-
-type Server struct {
-	stopChan     chan bool
-	stopOnce     sync.Once
-	waitGroug    sync.WaitGroup
-	netListener  net.Listener
-	LocalAddress string
-	SendChannel  chan []byte
-	RecvChannel  chan []byte
-}
-
-func NewServer(localAddress string) *Server {
-	return &Server{
-		stopChan:     make(chan bool),
-		LocalAddress: localAddress,
-		SendChannel:  make(chan []byte, 8),
-		RecvChannel:  make(chan []byte, 8),
-	}
-}
-
-func (server *Server) StartServer() {
-	go server.handleServer()
-}
-
-func (server *Server) StopServer() {
-	server.stopOnce.Do(func() {
-		log.Println("🛑 服务器正在关闭...")
-		close(server.stopChan)
-
-		if server.netListener != nil {
-			server.netListener.Close()
-		}
-		server.waitGroug.Wait()
-
-		log.Println("✅ 服务器已成功关闭")
-	})
-}
-
-func (server *Server) handleServer() {
-	listener, err := reuseport.Listen("tcp", server.LocalAddress)
-	if err != nil {
-		log.Printf("❌ 服务端监听端口失败: %s\n", err.Error())
-		return
-	}
-	defer listener.Close()
-	server.netListener = listener
-	log.Printf("🎉 服务端已开始监听: %s\n", server.LocalAddress)
-
-	for {
-		listener.(*net.TCPListener).SetDeadline(time.Now().Add(1 * time.Second))
-		connect, err := listener.Accept()
-		if err != nil {
-			select {
-			case <-server.stopChan:
-				log.Println("🛑 服务器收到停止信号，终止监听")
-				return
-			default:
-				continue
-			}
-		}
-		log.Printf("🎉 与客户端 %s 成功建立连接\n", connect.RemoteAddr().String())
-
-		server.waitGroug.Add(1)
-		go server.handleConnection(connect)
-	}
-}
-
-func (server *Server) handleConnection(connect net.Conn) {
-	defer connect.Close()
-	defer server.waitGroug.Done()
-
-	// 记录双棘轮的状态信息
-	ratchetState := utils.NewRatchetState()
-
-	// 使用 bufio 修饰 net.Conn
-	reader := bufio.NewReader(connect)
-	writer := bufio.NewWriter(connect)
-
-	// 生成 Diffe-Hellman 密钥对
-	keyPair := utils.NewDiffeHellmanKeyPair()
-	pubKeyBytes, _ := utils.EncodeMessage(keyPair.PublicKey.Bytes())
-
-	// 接收客户端的初始公钥
-	remotePubKeyBytes, _ := utils.DecodeMessage(reader)
-	remotePubKey := utils.BytesToPublicKey(remotePubKeyBytes)
-
-	// 计算共享密钥
-	sharedSecret, _ := keyPair.PrivateKey.ECDH(remotePubKey)
-	ratchetState.RootChain = sharedSecret
-
-	// 向客户端发送初始公钥
-	if _, err := writer.Write(pubKeyBytes); err != nil {
-		log.Printf("🤯 向客户端发送信息失败: %s\n", err.Error())
-		return
-	}
-	writer.Flush()
-
-	// 交换公钥用于后续主动发起请求
-	// 生成 Diffe-Hellman 密钥对
-	keyPair = utils.NewDiffeHellmanKeyPair()
-	pubKeyBytes, _ = utils.EncodeMessage(keyPair.PublicKey.Bytes())
-
-	// 接收客户端的公钥
-	remotePubKeyBytes, _ = utils.DecodeMessage(reader)
-	remotePubKey = utils.BytesToPublicKey(remotePubKeyBytes)
-
-	// 向客户端发送公钥
-	if _, err := writer.Write(pubKeyBytes); err != nil {
-		log.Printf("🤯 向客户端发送信息失败: %s\n", err.Error())
-		return
-	}
-	writer.Flush()
-
-	server.waitGroug.Add(2)
-	// NOTE: 启动 gorunite 发送信息
-	go startSendListener(server.stopChan, &server.waitGroug, server.SendChannel, writer, keyPair, ratchetState, &remotePubKey)
-	// NOTE: 启动 gorunite 接收信息
-	go startRecvListener(server.stopChan, &server.waitGroug, server.RecvChannel, reader, keyPair, ratchetState, &remotePubKey)
-
-	<-server.stopChan
-	log.Printf("🛑 关闭与客户端 %s 的连接\n", connect.RemoteAddr().String())
-}
+package core
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"sync"
+)
+
+type Server struct {
+	stopChan     chan bool
+	stopOnce     sync.Once
+	waitGroug    sync.WaitGroup
+	netMutex     sync.Mutex // 保护 netListener，监听器在 handleServer 中创建，在 StopServer 中关闭
+	netListener  net.Listener
+	sessionMutex sync.RWMutex
+	sessions     map[string]*Session
+	Handshake    HandshakeConfig
+	Transport    Transport
+	LocalAddress string
+	SendChannel  chan []byte       // 向所有对端广播的信息
+	RecvChannel  chan *PeerMessage // 从各个对端接收的信息，附带来源标识
+}
+
+func NewServer(localAddress string) *Server {
+	return &Server{
+		stopChan:     make(chan bool),
+		sessions:     make(map[string]*Session),
+		Handshake:    NewHandshakeConfig(),
+		Transport:    NewTCPTransport(),
+		LocalAddress: localAddress,
+		SendChannel:  make(chan []byte, 8),
+		RecvChannel:  make(chan *PeerMessage, 8),
+	}
+}
+
+func (server *Server) StartServer() {
+	server.waitGroug.Add(2)
+	go server.handleBroadcast()
+	go server.handleServer()
+}
+
+func (server *Server) StopServer() {
+	server.stopOnce.Do(func() {
+		log.Println("🛑 服务器正在关闭...")
+		close(server.stopChan)
+
+		server.netMutex.Lock()
+		if server.netListener != nil {
+			server.netListener.Close()
+		}
+		server.netMutex.Unlock()
+		server.waitGroug.Wait()
+
+		log.Println("✅ 服务器已成功关闭")
+	})
+}
+
+// 返回当前已建立会话的所有对端标识
+func (server *Server) Peers() []string {
+	server.sessionMutex.RLock()
+	defer server.sessionMutex.RUnlock()
+
+	peers := make([]string, 0, len(server.sessions))
+	for peerID := range server.sessions {
+		peers = append(peers, peerID)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// 返回指定对端的会话，不存在时返回 nil
+func (server *Server) Session(peerID string) *Session {
+	server.sessionMutex.RLock()
+	defer server.sessionMutex.RUnlock()
+
+	return server.sessions[peerID]
+}
+
+// 向指定对端发送信息
+func (server *Server) SendTo(peerID string, message []byte) error {
+	session := server.Session(peerID)
+	if session == nil {
+		return ErrPeerNotFound
+	}
+
+	select {
+	case session.SendChannel <- message:
+		return nil
+	case <-session.Done():
+		return ErrPeerNotFound
+	}
+}
+
+func (server *Server) handleServer() {
+	defer server.waitGroug.Done()
+
+	listener, err := server.Transport.Listen(server.LocalAddress)
+	if err != nil {
+		log.Printf("❌ 服务端监听端口失败: %s\n", err.Error())
+		return
+	}
+	defer listener.Close()
+	// 在锁内检查停止信号，StopServer 要么看到监听器并关闭它，要么停止信号在这里被发现
+	server.netMutex.Lock()
+	select {
+	case <-server.stopChan:
+		server.netMutex.Unlock()
+		return
+	default:
+	}
+	server.netListener = listener
+	server.netMutex.Unlock()
+	log.Printf("🎉 服务端已开始监听: %s\n", server.LocalAddress)
+
+	for {
+		// StopServer 关闭监听器后 Accept 会立即返回错误
+		connect, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-server.stopChan:
+				log.Println("🛑 服务器收到停止信号，终止监听")
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				log.Printf("❌ 服务端监听器已关闭: %s\n", err.Error())
+				return
+			}
+			continue
+		}
+		log.Printf("🎉 与客户端 %s 成功建立连接\n", connect.RemoteAddr().String())
+
+		server.waitGroug.Add(1)
+		go server.handleConnection(connect)
+	}
+}
+
+func (server *Server) handleConnection(connect net.Conn) {
+	defer server.waitGroug.Done()
+
+	// 每条连接拥有独立的会话与收发通道
+	session := newSession(connect, false, &server.Handshake, make(chan []byte, 8), make(chan []byte, 8))
+	defer session.Stop()
+	if err := session.startUntil(server.stopChan); err != nil {
+		log.Printf("🤯 与客户端 %s 协商密钥失败: %s\n", session.RemoteAddress, err.Error())
+		return
+	}
+
+	server.sessionMutex.Lock()
+	// 对端标识冲突时重新生成，避免覆盖已有的会话
+	for server.sessions[session.PeerID] != nil {
+		session.PeerID = newPeerID()
+	}
+	server.sessions[session.PeerID] = session
+	server.sessionMutex.Unlock()
+	log.Printf("🎉 对端 %s (%s) 已加入\n", session.PeerID, session.RemoteAddress)
+
+	defer func() {
+		server.sessionMutex.Lock()
+		delete(server.sessions, session.PeerID)
+		server.sessionMutex.Unlock()
+	}()
+
+	// 为接收到的信息附带来源标识
+	for {
+		select {
+		case <-server.stopChan:
+			log.Printf("🛑 关闭与客户端 %s 的连接\n", session.RemoteAddress)
+			return
+		case <-session.Done():
+			log.Printf("🛑 对端 %s (%s) 已断开\n", session.PeerID, session.RemoteAddress)
+			return
+		case message := <-session.RecvChannel:
+			select {
+			case server.RecvChannel <- &PeerMessage{session.PeerID, message}:
+			case <-server.stopChan:
+			}
+		}
+	}
+}
+
+// 将 SendChannel 中的信息广播给所有对端
+func (server *Server) handleBroadcast() {
+	defer server.waitGroug.Done()
+
+	for {
+		select {
+		case <-server.stopChan:
+			return
+		case message := <-server.SendChannel:
+			for _, peerID := range server.Peers() {
+				if err := server.SendTo(peerID, message); err != nil {
+					log.Printf("🤯 向对端 %s 发送信息失败: %s\n", peerID, err.Error())
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// 使用管道传输层启动一对服务端与客户端，等待会话建立
+func startPipeServer(t *testing.T, configure func(server *Server, client *Client)) (*Server, *Client) {
+	t.Helper()
+
+	transport := NewPipeTransport()
+	server := NewServer("server")
+	server.Transport = transport
+	client := NewClient("client", "server")
+	client.Transport = transport
+	if configure != nil {
+		configure(server, client)
+	}
+	server.StartServer()
+	t.Cleanup(server.StopServer)
+	waitFor(t, func() bool {
+		server.netMutex.Lock()
+		defer server.netMutex.Unlock()
+		return server.netListener != nil
+	})
+	client.StartClient()
+	t.Cleanup(client.StopClient)
+	return server, client
+}
+
+// 轮询直到条件成立，超时则测试失败
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	server, client := startPipeServer(t, nil)
+
+	client.SendChannel <- []byte("hello")
+	message := <-server.RecvChannel
+	if string(message.Message) != "hello" {
+		t.Fatalf("服务端收到 %q", message.Message)
+	}
+	if err := server.SendTo(message.PeerID, []byte("reply")); err != nil {
+		t.Fatal(err)
+	}
+	if reply := <-client.RecvChannel; string(reply) != "reply" {
+		t.Fatalf("客户端收到 %q", reply)
+	}
+}
+
+// 服务端不再读取接收通道时，StopServer 不能因为阻塞的接收监听器而挂起
+func TestStopServerWithUndrainedMessages(t *testing.T) {
+	server, client := startPipeServer(t, nil)
+
+	// 填满服务端与会话的接收通道，使接收监听器阻塞在投递上
+	for range 2*cap(server.RecvChannel) + 2 {
+		client.SendChannel <- []byte("undrained")
+	}
+	waitFor(t, func() bool {
+		peers := server.Peers()
+		if len(peers) != 1 {
+			return false
+		}
+		session := server.Session(peers[0])
+		return len(server.RecvChannel) == cap(server.RecvChannel) && len(session.RecvChannel) == cap(session.RecvChannel)
+	})
+	// 等待接收监听器读取最后一条信息并阻塞在投递上
+	time.Sleep(100 * time.Millisecond)
+
+	stopped := make(chan bool)
+	go func() {
+		server.StopServer()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StopServer 没有返回")
+	}
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 握手的超时时间，对端停止响应时握手失败而不是一直阻塞
+const handshakeTimeout = 30 * time.Second
+
+var ErrPeerNotFound = errors.New("指定的对端不存在")
+
+var peerIDCounter atomic.Uint64
+
+// 单条连接对应的会话，持有独立的收发通道与双棘轮状态
+type Session struct {
+	stopChan       chan bool
+	stopOnce       sync.Once
+	waitGroup      sync.WaitGroup
+	netConnect     net.Conn
+	isInitiator    bool
+	config         *HandshakeConfig
+	PeerID         string
+	RemoteAddress  string
+	RemoteIdentity []byte // 经过认证的对端身份公钥，仅 Noise 握手可用
+	transcript     hash.Hash
+	verifyMutex    sync.Mutex
+	established    bool // 双方完成密钥确认后置为 true
+	sas            string
+	coverSecret    []byte // 握手导出的掩护流量层密钥
+	verifyStatus   int
+	SendChannel    chan []byte
+	RecvChannel    chan []byte
+}
+
+// 携带来源信息的消息，用于区分不同对端发送的数据
+type PeerMessage struct {
+	PeerID  string
+	Message []byte
+}
+
+func newSession(connect net.Conn, isInitiator bool, config *HandshakeConfig, sendChannel, recvChannel chan []byte) *Session {
+	return &Session{
+		stopChan:      make(chan bool),
+		netConnect:    connect,
+		isInitiator:   isInitiator,
+		config:        config,
+		transcript:    sha256.New(),
+		verifyStatus:  Unverified,
+		PeerID:        newPeerID(),
+		RemoteAddress: connect.RemoteAddr().String(),
+		SendChannel:   sendChannel,
+		RecvChannel:   recvChannel,
+	}
+}
+
+// 生成 8 字节的随机对端标识，随机数不可用时退化为基于时间与计数器的标识
+func newPeerID() string {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		binary.BigEndian.PutUint64(idBytes, uint64(time.Now().UnixNano())+peerIDCounter.Add(1))
+	}
+	return hex.EncodeToString(idBytes)
+}
+
+// 返回会话结束时关闭的通道
+func (session *Session) Done() <-chan bool {
+	return session.stopChan
+}
+
+// 会话是否已完成握手与密钥确认
+func (session *Session) Established() bool {
+	session.verifyMutex.Lock()
+	defer session.verifyMutex.Unlock()
+
+	return session.established
+}
+
+func (session *Session) Stop() {
+	session.stopOnce.Do(func() {
+		close(session.stopChan)
+		session.netConnect.Close()
+		session.waitGroup.Wait()
+	})
+}
+
+// 完成密钥协商，并启动收发监听器
+func (session *Session) start() error {
+	// 记录双棘轮的状态信息
+	ratchetState := utils.NewRatchetState()
+
+	// 使用 bufio 修饰 net.Conn
+	reader := bufio.NewReader(session.netConnect)
+	writer := bufio.NewWriter(session.netConnect)
+
+	session.netConnect.SetDeadline(time.Now().Add(handshakeTimeout))
+	keyPair, remotePubKey, err := session.handshake(reader, writer, ratchetState)
+	if err != nil {
+		return err
+	}
+	if session.config.CheckIdentity != nil && len(session.RemoteIdentity) > 0 {
+		if err := session.config.CheckIdentity(session); err != nil {
+			return err
+		}
+	}
+	session.netConnect.SetDeadline(time.Time{})
+	session.verifyMutex.Lock()
+	session.sas = deriveSAS(session.transcript.Sum(nil))
+	session.established = true
+	session.verifyMutex.Unlock()
+
+	// 启用掩护流量时，之后的棘轮消息经过掩护流量层以固定大小的帧发送
+	if session.config.Cover != nil {
+		cover, err := newCoverConn(reader, session.netConnect, session.config.Cover, session.coverSecret, session.isInitiator)
+		if err != nil {
+			return err
+		}
+		reader = bufio.NewReader(cover)
+		writer = bufio.NewWriter(cover)
+		session.waitGroup.Add(1)
+		go session.handleCover(cover)
+	}
+
+	session.waitGroup.Add(2)
+	// NOTE: 启动 gorunite 发送信息
+	go func() {
+		startSendListener(session.stopChan, &session.waitGroup, session.SendChannel, writer, keyPair, ratchetState, &remotePubKey)
+		go session.Stop()
+	}()
+	// NOTE: 启动 gorunite 接收信息
+	go func() {
+		startRecvListener(session.stopChan, &session.waitGroup, session.RecvChannel, reader, keyPair, ratchetState, &remotePubKey)
+		go session.Stop()
+	}()
+
+	return nil
+}
+
+// 与 start 相同，但 stop 关闭时立即关闭连接使握手失败，避免握手阻塞调用方的 Stop
+func (session *Session) startUntil(stop <-chan bool) error {
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			session.netConnect.Close()
+		case <-done:
+		}
+	}()
+	return session.start()
+}
+
+// 编码并发送一帧数据
+func writeFrame(writer *bufio.Writer, data []byte) error {
+	frame, err := utils.EncodeMessage(data)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(frame); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tor 默认的 ControlPort
+const TorControlAddress = "127.0.0.1:9051"
+
+var ErrTorControl = errors.New("Tor 控制端口返回错误")
+
+// Tor 控制协议的最小实现，只用于发布与移除洋葱服务；
+// 未设置 Detach 标志的洋葱服务随控制连接一起关闭，因此连接在服务存在期间保持打开
+type TorControl struct {
+	mutex      sync.Mutex
+	conn       net.Conn
+	reader     *bufio.Reader
+	Address    string
+	Password   string // HashedControlPassword 对应的口令
+	CookieFile string // 使用 CookieAuthentication 时的 cookie 文件路径
+	PrivateKey string // 洋葱服务的私钥，为空时生成新的地址并在发布后保存于此
+}
+
+func NewTorControl(address string) *TorControl {
+	return &TorControl{Address: address}
+}
+
+// 发布一个洋葱服务，将服务的 port 端口转发到 target，返回服务 ID (不含 .onion)
+func (control *TorControl) AddOnion(port int, target string) (string, error) {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	if err := control.connect(); err != nil {
+		return "", err
+	}
+	key := control.PrivateKey
+	if key == "" {
+		key = "NEW:ED25519-V3"
+	}
+	lines, err := control.command(fmt.Sprintf("ADD_ONION %s Port=%d,%s", key, port, target))
+	if err != nil {
+		return "", err
+	}
+
+	serviceID := ""
+	for _, line := range lines {
+		if value, ok := strings.CutPrefix(line, "ServiceID="); ok {
+			serviceID = value
+		}
+		if value, ok := strings.CutPrefix(line, "PrivateKey="); ok {
+			control.PrivateKey = value
+		}
+	}
+	if serviceID == "" {
+		return "", ErrTorControl
+	}
+	return serviceID, nil
+}
+
+func (control *TorControl) DelOnion(serviceID string) error {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	if control.conn == nil {
+		return nil
+	}
+	_, err := control.command("DEL_ONION " + serviceID)
+	return err
+}
+
+func (control *TorControl) Close() error {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	if control.conn == nil {
+		return nil
+	}
+	err := control.conn.Close()
+	control.conn = nil
+	return err
+}
+
+// 连接控制端口并认证，已经连接时直接返回
+func (control *TorControl) connect() error {
+	if control.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", control.Address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	control.conn = conn
+	control.reader = bufio.NewReader(conn)
+
+	authenticate := "AUTHENTICATE"
+	if control.CookieFile != "" {
+		cookie, err := os.ReadFile(control.CookieFile)
+		if err != nil {
+			control.conn.Close()
+			control.conn = nil
+			return err
+		}
+		authenticate += " " + hex.EncodeToString(cookie)
+	} else if control.Password != "" {
+		authenticate += " " + strconv.Quote(control.Password)
+	}
+	if _, err := control.command(authenticate); err != nil {
+		control.conn.Close()
+		control.conn = nil
+		return err
+	}
+	return nil
+}
+
+// 发送一条命令并读取回复，返回 "250-" 开头的各行内容
+func (control *TorControl) command(command string) ([]string, error) {
+	control.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer control.conn.SetDeadline(time.Time{})
+
+	if _, err := control.conn.Write([]byte(command + "\r\n")); err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	for {
+		line, err := control.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 4 {
+			return nil, ErrTorControl
+		}
+		if line[:3] != "250" {
+			return nil, fmt.Errorf("%w: %s", ErrTorControl, line)
+		}
+		// "250 " 为最后一行，"250-" 为中间行
+		if line[3] == ' ' {
+			return lines, nil
+		}
+		lines = append(lines, line[4:])
+	}
+}
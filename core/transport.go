@@ -0,0 +1,28 @@
+package core
+
+import (
+	"net"
+
+	"github.com/libp2p/go-reuseport"
+)
+
+// 传输层抽象，会话只依赖 net.Conn 提供的有序字节流
+type Transport interface {
+	Dial(localAddress, remoteAddress string) (net.Conn, error)
+	Listen(localAddress string) (net.Listener, error)
+}
+
+// 基于 TCP 的传输层，利用端口复用使监听与拨号可以共用同一本地端口
+type TCPTransport struct{}
+
+func NewTCPTransport() *TCPTransport {
+	return &TCPTransport{}
+}
+
+func (transport *TCPTransport) Dial(localAddress, remoteAddress string) (net.Conn, error) {
+	return reuseport.Dial("tcp", localAddress, remoteAddress)
+}
+
+func (transport *TCPTransport) Listen(localAddress string) (net.Listener, error) {
+	return reuseport.Listen("tcp", localAddress)
+}
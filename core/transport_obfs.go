@@ -0,0 +1,436 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	obfsMaxPayload = 16 << 10 // 单个帧携带的最大明文字节数
+	obfsMaxFrame   = 0xffff   // 帧长度使用 2 字节表示
+	obfsMarkSize   = 16       // 客户端握手中标记与 MAC 的长度
+	obfsMaxHello   = 4096     // 客户端握手中随机填充的最大字节数
+	obfsMACWindow  = 1        // 客户端 MAC 中的小时数允许与服务端相差的小时数
+)
+
+var ErrObfsFrame = errors.New("混淆帧认证失败")
+var ErrObfsHandshake = errors.New("混淆握手认证失败")
+var ErrObfsKey = errors.New("混淆传输层缺少服务端的长期密钥")
+
+// 混淆传输层，使连接上的所有字节与随机数据无法区分：
+// 双方先交换 Elligator2 编码的临时公钥，之后的数据 (包括会话握手中的公钥与长度前缀)
+// 以帧为单位加密，帧长度使用独立的密钥流掩盖，每个帧附加随机长度的填充。
+// 与 obfs4 相同，客户端需要通过带外方式得到服务端的长期公钥：客户端握手附带以该公钥为密钥的 MAC，
+// 不知道公钥的探测者得不到任何回复；会话密钥同时混入与服务端长期公钥的 DH 结果，冒充的服务端无法生成有效的帧
+type ObfsTransport struct {
+	Transport   Transport                  // 底层传输层
+	MaxPadding  int                        // 每个帧附加的随机填充的最大字节数
+	IdentityKey *utils.DiffeHellmanKeyPair // 服务端的长期密钥，Listen 时需要
+	ServerKey   []byte                     // 服务端的长期公钥，Dial 时需要
+}
+
+// 一个方向上的帧加密状态
+type obfsCipher struct {
+	aead  cipher.AEAD
+	mask  cipher.Stream // 用于掩盖帧长度的 AES-CTR 密钥流
+	nonce uint64
+}
+
+type obfsConn struct {
+	net.Conn
+	isClient      bool
+	maxPadding    int
+	identityKey   *utils.DiffeHellmanKeyPair // 服务端的长期密钥
+	serverKey     []byte                     // 服务端的长期公钥
+	replay        *obfsReplayFilter
+	reader        *bufio.Reader
+	handshakeOnce sync.Once
+	handshakeErr  error
+	send          *obfsCipher
+	recv          *obfsCipher
+	readMutex     sync.Mutex
+	writeMutex    sync.Mutex
+	readBuffer    []byte
+}
+
+type obfsListener struct {
+	net.Listener
+	maxPadding  int
+	identityKey *utils.DiffeHellmanKeyPair
+	replay      *obfsReplayFilter
+}
+
+// 记录 MAC 有效期内见过的客户端 MAC，拒绝重放的客户端握手
+type obfsReplayFilter struct {
+	mutex sync.Mutex
+	seen  map[string]time.Time
+}
+
+func NewObfsTransport(transport Transport) *ObfsTransport {
+	return &ObfsTransport{
+		Transport:  transport,
+		MaxPadding: 512,
+	}
+}
+
+func (transport *ObfsTransport) Dial(localAddress, remoteAddress string) (net.Conn, error) {
+	if _, err := utils.BytesToPublicKey(transport.ServerKey); err != nil {
+		return nil, ErrObfsKey
+	}
+	conn, err := transport.Transport.Dial(localAddress, remoteAddress)
+	if err != nil {
+		return nil, err
+	}
+	obfs := newObfsConn(conn, true, transport.MaxPadding)
+	obfs.serverKey = transport.ServerKey
+	return obfs, nil
+}
+
+func (transport *ObfsTransport) Listen(localAddress string) (net.Listener, error) {
+	if transport.IdentityKey == nil {
+		return nil, ErrObfsKey
+	}
+	listener, err := transport.Transport.Listen(localAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &obfsListener{
+		Listener:    listener,
+		maxPadding:  transport.MaxPadding,
+		identityKey: transport.IdentityKey,
+		replay:      &obfsReplayFilter{seen: make(map[string]time.Time)},
+	}, nil
+}
+
+// 握手在第一次读写时进行，避免一个缓慢的连接阻塞 Accept；
+// 握手的超时由会话的握手超时控制，混淆层不单独设置读写超时
+func (listener *obfsListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	obfs := newObfsConn(conn, false, listener.maxPadding)
+	obfs.identityKey = listener.identityKey
+	obfs.serverKey = listener.identityKey.PublicKey.Bytes()
+	obfs.replay = listener.replay
+	return obfs, nil
+}
+
+func newObfsConn(conn net.Conn, isClient bool, maxPadding int) *obfsConn {
+	return &obfsConn{
+		Conn:       conn,
+		isClient:   isClient,
+		maxPadding: maxPadding,
+		reader:     bufio.NewReader(conn),
+	}
+}
+
+// MAC 在前后 obfsMACWindow 小时内有效，超出有效期的记录不再需要
+func (filter *obfsReplayFilter) check(mac []byte) bool {
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+
+	now := time.Now()
+	for seen, expiresAt := range filter.seen {
+		if now.After(expiresAt) {
+			delete(filter.seen, seen)
+		}
+	}
+	if _, ok := filter.seen[string(mac)]; ok {
+		return false
+	}
+	filter.seen[string(mac)] = now.Add(time.Duration(2*obfsMACWindow+1) * time.Hour)
+	return true
+}
+
+// 以服务端长期公钥为密钥的 HMAC-SHA256，截取前 obfsMarkSize 字节
+func obfsHMAC(serverKey []byte, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, serverKey)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)[:obfsMarkSize]
+}
+
+// 标记用于在随机长度的填充之后定位 MAC
+func obfsMark(serverKey, representative []byte) []byte {
+	return obfsHMAC(serverKey, []byte("DoubleRatchetDemo obfs mark"), representative)
+}
+
+// MAC 覆盖客户端握手的全部内容与当前的小时数
+func obfsMAC(serverKey, hello []byte, hour int64) []byte {
+	return obfsHMAC(serverKey, []byte("DoubleRatchetDemo obfs mac"), hello, binary.BigEndian.AppendUint64(nil, uint64(hour)))
+}
+
+func newObfsCipher(key []byte) (*obfsCipher, error) {
+	frameKey, lengthKey := utils.DevirateChainKey(key, []byte("DoubleRatchetDemo obfs"))
+	frameBlock, err := aes.NewCipher(frameKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(frameBlock)
+	if err != nil {
+		return nil, err
+	}
+	lengthBlock, err := aes.NewCipher(lengthKey)
+	if err != nil {
+		return nil, err
+	}
+	mask := cipher.NewCTR(lengthBlock, make([]byte, aes.BlockSize))
+	return &obfsCipher{aead: aead, mask: mask}, nil
+}
+
+// nonce 为 32 位的 0 加上 64 位大端序计数，帧被重放、重排或删除时认证失败
+func (obfs *obfsCipher) nextNonce() []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], obfs.nonce)
+	obfs.nonce++
+	return nonce
+}
+
+// 双方交换 Elligator2 编码的临时公钥，由 DH 结果与双方的编码派生两个方向的密钥
+func (conn *obfsConn) handshake() error {
+	conn.handshakeOnce.Do(func() {
+		if conn.isClient {
+			conn.handshakeErr = conn.clientHandshake()
+		} else {
+			conn.handshakeErr = conn.serverHandshake()
+		}
+	})
+	return conn.handshakeErr
+}
+
+// 客户端握手：临时公钥 || 随机长度的填充 || 标记 || MAC；
+// 服务端回复的第一个帧只有持有长期私钥才能生成，用于认证服务端
+func (conn *obfsConn) clientHandshake() error {
+	serverKey, err := utils.BytesToPublicKey(conn.serverKey)
+	if err != nil {
+		return ErrObfsKey
+	}
+	keyPair, err := utils.NewElligatorKeyPair()
+	if err != nil {
+		return err
+	}
+	paddingLength, err := rand.Int(rand.Reader, big.NewInt(obfsMaxHello+1))
+	if err != nil {
+		return err
+	}
+	hello := make([]byte, 32+paddingLength.Int64())
+	copy(hello, keyPair.Representative)
+	rand.Read(hello[32:])
+	hello = append(hello, obfsMark(conn.serverKey, keyPair.Representative)...)
+	hello = append(hello, obfsMAC(conn.serverKey, hello, time.Now().Unix()/3600)...)
+	if _, err := conn.Conn.Write(hello); err != nil {
+		return err
+	}
+
+	peerRepresentative := make([]byte, 32)
+	if _, err := io.ReadFull(conn.reader, peerRepresentative); err != nil {
+		return err
+	}
+	if err := conn.deriveKeys(keyPair, serverKey, keyPair.Representative, peerRepresentative); err != nil {
+		return err
+	}
+	if _, err := conn.openFrame(); err != nil {
+		return ErrObfsHandshake
+	}
+	return nil
+}
+
+// 服务端验证客户端握手的 MAC 后才回复，验证失败时不发送任何数据，
+// 读取并丢弃之后的数据直到连接超时或关闭，与没有运行任何服务的端口无法区分；
+// 回复的公钥之后立即发送一个填充帧，使第一个数据包的长度不固定
+func (conn *obfsConn) serverHandshake() error {
+	peerRepresentative, err := conn.readClientHello()
+	if err != nil {
+		io.Copy(io.Discard, conn.reader)
+		return err
+	}
+	keyPair, err := utils.NewElligatorKeyPair()
+	if err != nil {
+		return err
+	}
+	if err := conn.deriveKeys(keyPair, nil, peerRepresentative, keyPair.Representative); err != nil {
+		return err
+	}
+	frame, err := conn.sealFrame(nil)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Conn.Write(append(keyPair.Representative, frame...))
+	return err
+}
+
+// 读取客户端握手，通过标记找到填充的结尾，返回客户端的 Elligator2 编码
+func (conn *obfsConn) readClientHello() ([]byte, error) {
+	hello := make([]byte, 32, 32+obfsMaxHello+2*obfsMarkSize)
+	if _, err := io.ReadFull(conn.reader, hello); err != nil {
+		return nil, err
+	}
+	mark := obfsMark(conn.serverKey, hello[:32])
+	for len(hello) < 32+obfsMarkSize || !bytes.Equal(hello[len(hello)-obfsMarkSize:], mark) {
+		if len(hello) >= 32+obfsMaxHello+obfsMarkSize {
+			return nil, ErrObfsHandshake
+		}
+		next, err := conn.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		hello = append(hello, next)
+	}
+
+	mac := make([]byte, obfsMarkSize)
+	if _, err := io.ReadFull(conn.reader, mac); err != nil {
+		return nil, err
+	}
+	hour := time.Now().Unix() / 3600
+	for offset := int64(-obfsMACWindow); offset <= obfsMACWindow; offset++ {
+		if hmac.Equal(mac, obfsMAC(conn.serverKey, hello, hour+offset)) {
+			if !conn.replay.check(mac) {
+				return nil, ErrObfsHandshake
+			}
+			return hello[:32], nil
+		}
+	}
+	return nil, ErrObfsHandshake
+}
+
+// 由临时公钥之间、客户端临时公钥与服务端长期公钥之间的两个 DH 结果派生两个方向的密钥；
+// 客户端传入服务端的长期公钥，服务端传入 nil 并使用自己的长期私钥
+func (conn *obfsConn) deriveKeys(keyPair *utils.ElligatorKeyPair, serverKey *ecdh.PublicKey, clientRepresentative, serverRepresentative []byte) error {
+	peerRepresentative := serverRepresentative
+	if !conn.isClient {
+		peerRepresentative = clientRepresentative
+	}
+	peerKey, err := utils.RepresentativeToPublicKey(peerRepresentative)
+	if err != nil {
+		return err
+	}
+	ephemeralSecret, err := keyPair.PrivateKey.ECDH(peerKey)
+	if err != nil {
+		return err
+	}
+	var staticSecret []byte
+	if conn.isClient {
+		staticSecret, err = keyPair.PrivateKey.ECDH(serverKey)
+	} else {
+		staticSecret, err = conn.identityKey.PrivateKey.ECDH(peerKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	secret := append(ephemeralSecret, staticSecret...)
+	salt := append(append(append([]byte{}, clientRepresentative...), serverRepresentative...), conn.serverKey...)
+	sendKey, recvKey := utils.DevirateChainKey(secret, salt)
+	if !conn.isClient {
+		sendKey, recvKey = recvKey, sendKey
+	}
+	if conn.send, err = newObfsCipher(sendKey); err != nil {
+		return err
+	}
+	conn.recv, err = newObfsCipher(recvKey)
+	return err
+}
+
+// 帧格式：2 字节掩盖后的长度 || AEAD(2 字节数据长度 || 数据 || 填充)，数据为空的帧只用于填充
+func (conn *obfsConn) sealFrame(payload []byte) ([]byte, error) {
+	paddingLength := 0
+	if conn.maxPadding > 0 {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(conn.maxPadding)+1))
+		if err != nil {
+			return nil, err
+		}
+		paddingLength = int(n.Int64())
+	}
+	overhead := 2 + conn.send.aead.Overhead()
+	paddingLength = min(paddingLength, obfsMaxFrame-overhead-len(payload))
+
+	plaintext := make([]byte, 2+len(payload)+paddingLength)
+	binary.BigEndian.PutUint16(plaintext, uint16(len(payload)))
+	copy(plaintext[2:], payload)
+	sealed := conn.send.aead.Seal(nil, conn.send.nextNonce(), plaintext, nil)
+
+	frame := make([]byte, 2, 2+len(sealed))
+	binary.BigEndian.PutUint16(frame, uint16(len(sealed)))
+	conn.send.mask.XORKeyStream(frame, frame)
+	return append(frame, sealed...), nil
+}
+
+// 读取一个帧并返回其中的数据，填充帧返回空数据
+func (conn *obfsConn) openFrame() ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn.reader, header); err != nil {
+		return nil, err
+	}
+	conn.recv.mask.XORKeyStream(header, header)
+	sealed := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(conn.reader, sealed); err != nil {
+		return nil, err
+	}
+	plaintext, err := conn.recv.aead.Open(nil, conn.recv.nextNonce(), sealed, nil)
+	if err != nil || len(plaintext) < 2 {
+		return nil, ErrObfsFrame
+	}
+	length := int(binary.BigEndian.Uint16(plaintext))
+	if 2+length > len(plaintext) {
+		return nil, ErrObfsFrame
+	}
+	return plaintext[2 : 2+length], nil
+}
+
+func (conn *obfsConn) Read(b []byte) (int, error) {
+	if err := conn.handshake(); err != nil {
+		return 0, err
+	}
+	conn.readMutex.Lock()
+	defer conn.readMutex.Unlock()
+
+	for len(conn.readBuffer) == 0 {
+		payload, err := conn.openFrame()
+		if err != nil {
+			return 0, err
+		}
+		conn.readBuffer = payload
+	}
+	n := copy(b, conn.readBuffer)
+	conn.readBuffer = conn.readBuffer[n:]
+	return n, nil
+}
+
+// 一次写入的所有帧合并后写入底层连接
+func (conn *obfsConn) Write(b []byte) (int, error) {
+	if err := conn.handshake(); err != nil {
+		return 0, err
+	}
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	frames := []byte{}
+	for offset := 0; offset < len(b); offset += obfsMaxPayload {
+		frame, err := conn.sealFrame(b[offset:min(offset+obfsMaxPayload, len(b))])
+		if err != nil {
+			return 0, err
+		}
+		frames = append(frames, frame...)
+	}
+	if _, err := conn.Conn.Write(frames); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
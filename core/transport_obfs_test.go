@@ -0,0 +1,140 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 记录第一次写入的数据，即客户端握手
+type recordConn struct {
+	net.Conn
+	hello []byte
+}
+
+func (conn *recordConn) Write(b []byte) (int, error) {
+	if conn.hello == nil {
+		conn.hello = append([]byte(nil), b...)
+	}
+	return conn.Conn.Write(b)
+}
+
+func newTestObfsTransport() (*ObfsTransport, *PipeTransport) {
+	pipe := NewPipeTransport()
+	identityKey := utils.NewDiffeHellmanKeyPair()
+	transport := NewObfsTransport(pipe)
+	transport.IdentityKey = identityKey
+	transport.ServerKey = identityKey.PublicKey.Bytes()
+	return transport, pipe
+}
+
+// 接受一条连接并在后台读取，使服务端进行握手；返回读取的结果
+func acceptAndRead(t *testing.T, listener net.Listener) chan error {
+	t.Helper()
+
+	result := make(chan error, 1)
+	go func() {
+		connect, err := listener.Accept()
+		if err != nil {
+			result <- err
+			return
+		}
+		defer connect.Close()
+		_, err = connect.Read(make([]byte, 1))
+		result <- err
+	}()
+	return result
+}
+
+// 等待对端的回复，直到超时都没有收到任何数据
+func expectSilence(t *testing.T, connect net.Conn) {
+	t.Helper()
+
+	connect.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	n, err := connect.Read(make([]byte, 1))
+	var netErr net.Error
+	if n != 0 || !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("期望对端不回复，实际读取 %d 字节，错误 %v", n, err)
+	}
+}
+
+// 数据被切分为多个帧，在管道传输层上完整地往返
+func TestObfsTransport(t *testing.T) {
+	transport, _ := newTestObfsTransport()
+	testTransportRoundTrip(t, transport, "server", listenerAddress)
+}
+
+// 使用错误的服务端公钥时，服务端不回复任何数据
+func TestObfsWrongServerKey(t *testing.T) {
+	transport, pipe := newTestObfsTransport()
+	listener, err := transport.Listen("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	result := acceptAndRead(t, listener)
+
+	client := NewObfsTransport(pipe)
+	client.ServerKey = utils.NewDiffeHellmanKeyPair().PublicKey.Bytes()
+	connect, err := client.Dial("client", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect.SetDeadline(time.Now().Add(300 * time.Millisecond))
+	_, err = connect.Write([]byte("probe"))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("期望握手超时，实际返回 %v", err)
+	}
+
+	connect.Close()
+	if err := <-result; err == nil {
+		t.Fatal("服务端接受了使用错误公钥的握手")
+	}
+}
+
+// 重放之前成功的客户端握手时，服务端不回复任何数据
+func TestObfsReplayedHello(t *testing.T) {
+	transport, pipe := newTestObfsTransport()
+	listener, err := transport.Listen("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	result := acceptAndRead(t, listener)
+	raw, err := pipe.Dial("client", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &recordConn{Conn: raw}
+	first := newObfsConn(recorder, true, transport.MaxPadding)
+	first.serverKey = transport.ServerKey
+	first.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := first.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	result = acceptAndRead(t, listener)
+	replay, err := pipe.Dial("client", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := replay.Write(recorder.hello); err != nil {
+		t.Fatal(err)
+	}
+	expectSilence(t, replay)
+
+	replay.Close()
+	if err := <-result; err == nil {
+		t.Fatal("服务端接受了重放的握手")
+	}
+}
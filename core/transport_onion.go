@@ -0,0 +1,33 @@
+package core
+
+import (
+	"net"
+	"time"
+
+	"github.com/libp2p/go-reuseport"
+	"github.com/reagin/double_ratchet/relay"
+)
+
+// 经过 2 到 3 个中继的洋葱线路拨号，每个中继只解开一层加密，
+// 对端与各个中继都无法同时得知双方的网络地址
+type OnionTransport struct {
+	Path    []relay.OnionHop // 按顺序经过的中继，最后一个中继连接对端
+	Timeout time.Duration    // 建立线路的超时时间
+}
+
+func NewOnionTransport(path []relay.OnionHop) *OnionTransport {
+	return &OnionTransport{
+		Path:    path,
+		Timeout: 10 * time.Second,
+	}
+}
+
+// 线路由中继发起连接，不使用 localAddress
+func (transport *OnionTransport) Dial(localAddress, remoteAddress string) (net.Conn, error) {
+	return relay.DialCircuit(transport.Path, remoteAddress, transport.Timeout)
+}
+
+// 接收方的连接来自出口中继，与普通 TCP 连接相同
+func (transport *OnionTransport) Listen(localAddress string) (net.Listener, error) {
+	return reuseport.Listen("tcp", localAddress)
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+var ErrPipeRefused = errors.New("目标地址没有处于监听状态的管道")
+
+// 进程内的内存管道传输层，便于在测试中直接连接客户端与服务端
+type PipeTransport struct {
+	mutex     sync.Mutex
+	listeners map[string]*pipeListener
+}
+
+type pipeAddr string
+
+type pipeConn struct {
+	net.Conn
+	localAddr  pipeAddr
+	remoteAddr pipeAddr
+}
+
+type pipeListener struct {
+	address   pipeAddr
+	transport *PipeTransport
+	closeOnce sync.Once
+	closeChan chan bool
+	connChan  chan net.Conn
+}
+
+func NewPipeTransport() *PipeTransport {
+	return &PipeTransport{
+		listeners: make(map[string]*pipeListener),
+	}
+}
+
+func (transport *PipeTransport) Dial(localAddress, remoteAddress string) (net.Conn, error) {
+	transport.mutex.Lock()
+	listener, ok := transport.listeners[remoteAddress]
+	transport.mutex.Unlock()
+	if !ok {
+		return nil, ErrPipeRefused
+	}
+
+	localConn, remoteConn := net.Pipe()
+	select {
+	case listener.connChan <- &pipeConn{remoteConn, pipeAddr(remoteAddress), pipeAddr(localAddress)}:
+		return &pipeConn{localConn, pipeAddr(localAddress), pipeAddr(remoteAddress)}, nil
+	case <-listener.closeChan:
+		return nil, ErrPipeRefused
+	}
+}
+
+func (transport *PipeTransport) Listen(localAddress string) (net.Listener, error) {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	if _, ok := transport.listeners[localAddress]; ok {
+		return nil, errors.New("管道地址已被占用: " + localAddress)
+	}
+	listener := &pipeListener{
+		address:   pipeAddr(localAddress),
+		transport: transport,
+		closeChan: make(chan bool),
+		connChan:  make(chan net.Conn),
+	}
+	transport.listeners[localAddress] = listener
+
+	return listener, nil
+}
+
+func (addr pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (addr pipeAddr) String() string {
+	return string(addr)
+}
+
+func (conn *pipeConn) LocalAddr() net.Addr {
+	return conn.localAddr
+}
+
+func (conn *pipeConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+func (listener *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case connect := <-listener.connChan:
+		return connect, nil
+	case <-listener.closeChan:
+		return nil, net.ErrClosed
+	}
+}
+
+func (listener *pipeListener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.closeChan)
+
+		listener.transport.mutex.Lock()
+		delete(listener.transport.listeners, string(listener.address))
+		listener.transport.mutex.Unlock()
+	})
+	return nil
+}
+
+func (listener *pipeListener) Addr() net.Addr {
+	return listener.address
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-reuseport"
+	"golang.org/x/net/proxy"
+)
+
+// Tor 默认的 SocksPort
+const TorSocksAddress = "127.0.0.1:9050"
+
+// 通过 SOCKS5 代理拨号的传输层，对端只能看到代理的地址，
+// 域名 (包括 .onion 地址) 交给代理解析，本机不发出 DNS 查询
+type SOCKSTransport struct {
+	ProxyAddress   string
+	Username       string
+	Password       string
+	IsolateStreams bool          // 以对端地址作为认证信息，Tor 会为每个对端使用独立的线路
+	Timeout        time.Duration // 连接代理的超时时间
+	Control        *TorControl   // 不为 nil 时监听的同时通过控制端口发布洋葱服务
+	OnionAddress   string        // 洋葱服务的地址，Listen 成功后设置
+}
+
+// 关闭监听时同时移除洋葱服务
+type onionServiceListener struct {
+	net.Listener
+	control   *TorControl
+	serviceID string
+	closeOnce sync.Once
+}
+
+func NewSOCKSTransport(proxyAddress string) *SOCKSTransport {
+	return &SOCKSTransport{
+		ProxyAddress: proxyAddress,
+		Timeout:      30 * time.Second,
+	}
+}
+
+// 使用 Tor 的 SocksPort 并为每个对端隔离线路
+func NewTorTransport() *SOCKSTransport {
+	transport := NewSOCKSTransport(TorSocksAddress)
+	transport.IsolateStreams = true
+	return transport
+}
+
+// 连接由代理发起，不使用 localAddress
+func (transport *SOCKSTransport) Dial(localAddress, remoteAddress string) (net.Conn, error) {
+	dialer, err := proxy.SOCKS5("tcp", transport.ProxyAddress, transport.auth(remoteAddress), &net.Dialer{Timeout: transport.Timeout})
+	if err != nil {
+		return nil, err
+	}
+	return dialer.Dial("tcp", remoteAddress)
+}
+
+// Tor 默认按 SOCKS 认证信息隔离线路 (IsolateSOCKSAuth)，
+// 不同对端的连接不会共用同一条线路，出口节点无法将它们关联起来
+func (transport *SOCKSTransport) auth(remoteAddress string) *proxy.Auth {
+	if transport.IsolateStreams {
+		username := transport.Username
+		if username == "" {
+			username = "doubleratchet"
+		}
+		return &proxy.Auth{User: username, Password: remoteAddress}
+	}
+	if transport.Username == "" {
+		return nil
+	}
+	return &proxy.Auth{User: transport.Username, Password: transport.Password}
+}
+
+// 在本地监听，设置了控制端口时将洋葱服务的同一端口映射到本地监听地址
+func (transport *SOCKSTransport) Listen(localAddress string) (net.Listener, error) {
+	listener, err := reuseport.Listen("tcp", localAddress)
+	if err != nil {
+		return nil, err
+	}
+	if transport.Control == nil {
+		return listener, nil
+	}
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	target := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	serviceID, err := transport.Control.AddOnion(port, target)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	transport.OnionAddress = net.JoinHostPort(serviceID+".onion", strconv.Itoa(port))
+
+	return &onionServiceListener{
+		Listener:  listener,
+		control:   transport.Control,
+		serviceID: serviceID,
+	}, nil
+}
+
+func (listener *onionServiceListener) Close() error {
+	listener.closeOnce.Do(func() {
+		listener.control.DelOnion(listener.serviceID)
+	})
+	return listener.Listener.Close()
+}
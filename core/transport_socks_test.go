@@ -0,0 +1,191 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 代理收到的认证信息与目标地址
+type socksRequest struct {
+	username string
+	password string
+	target   string
+}
+
+// 把收到的数据原样发回的目标
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			connect, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer connect.Close()
+				io.Copy(connect, connect)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// 最简单的 SOCKS5 代理，记录每个连接的认证信息与目标地址，所有连接都转发给 upstream
+func startSOCKSServer(t *testing.T, upstream string) (string, <-chan socksRequest) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	requests := make(chan socksRequest, 16)
+	go func() {
+		for {
+			connect, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleSOCKS(connect, upstream, requests)
+		}
+	}()
+	return listener.Addr().String(), requests
+}
+
+func handleSOCKS(connect net.Conn, upstream string, requests chan<- socksRequest) {
+	defer connect.Close()
+	reader := bufio.NewReader(connect)
+	readBytes := func(n int) []byte {
+		buffer := make([]byte, n)
+		if _, err := io.ReadFull(reader, buffer); err != nil {
+			return nil
+		}
+		return buffer
+	}
+
+	header := readBytes(2)
+	if header == nil || header[0] != 5 {
+		return
+	}
+	request := socksRequest{}
+	methods := readBytes(int(header[1]))
+	if slices.Contains(methods, 2) {
+		// RFC 1929 用户名密码认证
+		connect.Write([]byte{5, 2})
+		version := readBytes(2)
+		if version == nil {
+			return
+		}
+		request.username = string(readBytes(int(version[1])))
+		request.password = string(readBytes(int(readBytes(1)[0])))
+		connect.Write([]byte{1, 0})
+	} else {
+		connect.Write([]byte{5, 0})
+	}
+
+	command := readBytes(4)
+	if command == nil || command[1] != 1 {
+		return
+	}
+	var host string
+	switch command[3] {
+	case 1:
+		host = net.IP(readBytes(4)).String()
+	case 3:
+		host = string(readBytes(int(readBytes(1)[0])))
+	case 4:
+		host = net.IP(readBytes(16)).String()
+	}
+	port := binary.BigEndian.Uint16(readBytes(2))
+	request.target = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	requests <- request
+
+	target, err := net.Dial("tcp", upstream)
+	if err != nil {
+		connect.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	connect.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go func() {
+		io.Copy(target, reader)
+		target.Close()
+	}()
+	io.Copy(connect, target)
+}
+
+func dialSOCKS(t *testing.T, transport *SOCKSTransport, remoteAddress string, requests <-chan socksRequest) socksRequest {
+	t.Helper()
+	connect, err := transport.Dial("", remoteAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connect.Close()
+
+	connect.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := connect.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 4)
+	if _, err := io.ReadFull(connect, buffer); err != nil || string(buffer) != "ping" {
+		t.Fatalf("got %q, %v", buffer, err)
+	}
+	select {
+	case request := <-requests:
+		return request
+	case <-time.After(time.Second):
+		t.Fatal("the proxy received no request")
+		return socksRequest{}
+	}
+}
+
+func TestSOCKSStreamIsolation(t *testing.T) {
+	proxyAddress, requests := startSOCKSServer(t, startEchoServer(t))
+	transport := NewSOCKSTransport(proxyAddress)
+	transport.IsolateStreams = true
+	transport.Timeout = 2 * time.Second
+
+	// 域名交给代理解析，不同的对端使用不同的认证信息
+	first := dialSOCKS(t, transport, "abcdefghijklmnop.onion:9090", requests)
+	second := dialSOCKS(t, transport, "qrstuvwxyzabcdef.onion:9090", requests)
+	again := dialSOCKS(t, transport, "abcdefghijklmnop.onion:9090", requests)
+	if first.target != "abcdefghijklmnop.onion:9090" || second.target != "qrstuvwxyzabcdef.onion:9090" {
+		t.Fatalf("the proxy received %q and %q", first.target, second.target)
+	}
+	if first.username == "" || first == second {
+		t.Fatalf("streams to different peers share credentials: %+v, %+v", first, second)
+	}
+	if first != again {
+		t.Fatalf("streams to the same peer use different credentials: %+v, %+v", first, again)
+	}
+
+	// 指定的用户名同样参与隔离
+	transport.Username = "alice"
+	if request := dialSOCKS(t, transport, "abcdefghijklmnop.onion:9090", requests); request.username != "alice" || request.password != first.password {
+		t.Fatalf("unexpected credentials %+v", request)
+	}
+}
+
+func TestSOCKSCredentials(t *testing.T) {
+	proxyAddress, requests := startSOCKSServer(t, startEchoServer(t))
+	transport := NewSOCKSTransport(proxyAddress)
+	transport.Timeout = 2 * time.Second
+
+	if request := dialSOCKS(t, transport, "example.com:80", requests); request.username != "" || request.target != "example.com:80" {
+		t.Fatalf("unexpected request %+v", request)
+	}
+	transport.Username, transport.Password = "user", "secret"
+	if request := dialSOCKS(t, transport, "example.com:80", requests); request.username != "user" || request.password != "secret" {
+		t.Fatalf("unexpected credentials %+v", request)
+	}
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 在传输层上建立一条连接，双向发送数据并确认字节流完整
+func testTransportRoundTrip(t *testing.T, transport Transport, listenAddress string, dialAddress func(net.Listener) string) {
+	t.Helper()
+
+	listener, err := transport.Listen(listenAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		connect, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- connect
+	}()
+
+	client, err := transport.Dial("", dialAddress(listener))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var server net.Conn
+	select {
+	case server = <-accepted:
+		if server == nil {
+			t.Fatal("接受连接失败")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待连接超时")
+	}
+	defer server.Close()
+
+	request := bytes.Repeat([]byte("request "), 8<<10)
+	reply := []byte("reply")
+	go func() {
+		client.Write(request)
+	}()
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+	received := make([]byte, len(request))
+	if _, err := io.ReadFull(server, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, request) {
+		t.Fatal("服务端收到的数据与发送的不一致")
+	}
+
+	go func() {
+		server.Write(reply)
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	received = make([]byte, len(reply))
+	if _, err := io.ReadFull(client, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, reply) {
+		t.Fatal("客户端收到的数据与发送的不一致")
+	}
+
+	// 对端关闭后读取返回错误
+	client.Close()
+	if _, err := server.Read(received); err == nil {
+		t.Fatal("对端关闭后读取没有返回错误")
+	}
+}
+
+func listenerAddress(listener net.Listener) string {
+	return listener.Addr().String()
+}
+
+func TestTCPTransport(t *testing.T) {
+	testTransportRoundTrip(t, NewTCPTransport(), "127.0.0.1:0", listenerAddress)
+}
+
+func TestUnixTransport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratchet.sock")
+	testTransportRoundTrip(t, NewUnixTransport(), path, listenerAddress)
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	testTransportRoundTrip(t, NewWebSocketTransport("/ratchet"), "127.0.0.1:0", listenerAddress)
+}
+
+func TestPipeTransport(t *testing.T) {
+	testTransportRoundTrip(t, NewPipeTransport(), "server", listenerAddress)
+
+	if _, err := NewPipeTransport().Dial("client", "missing"); err != ErrPipeRefused {
+		t.Fatalf("拨号未监听的地址返回 %v，期望 %v", err, ErrPipeRefused)
+	}
+}
+
+// 只发送部分请求头的连接在超时后被服务端关闭
+func TestWebSocketHeaderTimeout(t *testing.T) {
+	transport := NewWebSocketTransport("/ratchet")
+	transport.HandshakeTimeout = 200 * time.Millisecond
+	listener, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	connect, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connect.Close()
+	connect.Write([]byte("GET /ratchet HTTP/1.1\r\nHost: slow\r\n"))
+	connect.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(connect); err != nil {
+		t.Fatalf("服务端没有在超时后关闭连接: %v", err)
+	}
+}
@@ -0,0 +1,639 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/libp2p/go-reuseport"
+)
+
+const (
+	udpDataPacket = iota + 1
+	udpAckPacket
+	udpClosePacket
+)
+
+const (
+	udpHeaderSize      = 18       // 类型(1) + 标志(1) + 连接令牌(8) + 消息编号(4) + 分片序号(2) + 分片总数(2)
+	udpOverheadIPv4    = 28       // IPv4 头部与 UDP 头部
+	udpOverheadIPv6    = 48       // IPv6 头部与 UDP 头部
+	udpDefaultMTU      = 1280     // IPv6 要求的最小 MTU，作为无法探测时的保守取值
+	udpMinMTU          = 576      // IPv4 要求的最小 MTU，配置的 MTU 不会低于该值
+	udpMaxMessage      = 64 << 10 // 单条消息的最大字节数，更长的写入拆分为多条消息
+	udpMaxReassembly   = 16       // 每个连接同时重组或等待按序交付的最大消息数
+	udpMaxConns        = 256      // 每个监听器同时维护的最大连接数
+	udpReliableFlag    = 1
+	udpReassemblyLimit = 30 * time.Second // 未完成重组的分片保留时间
+	udpDeliveredLimit  = 60 * time.Second // 已交付消息编号的保留时间，用于去重
+)
+
+// 由最小 MTU 与最大消息长度确定的分片数上限，超过上限的分片直接丢弃，不为其分配重组缓冲
+const udpMaxFragments = (udpMaxMessage + udpMinMTU - udpOverheadIPv6 - udpHeaderSize - 1) / (udpMinMTU - udpOverheadIPv6 - udpHeaderSize)
+
+var ErrUDPPeerUnreachable = errors.New("重传次数耗尽，对端无响应")
+
+// 基于 UDP 的数据报传输层，每次写入对应一条消息，超过路径 MTU 时自动分片；
+// 拨号方为每个连接生成随机的连接令牌，双方的所有数据包都携带该令牌，令牌不一致的数据包
+// (包括伪造的关闭包) 直接丢弃。可靠模式下消息按编号顺序交付，重传不会打乱字节流的顺序；
+// 不可靠模式下握手帧丢失时，握手在会话的握手超时后失败
+type UDPTransport struct {
+	Reliable          bool          // 是否启用确认与重传
+	RetransmitTimeout time.Duration // 未收到确认时的重传间隔
+	MaxRetransmits    int           // 单个分片的最大重传次数
+	MTU               int           // 初始路径 MTU，为 0 时由内核探测
+}
+
+type udpPending struct {
+	packet   []byte
+	sendTime time.Time
+	retries  int
+}
+
+type udpFragments struct {
+	parts    [][]byte
+	received int
+	lastSeen time.Time
+}
+
+// 数据包头部
+type udpHeader struct {
+	packetType byte
+	flags      byte
+	token      uint64
+	messageID  uint32
+	index      uint16
+	total      uint16
+}
+
+// 面向消息的 UDP 连接，不超过 udpMaxMessage 的单次 Write 作为一条完整消息发送
+type udpConn struct {
+	transport  *UDPTransport
+	packetConn *net.UDPConn
+	localAddr  net.Addr
+	remoteAddr *net.UDPAddr
+	isDialed   bool   // 拨号得到的连接独占套接字，监听得到的连接共用监听套接字
+	onClose    func() // 关闭时的回调，用于从监听器中移除连接
+	token      uint64 // 连接令牌，由拨号方生成
+	mutex      sync.Mutex
+	mtu        int
+	nextID     uint32
+	pending    map[uint64]*udpPending   // 等待确认的分片，键为 消息编号<<16|分片序号
+	fragments  map[uint32]*udpFragments // 重组中的消息
+	delivered  map[uint32]time.Time     // 不可靠模式下已交付的消息编号，避免重复交付
+	completed  map[uint32][]byte        // 可靠模式下已重组但排在未完成消息之后的消息
+	nextRecv   uint32                   // 可靠模式下下一条应交付的消息编号
+	recvChan   chan []byte
+	readBuffer []byte
+	closeErr   error
+	closeOnce  sync.Once
+	closeChan  chan bool
+	deadline   time.Time
+}
+
+type udpListener struct {
+	transport  *UDPTransport
+	packetConn *net.UDPConn
+	mutex      sync.Mutex
+	conns      map[string]*udpConn
+	closeOnce  sync.Once
+	closeChan  chan bool
+	connChan   chan net.Conn
+}
+
+func NewUDPTransport(reliable bool) *UDPTransport {
+	return &UDPTransport{
+		Reliable:          reliable,
+		RetransmitTimeout: 300 * time.Millisecond,
+		MaxRetransmits:    10,
+	}
+}
+
+func (transport *UDPTransport) Dial(localAddress, remoteAddress string) (net.Conn, error) {
+	connect, err := reuseport.Dial("udp", localAddress, remoteAddress)
+	if err != nil {
+		return nil, err
+	}
+	packetConn := connect.(*net.UDPConn)
+	setPathMTUDiscovery(packetConn)
+
+	tokenBytes := make([]byte, 8)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		packetConn.Close()
+		return nil, err
+	}
+	conn := transport.newConn(packetConn, packetConn.RemoteAddr().(*net.UDPAddr), binary.LittleEndian.Uint64(tokenBytes), true)
+	// 已连接的套接字可以直接向内核查询路径 MTU
+	if conn.mtu == 0 {
+		conn.mtu = queryPathMTU(packetConn)
+	}
+	go conn.readLoop()
+
+	return conn, nil
+}
+
+func (transport *UDPTransport) Listen(localAddress string) (net.Listener, error) {
+	packetConn, err := reuseport.ListenPacket("udp", localAddress)
+	if err != nil {
+		return nil, err
+	}
+	setPathMTUDiscovery(packetConn.(*net.UDPConn))
+
+	listener := &udpListener{
+		transport:  transport,
+		packetConn: packetConn.(*net.UDPConn),
+		conns:      make(map[string]*udpConn),
+		closeChan:  make(chan bool),
+		connChan:   make(chan net.Conn, 8),
+	}
+	go listener.readLoop()
+
+	return listener, nil
+}
+
+func (transport *UDPTransport) newConn(packetConn *net.UDPConn, remoteAddr *net.UDPAddr, token uint64, isDialed bool) *udpConn {
+	conn := &udpConn{
+		transport:  transport,
+		packetConn: packetConn,
+		localAddr:  packetConn.LocalAddr(),
+		remoteAddr: remoteAddr,
+		isDialed:   isDialed,
+		token:      token,
+		mtu:        transport.MTU,
+		pending:    make(map[uint64]*udpPending),
+		fragments:  make(map[uint32]*udpFragments),
+		delivered:  make(map[uint32]time.Time),
+		completed:  make(map[uint32][]byte),
+		recvChan:   make(chan []byte, 64),
+		closeChan:  make(chan bool),
+	}
+	if transport.Reliable {
+		go conn.retransmitLoop()
+	}
+	return conn
+}
+
+func (listener *udpListener) Accept() (net.Conn, error) {
+	select {
+	case connect := <-listener.connChan:
+		return connect, nil
+	case <-listener.closeChan:
+		return nil, net.ErrClosed
+	}
+}
+
+func (listener *udpListener) Close() error {
+	var err error
+	listener.closeOnce.Do(func() {
+		close(listener.closeChan)
+		err = listener.packetConn.Close()
+	})
+	return err
+}
+
+func (listener *udpListener) Addr() net.Addr {
+	return listener.packetConn.LocalAddr()
+}
+
+// 按来源地址分发数据报，收到未知地址的数据包时以其中的令牌创建新连接，
+// 连接数达到上限时丢弃来自新地址的数据包
+func (listener *udpListener) readLoop() {
+	buffer := make([]byte, 1<<16)
+	for {
+		n, remoteAddr, err := listener.packetConn.ReadFromUDP(buffer)
+		if err != nil {
+			listener.closeConns(err)
+			return
+		}
+		if n < udpHeaderSize {
+			continue
+		}
+
+		listener.mutex.Lock()
+		conn, ok := listener.conns[remoteAddr.String()]
+		if !ok && buffer[0] == udpDataPacket && len(listener.conns) < udpMaxConns {
+			conn = listener.transport.newConn(listener.packetConn, remoteAddr, decodeUDPHeader(buffer).token, false)
+			address := remoteAddr.String()
+			conn.onClose = func() {
+				listener.mutex.Lock()
+				delete(listener.conns, address)
+				listener.mutex.Unlock()
+			}
+			listener.conns[address] = conn
+			select {
+			case listener.connChan <- conn:
+			default:
+				// 等待 Accept 的连接过多时丢弃新连接
+				delete(listener.conns, address)
+				conn = nil
+			}
+		}
+		listener.mutex.Unlock()
+
+		if conn != nil {
+			conn.handlePacket(append([]byte(nil), buffer[:n]...))
+		}
+	}
+}
+
+func (listener *udpListener) closeConns(err error) {
+	listener.mutex.Lock()
+	conns := make([]*udpConn, 0, len(listener.conns))
+	for _, conn := range listener.conns {
+		conns = append(conns, conn)
+	}
+	listener.mutex.Unlock()
+
+	for _, conn := range conns {
+		conn.closeWithError(err)
+	}
+}
+
+// 独占套接字的连接自行读取数据报
+func (conn *udpConn) readLoop() {
+	buffer := make([]byte, 1<<16)
+	for {
+		n, err := conn.packetConn.Read(buffer)
+		if err != nil {
+			conn.closeWithError(err)
+			return
+		}
+		if n < udpHeaderSize {
+			continue
+		}
+		conn.handlePacket(append([]byte(nil), buffer[:n]...))
+	}
+}
+
+// 令牌不一致的数据包来自伪造的来源，直接丢弃
+func (conn *udpConn) handlePacket(packet []byte) {
+	header := decodeUDPHeader(packet)
+	if header.token != conn.token {
+		return
+	}
+
+	switch header.packetType {
+	case udpAckPacket:
+		conn.mutex.Lock()
+		delete(conn.pending, uint64(header.messageID)<<16|uint64(header.index))
+		conn.mutex.Unlock()
+	case udpClosePacket:
+		conn.closeWithError(io.EOF)
+	case udpDataPacket:
+		if header.total == 0 || header.index >= header.total || header.total > udpMaxFragments {
+			return
+		}
+		// 监听套接字上的所有连接共用一个读取循环，不能因为某个连接读取缓慢而阻塞；
+		// 接收队列的剩余空间不足以容纳一次按序交付的所有消息时丢弃分片且不回复确认，
+		// 可靠模式下由对端稍后重传
+		if cap(conn.recvChan)-len(conn.recvChan) < udpMaxReassembly {
+			return
+		}
+		isReliable := header.flags&udpReliableFlag != 0
+		messages, accepted := conn.reassemble(header, packet[udpHeaderSize:], isReliable)
+		if !accepted {
+			return
+		}
+		if isReliable {
+			conn.sendPacket(encodeUDPHeader(udpAckPacket, 0, conn.token, header.messageID, header.index, header.total))
+		}
+		for _, message := range messages {
+			select {
+			case conn.recvChan <- message:
+			default:
+			}
+		}
+	}
+}
+
+// 缓存分片，返回可以交付的完整消息，以及是否接受了该分片 (重复的分片视为已接受，需要再次确认)；
+// 可靠模式下只接受从下一条应交付的消息起 udpMaxReassembly 条以内的消息，并按编号顺序交付
+func (conn *udpConn) reassemble(header udpHeader, payload []byte, isReliable bool) ([][]byte, bool) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	now := time.Now()
+	// 清理过期的分片与去重记录
+	for id, fragments := range conn.fragments {
+		if now.Sub(fragments.lastSeen) > udpReassemblyLimit {
+			delete(conn.fragments, id)
+		}
+	}
+	for id, deliveredTime := range conn.delivered {
+		if now.Sub(deliveredTime) > udpDeliveredLimit {
+			delete(conn.delivered, id)
+		}
+	}
+
+	messageID := header.messageID
+	if isReliable {
+		offset := messageID - conn.nextRecv
+		if offset >= 1<<31 {
+			return nil, true
+		}
+		if offset >= udpMaxReassembly {
+			return nil, false
+		}
+		if _, ok := conn.completed[messageID]; ok {
+			return nil, true
+		}
+	} else if _, ok := conn.delivered[messageID]; ok {
+		return nil, true
+	}
+
+	fragments, ok := conn.fragments[messageID]
+	// 发送方因 MTU 减小重新切分消息时分片总数会变化，以新的切分为准
+	if !ok || int(header.total) != len(fragments.parts) {
+		if !ok && len(conn.fragments)+len(conn.completed) >= udpMaxReassembly {
+			return nil, false
+		}
+		fragments = &udpFragments{parts: make([][]byte, header.total)}
+		conn.fragments[messageID] = fragments
+	}
+	if fragments.parts[header.index] != nil {
+		return nil, true
+	}
+	fragments.parts[header.index] = payload
+	fragments.received++
+	fragments.lastSeen = now
+	if fragments.received < len(fragments.parts) {
+		return nil, true
+	}
+
+	delete(conn.fragments, messageID)
+	message := []byte{}
+	for _, part := range fragments.parts {
+		message = append(message, part...)
+	}
+	if !isReliable {
+		conn.delivered[messageID] = now
+		return [][]byte{message}, true
+	}
+
+	conn.completed[messageID] = message
+	var messages [][]byte
+	for {
+		message, ok := conn.completed[conn.nextRecv]
+		if !ok {
+			break
+		}
+		delete(conn.completed, conn.nextRecv)
+		messages = append(messages, message)
+		conn.nextRecv++
+	}
+	return messages, true
+}
+
+func (conn *udpConn) Read(b []byte) (int, error) {
+	if len(conn.readBuffer) == 0 {
+		conn.mutex.Lock()
+		deadline := conn.deadline
+		conn.mutex.Unlock()
+
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case message := <-conn.recvChan:
+			conn.readBuffer = message
+		case <-conn.closeChan:
+			return 0, conn.closeErr
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+
+	n := copy(b, conn.readBuffer)
+	conn.readBuffer = conn.readBuffer[n:]
+	return n, nil
+}
+
+// 超过 udpMaxMessage 的写入拆分为多条消息
+func (conn *udpConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) || len(b) == 0 {
+		end := min(written+udpMaxMessage, len(b))
+		conn.mutex.Lock()
+		messageID := conn.nextID
+		conn.nextID++
+		conn.mutex.Unlock()
+
+		if err := conn.writeChunk(messageID, b[written:end]); err != nil {
+			return written, err
+		}
+		written = end
+		if len(b) == 0 {
+			break
+		}
+	}
+	return written, nil
+}
+
+// 按当前路径 MTU 切分消息，数据报过大时以新的 MTU 重新切分，消息编号保持不变
+func (conn *udpConn) writeChunk(messageID uint32, b []byte) error {
+	for {
+		select {
+		case <-conn.closeChan:
+			return conn.closeErr
+		default:
+		}
+
+		err := conn.writeMessage(messageID, b)
+		if !errors.Is(err, syscall.EMSGSIZE) {
+			return err
+		}
+
+		conn.mutex.Lock()
+		current := conn.mtu
+		if current <= 0 {
+			current = udpDefaultMTU
+		}
+		mtu := udpDefaultMTU
+		if conn.isDialed {
+			mtu = queryPathMTU(conn.packetConn)
+		}
+		// MTU 无法继续减小时放弃发送
+		if mtu >= current {
+			conn.mutex.Unlock()
+			return err
+		}
+		conn.mtu = mtu
+		conn.mutex.Unlock()
+		log.Printf("⚠️ 数据报超过路径 MTU，调整为 %d\n", mtu)
+	}
+}
+
+// 可靠模式下记录分片等待确认
+func (conn *udpConn) writeMessage(messageID uint32, b []byte) error {
+	conn.mutex.Lock()
+	payloadSize := conn.payloadSize()
+	conn.mutex.Unlock()
+
+	total := max((len(b)+payloadSize-1)/payloadSize, 1)
+
+	var flags byte
+	if conn.transport.Reliable {
+		flags = udpReliableFlag
+	}
+	for index := range total {
+		end := min((index+1)*payloadSize, len(b))
+		packet := encodeUDPHeader(udpDataPacket, flags, conn.token, messageID, uint16(index), uint16(total))
+		packet = append(packet, b[index*payloadSize:end]...)
+
+		if conn.transport.Reliable {
+			conn.mutex.Lock()
+			conn.pending[uint64(messageID)<<16|uint64(index)] = &udpPending{packet: packet, sendTime: time.Now()}
+			conn.mutex.Unlock()
+		}
+		if err := conn.sendPacket(packet); err != nil {
+			// 丢弃这条消息已登记的分片，由调用方重新切分
+			conn.mutex.Lock()
+			for i := range total {
+				delete(conn.pending, uint64(messageID)<<16|uint64(i))
+			}
+			conn.mutex.Unlock()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (conn *udpConn) sendPacket(packet []byte) error {
+	if conn.isDialed {
+		_, err := conn.packetConn.Write(packet)
+		return err
+	}
+	_, err := conn.packetConn.WriteToUDP(packet, conn.remoteAddr)
+	return err
+}
+
+// 根据路径 MTU 计算单个分片可以承载的数据长度
+func (conn *udpConn) payloadSize() int {
+	mtu := conn.mtu
+	if mtu <= 0 {
+		mtu = udpDefaultMTU
+	}
+	mtu = max(mtu, udpMinMTU)
+	overhead := udpOverheadIPv4
+	if conn.remoteAddr.IP.To4() == nil {
+		overhead = udpOverheadIPv6
+	}
+	return mtu - overhead - udpHeaderSize
+}
+
+// 定期重传超时未确认的分片，超过最大次数后关闭连接
+func (conn *udpConn) retransmitLoop() {
+	ticker := time.NewTicker(conn.transport.RetransmitTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.closeChan:
+			return
+		case now := <-ticker.C:
+			var packets [][]byte
+			conn.mutex.Lock()
+			for _, pending := range conn.pending {
+				if now.Sub(pending.sendTime) < conn.transport.RetransmitTimeout {
+					continue
+				}
+				if pending.retries >= conn.transport.MaxRetransmits {
+					conn.mutex.Unlock()
+					conn.closeWithError(ErrUDPPeerUnreachable)
+					return
+				}
+				pending.retries++
+				pending.sendTime = now
+				packets = append(packets, pending.packet)
+			}
+			conn.mutex.Unlock()
+
+			for _, packet := range packets {
+				conn.sendPacket(packet)
+			}
+		}
+	}
+}
+
+func (conn *udpConn) closeWithError(err error) {
+	conn.closeOnce.Do(func() {
+		conn.closeErr = err
+		close(conn.closeChan)
+		if conn.onClose != nil {
+			conn.onClose()
+		}
+		if conn.isDialed {
+			conn.packetConn.Close()
+		}
+	})
+}
+
+func (conn *udpConn) Close() error {
+	// 尽力通知对端连接已关闭
+	select {
+	case <-conn.closeChan:
+	default:
+		conn.sendPacket(encodeUDPHeader(udpClosePacket, 0, conn.token, 0, 0, 0))
+	}
+	conn.closeWithError(net.ErrClosed)
+	return nil
+}
+
+func (conn *udpConn) LocalAddr() net.Addr {
+	return conn.localAddr
+}
+
+func (conn *udpConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// 只支持读取超时，写入数据报不会阻塞
+func (conn *udpConn) SetDeadline(t time.Time) error {
+	return conn.SetReadDeadline(t)
+}
+
+func (conn *udpConn) SetReadDeadline(t time.Time) error {
+	conn.mutex.Lock()
+	conn.deadline = t
+	conn.mutex.Unlock()
+	return nil
+}
+
+func (conn *udpConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func encodeUDPHeader(packetType, flags byte, token uint64, messageID uint32, index, total uint16) []byte {
+	header := make([]byte, udpHeaderSize)
+	header[0] = packetType
+	header[1] = flags
+	binary.LittleEndian.PutUint64(header[2:10], token)
+	binary.LittleEndian.PutUint32(header[10:14], messageID)
+	binary.LittleEndian.PutUint16(header[14:16], index)
+	binary.LittleEndian.PutUint16(header[16:18], total)
+	return header
+}
+
+// 调用方保证 packet 不短于 udpHeaderSize
+func decodeUDPHeader(packet []byte) udpHeader {
+	return udpHeader{
+		packetType: packet[0],
+		flags:      packet[1],
+		token:      binary.LittleEndian.Uint64(packet[2:10]),
+		messageID:  binary.LittleEndian.Uint32(packet[10:14]),
+		index:      binary.LittleEndian.Uint16(packet[14:16]),
+		total:      binary.LittleEndian.Uint16(packet[16:18]),
+	}
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"net"
+	"syscall"
+)
+
+// 设置 DF 标志，使内核在数据报超过路径 MTU 时返回 EMSGSIZE 而不是分片
+func setPathMTUDiscovery(packetConn *net.UDPConn) {
+	rawConn, err := packetConn.SyscallConn()
+	if err != nil {
+		return
+	}
+	rawConn.Control(func(fd uintptr) {
+		syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+	})
+}
+
+// 查询已连接套接字当前的路径 MTU，失败时返回保守取值
+func queryPathMTU(packetConn *net.UDPConn) int {
+	rawConn, err := packetConn.SyscallConn()
+	if err != nil {
+		return udpDefaultMTU
+	}
+
+	mtu := udpDefaultMTU
+	rawConn.Control(func(fd uintptr) {
+		level, option := syscall.IPPROTO_IP, syscall.IP_MTU
+		if addr, ok := packetConn.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+			level, option = syscall.IPPROTO_IPV6, syscall.IPV6_MTU
+		}
+		if value, err := syscall.GetsockoptInt(int(fd), level, option); err == nil && value > 0 {
+			mtu = value
+		}
+	})
+	return mtu
+}
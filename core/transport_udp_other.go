@@ -0,0 +1,13 @@
+//go:build !linux
+
+package core
+
+import "net"
+
+// 当前平台不支持设置 DF 标志，依赖 EMSGSIZE 与保守的 MTU 取值
+func setPathMTUDiscovery(packetConn *net.UDPConn) {}
+
+// 当前平台无法查询路径 MTU，使用保守取值
+func queryPathMTU(packetConn *net.UDPConn) int {
+	return udpDefaultMTU
+}
@@ -0,0 +1,269 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 在客户端与服务端之间转发数据报的代理，filter 决定客户端发往服务端的第 n 个数据包
+// 是直接转发 (返回 0)、丢弃 (返回 -1) 还是延迟若干个数据包后再转发
+type udpProxy struct {
+	front  *net.UDPConn
+	back   *net.UDPConn
+	mutex  sync.Mutex
+	client *net.UDPAddr
+}
+
+func startUDPProxy(t *testing.T, target string, filter func(n int) int) string {
+	t.Helper()
+
+	front, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetAddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := net.DialUDP("udp", nil, targetAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &udpProxy{front: front, back: back}
+	t.Cleanup(func() {
+		front.Close()
+		back.Close()
+	})
+
+	go func() {
+		type delayed struct {
+			packet []byte
+			after  int
+		}
+		var held []delayed
+		buffer := make([]byte, 1<<16)
+		for n := 0; ; n++ {
+			length, clientAddr, err := front.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			proxy.mutex.Lock()
+			proxy.client = clientAddr
+			proxy.mutex.Unlock()
+
+			packet := append([]byte(nil), buffer[:length]...)
+			switch delay := filter(n); {
+			case delay < 0:
+			case delay == 0:
+				back.Write(packet)
+			default:
+				held = append(held, delayed{packet, n + delay})
+			}
+			kept := held[:0]
+			for _, item := range held {
+				if item.after <= n {
+					back.Write(item.packet)
+				} else {
+					kept = append(kept, item)
+				}
+			}
+			held = kept
+		}
+	}()
+	go func() {
+		buffer := make([]byte, 1<<16)
+		for {
+			length, err := back.Read(buffer)
+			if err != nil {
+				return
+			}
+			proxy.mutex.Lock()
+			client := proxy.client
+			proxy.mutex.Unlock()
+			if client != nil {
+				front.WriteToUDP(buffer[:length], client)
+			}
+		}
+	}()
+	return front.LocalAddr().String()
+}
+
+// 建立一对经过 filter 代理的 UDP 连接
+func dialUDPPair(t *testing.T, transport *UDPTransport, filter func(n int) int) (net.Conn, net.Conn) {
+	t.Helper()
+
+	listener, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	address := listener.Addr().String()
+	if filter != nil {
+		address = startUDPProxy(t, address, filter)
+	}
+
+	client, err := transport.Dial("", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	// 监听方在收到第一个数据包后才创建连接
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	hello := make([]byte, 5)
+	if _, err := io.ReadFull(server, hello); err != nil || string(hello) != "hello" {
+		t.Fatalf("读取第一条消息失败: %q %v", hello, err)
+	}
+	return client, server
+}
+
+func TestUDPFragmentation(t *testing.T) {
+	transport := NewUDPTransport(true)
+	transport.MTU = 600
+	client, server := dialUDPPair(t, transport, nil)
+
+	// 超过 udpMaxMessage 的写入被拆分为多条消息，每条消息再按 MTU 分片
+	message := make([]byte, 3*udpMaxMessage+123)
+	for i := range message {
+		message[i] = byte(i * 7)
+	}
+	if n, err := client.Write(message); err != nil || n != len(message) {
+		t.Fatalf("写入 %d 字节: %v", n, err)
+	}
+	received := make([]byte, len(message))
+	if _, err := io.ReadFull(server, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, message) {
+		t.Fatal("重组后的消息与发送的不一致")
+	}
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	transport := NewUDPTransport(true)
+	transport.RetransmitTimeout = 50 * time.Millisecond
+	transport.MTU = 600
+	// 丢弃客户端发出的每三个数据包中的一个 (第一个数据包除外)
+	client, server := dialUDPPair(t, transport, func(n int) int {
+		if n > 0 && n%3 == 0 {
+			return -1
+		}
+		return 0
+	})
+
+	message := bytes.Repeat([]byte("retransmit "), 2000)
+	if _, err := client.Write(message); err != nil {
+		t.Fatal(err)
+	}
+	received := make([]byte, len(message))
+	if _, err := io.ReadFull(server, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, message) {
+		t.Fatal("重传后的消息与发送的不一致")
+	}
+}
+
+// 可靠模式下先完成重组的消息也要等待之前的消息，字节流的顺序与写入顺序一致
+func TestUDPReordering(t *testing.T) {
+	transport := NewUDPTransport(true)
+	transport.RetransmitTimeout = 50 * time.Millisecond
+	// 每个偶数编号的数据包延迟到之后第三个数据包之后再转发，丢弃第 5 个数据包使其只能靠重传到达
+	client, server := dialUDPPair(t, transport, func(n int) int {
+		switch {
+		case n == 5:
+			return -1
+		case n > 0 && n%2 == 0:
+			return 3
+		}
+		return 0
+	})
+
+	var expected bytes.Buffer
+	for i := range 40 {
+		message := fmt.Appendf(nil, "message-%02d;", i)
+		expected.Write(message)
+		if _, err := client.Write(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 再写入几条消息，使代理释放最后被延迟的数据包
+	received := make([]byte, expected.Len())
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(server, received)
+		done <- err
+	}()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(received, expected.Bytes()) {
+				t.Fatalf("消息顺序错误: %s", received)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+			client.Write(nil)
+		}
+	}
+}
+
+func TestUDPIgnoresForgedClose(t *testing.T) {
+	client, server := dialUDPPair(t, NewUDPTransport(true), nil)
+	conn := server.(*udpConn)
+
+	conn.handlePacket(encodeUDPHeader(udpClosePacket, 0, conn.token+1, 0, 0, 0))
+	select {
+	case <-conn.closeChan:
+		t.Fatal("令牌错误的关闭包关闭了连接")
+	default:
+	}
+	if _, err := client.Write([]byte("still open")); err != nil {
+		t.Fatal(err)
+	}
+	received := make([]byte, len("still open"))
+	if _, err := io.ReadFull(server, received); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close()
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := server.Read(received); err != io.EOF {
+		t.Fatalf("对端关闭后读取返回 %v，期望 %v", err, io.EOF)
+	}
+}
+
+// 未认证的来源声明巨大的分片总数或不断使用新的消息编号时，不能使重组缓冲无限增长
+func TestUDPReassemblyLimits(t *testing.T) {
+	_, server := dialUDPPair(t, NewUDPTransport(false), nil)
+	conn := server.(*udpConn)
+
+	conn.handlePacket(encodeUDPHeader(udpDataPacket, 0, conn.token, 1000, 0, 0xffff))
+	for id := range uint32(1000) {
+		conn.handlePacket(encodeUDPHeader(udpDataPacket, udpReliableFlag, conn.token, 2000+id, 0, 2))
+		conn.handlePacket(encodeUDPHeader(udpDataPacket, 0, conn.token, 5000+id, 0, 2))
+	}
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if _, ok := conn.fragments[1000]; ok {
+		t.Fatal("为超过分片数上限的消息分配了重组缓冲")
+	}
+	if len(conn.fragments)+len(conn.completed) > udpMaxReassembly {
+		t.Fatalf("重组中的消息数 %d 超过上限 %d", len(conn.fragments)+len(conn.completed), udpMaxReassembly)
+	}
+}
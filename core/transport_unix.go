@@ -0,0 +1,36 @@
+package core
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+)
+
+// 基于 Unix 域套接字的传输层，地址为套接字文件路径
+type UnixTransport struct{}
+
+func NewUnixTransport() *UnixTransport {
+	return &UnixTransport{}
+}
+
+// Unix 域套接字无需绑定本地地址，localAddress 被忽略
+func (transport *UnixTransport) Dial(localAddress, remoteAddress string) (net.Conn, error) {
+	return net.Dial("unix", remoteAddress)
+}
+
+func (transport *UnixTransport) Listen(localAddress string) (net.Listener, error) {
+	// 清理上次异常退出遗留的套接字文件
+	if err := os.Remove(localAddress); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", localAddress)
+	if err != nil {
+		return nil, err
+	}
+	// 关闭监听时同时删除套接字文件
+	listener.(*net.UnixListener).SetUnlinkOnClose(true)
+
+	return listener, nil
+}
@@ -0,0 +1,200 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/libp2p/go-reuseport"
+)
+
+// 基于 HTTP 升级的 WebSocket 传输层，便于穿过仅允许 HTTP 的代理
+type WebSocketTransport struct {
+	Path             string        // WebSocket 服务所在的 HTTP 路径
+	HandshakeTimeout time.Duration // 完成 HTTP 升级的超时时间，避免慢速发送请求头的连接一直占用服务端
+}
+
+type webSocketConn struct {
+	conn       *websocket.Conn
+	reader     io.Reader
+	readMutex  sync.Mutex
+	writeMutex sync.Mutex
+}
+
+type webSocketListener struct {
+	listener  net.Listener
+	server    *http.Server
+	closeOnce sync.Once
+	closeChan chan bool
+	connChan  chan net.Conn
+}
+
+func NewWebSocketTransport(path string) *WebSocketTransport {
+	if path == "" {
+		path = "/"
+	}
+	return &WebSocketTransport{
+		Path:             path,
+		HandshakeTimeout: 10 * time.Second,
+	}
+}
+
+// remoteAddress 可以是 host:port，也可以是完整的 ws:// 或 wss:// 地址
+func (transport *WebSocketTransport) Dial(localAddress, remoteAddress string) (net.Conn, error) {
+	url := remoteAddress
+	if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+		url = "ws://" + remoteAddress + transport.Path
+	}
+
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: transport.HandshakeTimeout,
+		NetDial: func(network, address string) (net.Conn, error) {
+			if localAddress == "" {
+				return net.Dial(network, address)
+			}
+			return reuseport.Dial(network, localAddress, address)
+		},
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webSocketConn{conn: conn}, nil
+}
+
+func (transport *WebSocketTransport) Listen(localAddress string) (net.Listener, error) {
+	listener, err := reuseport.Listen("tcp", localAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	wsListener := &webSocketListener{
+		listener:  listener,
+		closeChan: make(chan bool),
+		connChan:  make(chan net.Conn),
+	}
+	upgrader := &websocket.Upgrader{
+		HandshakeTimeout: transport.HandshakeTimeout,
+		// 会话自身负责认证，这里不限制来源
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(transport.Path, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		// 升级后的连接不再受 HTTP 服务的读取超时限制，由会话自行设置超时
+		conn.NetConn().SetDeadline(time.Time{})
+		select {
+		case wsListener.connChan <- &webSocketConn{conn: conn}:
+		case <-wsListener.closeChan:
+			conn.Close()
+		}
+	})
+	wsListener.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: transport.HandshakeTimeout,
+		ReadTimeout:       transport.HandshakeTimeout,
+	}
+	go wsListener.server.Serve(listener)
+
+	return wsListener, nil
+}
+
+func (listener *webSocketListener) Accept() (net.Conn, error) {
+	select {
+	case connect := <-listener.connChan:
+		return connect, nil
+	case <-listener.closeChan:
+		return nil, net.ErrClosed
+	}
+}
+
+func (listener *webSocketListener) Close() error {
+	var err error
+	listener.closeOnce.Do(func() {
+		close(listener.closeChan)
+		err = listener.server.Close()
+	})
+	return err
+}
+
+func (listener *webSocketListener) Addr() net.Addr {
+	return listener.listener.Addr()
+}
+
+// 将连续的 WebSocket 二进制消息视为一条字节流
+func (wsConn *webSocketConn) Read(b []byte) (int, error) {
+	wsConn.readMutex.Lock()
+	defer wsConn.readMutex.Unlock()
+
+	for {
+		if wsConn.reader == nil {
+			messageType, reader, err := wsConn.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			wsConn.reader = reader
+		}
+
+		n, err := wsConn.reader.Read(b)
+		if errors.Is(err, io.EOF) {
+			wsConn.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// 每次写入作为一条 WebSocket 二进制消息发送
+func (wsConn *webSocketConn) Write(b []byte) (int, error) {
+	wsConn.writeMutex.Lock()
+	defer wsConn.writeMutex.Unlock()
+
+	if err := wsConn.conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (wsConn *webSocketConn) Close() error {
+	return wsConn.conn.Close()
+}
+
+func (wsConn *webSocketConn) LocalAddr() net.Addr {
+	return wsConn.conn.LocalAddr()
+}
+
+func (wsConn *webSocketConn) RemoteAddr() net.Addr {
+	return wsConn.conn.RemoteAddr()
+}
+
+func (wsConn *webSocketConn) SetDeadline(t time.Time) error {
+	if err := wsConn.conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return wsConn.conn.SetWriteDeadline(t)
+}
+
+func (wsConn *webSocketConn) SetReadDeadline(t time.Time) error {
+	return wsConn.conn.SetReadDeadline(t)
+}
+
+func (wsConn *webSocketConn) SetWriteDeadline(t time.Time) error {
+	return wsConn.conn.SetWriteDeadline(t)
+}
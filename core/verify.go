@@ -0,0 +1,65 @@
+package core
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	Unverified  = iota // 尚未比对短认证字符串
+	Verified           // 双方确认短认证字符串一致
+	Compromised        // 短认证字符串不一致，可能存在中间人
+)
+
+// 由握手记录派生 6 位数字的短认证字符串，中间人与双方分别握手时两端的结果不同；
+// 经典握手中发起方先承诺公钥，中间人只有一次机会使两端的结果碰巧相同
+func deriveSAS(transcriptHash []byte) string {
+	sasBytes, err := hkdf.Key(sha256.New, transcriptHash, nil, "DoubleRatchetDemo SAS", 4)
+	if err != nil {
+		return ""
+	}
+	code := binary.BigEndian.Uint32(sasBytes) % 1000000
+	return fmt.Sprintf("%03d %03d", code/1000, code%1000)
+}
+
+// 返回会话的短认证字符串，握手完成前为空
+func (session *Session) SAS() string {
+	session.verifyMutex.Lock()
+	defer session.verifyMutex.Unlock()
+
+	return session.sas
+}
+
+func (session *Session) VerifyStatus() int {
+	session.verifyMutex.Lock()
+	defer session.verifyMutex.Unlock()
+
+	return session.verifyStatus
+}
+
+// 记录用户比对短认证字符串的结果，不一致时立即关闭会话
+func (session *Session) Verify(isMatched bool) {
+	session.verifyMutex.Lock()
+	if isMatched {
+		session.verifyStatus = Verified
+	} else {
+		session.verifyStatus = Compromised
+	}
+	session.verifyMutex.Unlock()
+
+	if !isMatched {
+		go session.Stop()
+	}
+}
+
+// 返回由双方身份公钥计算的安全码，对端身份未经认证时为空
+func (session *Session) SafetyNumber() string {
+	if len(session.RemoteIdentity) == 0 || session.config.IdentityKey == nil {
+		return ""
+	}
+	return utils.SafetyNumber(session.config.IdentityKey.PublicKey.Bytes(), session.RemoteIdentity)
+}
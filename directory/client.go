@@ -0,0 +1,130 @@
+package directory
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/reagin/double_ratchet/transparency"
+	"github.com/reagin/double_ratchet/utils"
+)
+
+var ErrEntryMismatch = errors.New("目录返回的记录与查询的用户名不符")
+var ErrEntryExpired = errors.New("目录返回的记录已过期")
+
+// 目录服务的客户端
+type Client struct {
+	IdentityKey   *utils.DiffeHellmanKeyPair
+	ServerAddress string
+	Difficulty    int // 工作量证明难度，服务端要求更高时自动更新
+	Timeout       time.Duration
+	// 设置后查询到的身份公钥需要与密钥透明日志中的绑定一致
+	Transparency *transparency.Client
+}
+
+func NewClient(serverAddress string, identityKey *utils.DiffeHellmanKeyPair) *Client {
+	return &Client{
+		IdentityKey:   identityKey,
+		ServerAddress: serverAddress,
+		Difficulty:    20,
+		Timeout:       10 * time.Second,
+	}
+}
+
+// 注册用户名与当前地址，记录在 ttl 后过期，需要在过期前重新注册以保持在线
+func (client *Client) Register(username string, endpoints []string, ttl time.Duration) error {
+	if !usernamePattern.MatchString(username) {
+		return ErrUsername
+	}
+
+	for {
+		now := time.Now()
+		entry := &Entry{
+			Username:    username,
+			IdentityKey: client.IdentityKey.PublicKey.Bytes(),
+			Endpoints:   endpoints,
+			Timestamp:   now.UnixNano(),
+			ExpiresAt:   now.Add(ttl).UnixNano(),
+		}
+		signature, err := client.IdentityKey.Sign(entry.signedPayload())
+		if err != nil {
+			return err
+		}
+		entry.Signature = signature
+		difficulty := client.Difficulty
+		solveProofOfWork(entry, difficulty)
+
+		response, err := client.roundTrip(&Message{Type: RegisterType, Entry: entry})
+		// 服务端提高了难度时记录新的难度，工作量证明被拒绝时按新的难度重新计算
+		if response != nil && response.Difficulty > difficulty {
+			client.Difficulty = response.Difficulty
+			if response.Error == ErrProofOfWork.Error() {
+				continue
+			}
+		}
+		return err
+	}
+}
+
+func (client *Client) Unregister(username string) error {
+	request := &Message{
+		Type:        UnregisterType,
+		Username:    username,
+		IdentityKey: client.IdentityKey.PublicKey.Bytes(),
+		Timestamp:   time.Now().UnixNano(),
+	}
+	signature, err := client.IdentityKey.Sign(request.unregisterPayload())
+	if err != nil {
+		return err
+	}
+	request.Signature = signature
+	_, err = client.roundTrip(request)
+	return err
+}
+
+// 查询用户名，并校验记录的签名与有效期
+func (client *Client) Lookup(username string) (*Entry, error) {
+	response, err := client.roundTrip(&Message{Type: LookupType, Username: username})
+	if err != nil {
+		return nil, err
+	}
+	entry := response.Entry
+	if entry == nil || entry.Username != username {
+		return nil, ErrEntryMismatch
+	}
+	if err := entry.verify(); err != nil {
+		return nil, err
+	}
+	if entry.ExpiresAt <= time.Now().UnixNano() {
+		return nil, ErrEntryExpired
+	}
+	if client.Transparency != nil {
+		if err := client.Transparency.VerifyKey(username, entry.IdentityKey); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+// 建立连接发送请求并读取回复，服务端返回错误时同时返回回复
+func (client *Client) roundTrip(request *Message) (*Message, error) {
+	connect, err := net.DialTimeout("tcp", client.ServerAddress, client.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer connect.Close()
+	connect.SetDeadline(time.Now().Add(client.Timeout))
+
+	if err := writeMessage(bufio.NewWriter(connect), request); err != nil {
+		return nil, err
+	}
+	response, err := readMessage(bufio.NewReader(connect))
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return response, errors.New(response.Error)
+	}
+	return response, nil
+}
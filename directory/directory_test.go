@@ -0,0 +1,166 @@
+package directory
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const testDifficulty = 8
+
+func startTestServer(t *testing.T, difficulty int) *Server {
+	t.Helper()
+	server := NewServer("127.0.0.1:0", NewMemoryStore())
+	server.Difficulty = difficulty
+	if err := server.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.StopServer)
+	return server
+}
+
+func newTestClient(server *Server) *Client {
+	client := NewClient(server.netListener.Addr().String(), utils.NewDiffeHellmanKeyPair())
+	client.Difficulty = testDifficulty
+	client.Timeout = 2 * time.Second
+	return client
+}
+
+// 生成已签名、尚未计算工作量证明的记录
+func signedEntry(t *testing.T, client *Client, username string, ttl time.Duration) *Entry {
+	t.Helper()
+	now := time.Now()
+	entry := &Entry{
+		Username:    username,
+		IdentityKey: client.IdentityKey.PublicKey.Bytes(),
+		Endpoints:   []string{"127.0.0.1:3000"},
+		Timestamp:   now.UnixNano(),
+		ExpiresAt:   now.Add(ttl).UnixNano(),
+	}
+	signature, err := client.IdentityKey.Sign(entry.signedPayload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Signature = signature
+	return entry
+}
+
+func TestRegisterAndLookup(t *testing.T) {
+	server := startTestServer(t, testDifficulty)
+	alice := newTestClient(server)
+	if err := alice.Register("alice", []string{"127.0.0.1:3000"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := newTestClient(server).Lookup("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(entry.IdentityKey, alice.IdentityKey.PublicKey.Bytes()) || len(entry.Endpoints) != 1 {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if _, err := alice.Lookup("nobody"); err == nil || err.Error() != ErrNotFound.Error() {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	server := startTestServer(t, testDifficulty)
+	client := newTestClient(server)
+
+	entry := signedEntry(t, client, "alice", time.Hour)
+	solveProofOfWork(entry, testDifficulty)
+	if !checkProofOfWork(entry, testDifficulty) {
+		t.Fatal("the solved proof of work was rejected")
+	}
+	// 签名内容变化后原来的工作量证明失效
+	for checkProofOfWork(entry, testDifficulty) {
+		entry.Nonce++
+	}
+	if _, err := client.roundTrip(&Message{Type: RegisterType, Entry: entry}); err == nil || err.Error() != ErrProofOfWork.Error() {
+		t.Fatalf("expected a proof of work error, got %v", err)
+	}
+
+	// 服务端要求更高的难度时，客户端按回复中的难度重新计算
+	strict := startTestServer(t, testDifficulty+4)
+	client = newTestClient(strict)
+	if err := client.Register("alice", []string{"127.0.0.1:3000"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if client.Difficulty != strict.Difficulty {
+		t.Fatalf("the client did not adopt difficulty %d, got %d", strict.Difficulty, client.Difficulty)
+	}
+}
+
+func TestEntryExpires(t *testing.T) {
+	server := startTestServer(t, testDifficulty)
+	alice := newTestClient(server)
+	if err := alice.Register("alice", []string{"127.0.0.1:3000"}, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Lookup("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Register("alice", []string{"127.0.0.1:3000"}, server.MaxTTL+time.Hour); err == nil {
+		t.Fatal("registered an entry longer than MaxTTL")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if _, err := alice.Lookup("alice"); err == nil || err.Error() != ErrNotFound.Error() {
+		t.Fatalf("expected the expired entry to be hidden, got %v", err)
+	}
+	if err := server.Store.Expire(time.Now().UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := server.Store.Load("alice"); err != nil || entry != nil {
+		t.Fatalf("the expired entry was not removed: %v", err)
+	}
+
+	// 过期后的用户名可以由其他身份注册
+	bob := newTestClient(server)
+	if err := bob.Register("alice", []string{"127.0.0.1:4000"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRejectTakeover(t *testing.T) {
+	server := startTestServer(t, testDifficulty)
+	alice := newTestClient(server)
+	if err := alice.Register("alice", []string{"127.0.0.1:3000"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	mallory := newTestClient(server)
+	if err := mallory.Register("alice", []string{"127.0.0.1:6666"}, time.Hour); err == nil || err.Error() != ErrUsernameTaken.Error() {
+		t.Fatalf("expected a username taken error, got %v", err)
+	}
+	if err := mallory.Unregister("alice"); err == nil || err.Error() != ErrNotFound.Error() {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	// 重放 alice 的旧记录不能覆盖较新的记录
+	replayed := signedEntry(t, alice, "alice", time.Hour)
+	solveProofOfWork(replayed, testDifficulty)
+	if _, err := alice.roundTrip(&Message{Type: RegisterType, Entry: replayed}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.roundTrip(&Message{Type: RegisterType, Entry: replayed}); err == nil || err.Error() != ErrStaleRequest.Error() {
+		t.Fatalf("expected a stale request error, got %v", err)
+	}
+
+	entry, err := mallory.Lookup("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(entry.IdentityKey, alice.IdentityKey.PublicKey.Bytes()) {
+		t.Fatal("the username was taken over")
+	}
+	if err := alice.Unregister("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mallory.Lookup("alice"); err == nil || err.Error() != ErrNotFound.Error() {
+		t.Fatalf("expected the unregistered entry to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package directory
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"regexp"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	RegisterType   = "register"   // 注册或刷新用户名对应的身份公钥与地址
+	UnregisterType = "unregister" // 注销用户名
+	LookupType     = "lookup"     // 按用户名查询
+	ResultType     = "result"     // 服务端对请求的回复
+)
+
+// 单条信息的最大长度，信息只包含一条记录
+const maxMessageSize = 64 << 10
+
+// 用户名只允许小写字母、数字与 . _ -，可以直接作为文件名
+var usernamePattern = regexp.MustCompile(`^[a-z0-9._-]{3,32}$`)
+
+// 目录中的一条记录，由身份密钥签名，目录服务无法篡改其中的地址与过期时间
+type Entry struct {
+	Username    string
+	IdentityKey []byte
+	Endpoints   []string // 当前可以连接的地址，格式为 host:port
+	Timestamp   int64    // 注册时间，用于拒绝重放的请求
+	ExpiresAt   int64    // 超过该时间后记录失效，用户名可以被重新注册
+	Signature   []byte
+	Nonce       uint64 // 工作量证明
+}
+
+// 客户端与目录服务之间交换的信息
+type Message struct {
+	Type        string
+	Entry       *Entry
+	Username    string
+	IdentityKey []byte
+	Timestamp   int64
+	Signature   []byte
+	Difficulty  int // 服务端要求的工作量证明难度 (前导零比特数)
+	Error       string
+}
+
+// 记录中被签名的内容，工作量证明也基于该内容计算
+func (entry *Entry) signedPayload() []byte {
+	endpoints, _ := json.Marshal(entry.Endpoints)
+	endpointsHash := sha256.Sum256(endpoints)
+
+	payload := []byte("DoubleRatchetDemo directory entry")
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(entry.Username)))
+	payload = append(payload, entry.Username...)
+	payload = append(payload, entry.IdentityKey...)
+	payload = append(payload, endpointsHash[:]...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(entry.Timestamp))
+	return binary.BigEndian.AppendUint64(payload, uint64(entry.ExpiresAt))
+}
+
+// 校验记录的身份公钥与签名
+func (entry *Entry) verify() error {
+	if !usernamePattern.MatchString(entry.Username) {
+		return ErrUsername
+	}
+	if _, err := utils.BytesToPublicKey(entry.IdentityKey); err != nil {
+		return err
+	}
+	if !utils.XEdDSAVerify(entry.IdentityKey, entry.signedPayload(), entry.Signature) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// 注销请求所签名的内容
+func (message *Message) unregisterPayload() []byte {
+	payload := []byte("DoubleRatchetDemo directory unregister")
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(message.Username)))
+	payload = append(payload, message.Username...)
+	payload = append(payload, message.IdentityKey...)
+	return binary.BigEndian.AppendUint64(payload, uint64(message.Timestamp))
+}
+
+func writeMessage(writer *bufio.Writer, message *Message) error {
+	messageBytes, _ := json.Marshal(message)
+	messageBytes, _ = utils.EncodeMessage(messageBytes)
+	if _, err := writer.Write(messageBytes); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func readMessage(reader *bufio.Reader) (*Message, error) {
+	messageBytes, err := utils.DecodeMessageLimit(reader, maxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+	message := &Message{}
+	if err := json.Unmarshal(messageBytes, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
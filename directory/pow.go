@@ -0,0 +1,39 @@
+package directory
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+)
+
+// 工作量证明：SHA-256(记录内容 || nonce) 的前导零比特数不少于 difficulty，
+// 注册方每次注册都需要付出计算代价，批量抢注用户名的成本随之增加
+func checkProofOfWork(entry *Entry, difficulty int) bool {
+	return leadingZeros(proofHash(entry.signedPayload(), entry.Nonce)) >= difficulty
+}
+
+// 从 0 开始递增 nonce 直到满足难度要求
+func solveProofOfWork(entry *Entry, difficulty int) {
+	payload := entry.signedPayload()
+	for nonce := uint64(0); ; nonce++ {
+		if leadingZeros(proofHash(payload, nonce)) >= difficulty {
+			entry.Nonce = nonce
+			return
+		}
+	}
+}
+
+func proofHash(payload []byte, nonce uint64) [32]byte {
+	return sha256.Sum256(binary.BigEndian.AppendUint64(append([]byte(nil), payload...), nonce))
+}
+
+func leadingZeros(digest [32]byte) int {
+	count := 0
+	for _, b := range digest {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
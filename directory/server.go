@@ -0,0 +1,218 @@
+package directory
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	maxClockSkew   = 5 * time.Minute  // 请求时间戳与服务端时间允许的最大偏差
+	expireInterval = time.Minute      // 清理过期记录的间隔
+	requestTimeout = 10 * time.Second // 读取请求与写入回复的最长时间
+)
+
+var ErrUsername = errors.New("用户名需要为 3 到 32 个小写字母、数字或 . _ -")
+var ErrUsernameTaken = errors.New("用户名已被其他身份注册")
+var ErrNotFound = errors.New("未找到该用户名")
+var ErrSignature = errors.New("签名验证失败")
+var ErrStaleRequest = errors.New("请求已过期或被重放")
+var ErrProofOfWork = errors.New("工作量证明不满足难度要求")
+var ErrInvalidRequest = errors.New("无效的请求")
+
+// 目录服务：保存用户名到签名身份公钥与当前地址的映射，记录超过有效期后自动删除
+type Server struct {
+	stopChan     chan bool
+	stopOnce     sync.Once
+	waitGroup    sync.WaitGroup
+	netListener  net.Listener
+	mutex        sync.Mutex
+	Store        Store
+	Difficulty   int           // 注册所需的工作量证明难度 (前导零比特数)
+	MaxTTL       time.Duration // 记录的最长有效期
+	LocalAddress string
+}
+
+func NewServer(localAddress string, store Store) *Server {
+	return &Server{
+		stopChan:     make(chan bool),
+		Store:        store,
+		Difficulty:   20,
+		MaxTTL:       24 * time.Hour,
+		LocalAddress: localAddress,
+	}
+}
+
+func (server *Server) StartServer() error {
+	listener, err := net.Listen("tcp", server.LocalAddress)
+	if err != nil {
+		return err
+	}
+	server.netListener = listener
+	log.Printf("🎉 目录服务已开始监听: %s\n", listener.Addr().String())
+
+	server.waitGroup.Add(2)
+	go server.handleServer()
+	go server.handleExpire()
+	return nil
+}
+
+func (server *Server) StopServer() {
+	server.stopOnce.Do(func() {
+		close(server.stopChan)
+		server.netListener.Close()
+		server.waitGroup.Wait()
+		log.Println("✅ 目录服务已关闭")
+	})
+}
+
+func (server *Server) handleServer() {
+	defer server.waitGroup.Done()
+
+	for {
+		connect, err := server.netListener.Accept()
+		if err != nil {
+			return
+		}
+		server.waitGroup.Add(1)
+		go server.handleConnection(connect)
+	}
+}
+
+// 定期清理过期的记录
+func (server *Server) handleExpire() {
+	defer server.waitGroup.Done()
+
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-server.stopChan:
+			return
+		case <-ticker.C:
+			if err := server.Store.Expire(time.Now().UnixNano()); err != nil {
+				log.Printf("❌ 清理过期记录失败: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// 每条连接处理一个请求
+func (server *Server) handleConnection(connect net.Conn) {
+	defer server.waitGroup.Done()
+	defer connect.Close()
+
+	connect.SetDeadline(time.Now().Add(requestTimeout))
+	reader := bufio.NewReader(connect)
+	writer := bufio.NewWriter(connect)
+	request, err := readMessage(reader)
+	if err != nil {
+		return
+	}
+
+	// 回复中总是携带当前难度，客户端据此调整工作量证明
+	response := &Message{Type: ResultType, Difficulty: server.Difficulty}
+	switch request.Type {
+	case RegisterType:
+		err = server.handleRegister(request.Entry)
+	case UnregisterType:
+		err = server.handleUnregister(request)
+	case LookupType:
+		response.Entry, err = server.handleLookup(request.Username)
+	default:
+		err = ErrInvalidRequest
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	writeMessage(writer, response)
+}
+
+// 注册或刷新记录，有效期内的用户名只能由注册它的身份刷新
+func (server *Server) handleRegister(entry *Entry) error {
+	if entry == nil {
+		return ErrInvalidRequest
+	}
+	if err := entry.verify(); err != nil {
+		return err
+	}
+	now := time.Now()
+	if now.Sub(time.Unix(0, entry.Timestamp)).Abs() > maxClockSkew {
+		return ErrStaleRequest
+	}
+	if entry.ExpiresAt <= now.UnixNano() || entry.ExpiresAt > now.Add(server.MaxTTL).UnixNano() {
+		return ErrInvalidRequest
+	}
+	for _, endpoint := range entry.Endpoints {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return ErrInvalidRequest
+		}
+	}
+	if !checkProofOfWork(entry, server.Difficulty) {
+		return ErrProofOfWork
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	existing, err := server.Store.Load(entry.Username)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.ExpiresAt > now.UnixNano() {
+		if !bytes.Equal(existing.IdentityKey, entry.IdentityKey) {
+			return ErrUsernameTaken
+		}
+		if entry.Timestamp <= existing.Timestamp {
+			return ErrStaleRequest
+		}
+	}
+	if existing == nil || !bytes.Equal(existing.IdentityKey, entry.IdentityKey) {
+		log.Printf("📇 用户名 %s 已注册\n", entry.Username)
+	}
+	return server.Store.Save(entry.Username, entry)
+}
+
+func (server *Server) handleUnregister(request *Message) error {
+	if !usernamePattern.MatchString(request.Username) {
+		return ErrUsername
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	existing, err := server.Store.Load(request.Username)
+	if err != nil {
+		return err
+	}
+	if existing == nil || !bytes.Equal(existing.IdentityKey, request.IdentityKey) {
+		return ErrNotFound
+	}
+	if !utils.XEdDSAVerify(request.IdentityKey, request.unregisterPayload(), request.Signature) {
+		return ErrSignature
+	}
+	if time.Since(time.Unix(0, request.Timestamp)).Abs() > maxClockSkew || request.Timestamp <= existing.Timestamp {
+		return ErrStaleRequest
+	}
+	return server.Store.Delete(request.Username)
+}
+
+func (server *Server) handleLookup(username string) (*Entry, error) {
+	// 用户名同时作为存储的文件名，查询前需要校验
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrUsername
+	}
+	entry, err := server.Store.Load(username)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || entry.ExpiresAt <= time.Now().UnixNano() {
+		return nil, ErrNotFound
+	}
+	return entry, nil
+}
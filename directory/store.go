@@ -0,0 +1,166 @@
+package directory
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 目录记录的存储后端，Load 在记录不存在时返回 nil, nil
+type Store interface {
+	Load(username string) (*Entry, error)
+	Save(username string, entry *Entry) error
+	Delete(username string) error
+	// 删除所有在 now 之前过期的记录
+	Expire(now int64) error
+}
+
+// 保存在内存中的存储后端，服务重启后数据丢失
+type MemoryStore struct {
+	mutex   sync.Mutex
+	entries map[string][]byte
+}
+
+// 每个用户名保存为目录下的一个 JSON 文件
+type FileStore struct {
+	mutex     sync.Mutex
+	Directory string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string][]byte)}
+}
+
+func (store *MemoryStore) Load(username string) (*Entry, error) {
+	store.mutex.Lock()
+	data, ok := store.entries[username]
+	store.mutex.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (store *MemoryStore) Save(username string, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	store.entries[username] = data
+	store.mutex.Unlock()
+	return nil
+}
+
+func (store *MemoryStore) Delete(username string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.entries, username)
+	return nil
+}
+
+func (store *MemoryStore) Expire(now int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for username, data := range store.entries {
+		entry := &Entry{}
+		if err := json.Unmarshal(data, entry); err != nil || entry.ExpiresAt <= now {
+			delete(store.entries, username)
+		}
+	}
+	return nil
+}
+
+func NewFileStore(directory string) (*FileStore, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{Directory: directory}, nil
+}
+
+func (store *FileStore) Load(username string) (*Entry, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.load(username)
+}
+
+// 先写入临时文件再重命名，避免写入中断导致记录损坏
+func (store *FileStore) Save(username string, entry *Entry) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempPath := store.path(username) + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, store.path(username))
+}
+
+func (store *FileStore) Delete(username string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := os.Remove(store.path(username))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (store *FileStore) Expire(now int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(store.Directory, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		entry, err := store.load(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return err
+		}
+		if entry != nil && entry.ExpiresAt <= now {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (store *FileStore) load(username string) (*Entry, error) {
+	data, err := os.ReadFile(store.path(username))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// 用户名经过校验，只包含可以作为文件名的字符
+func (store *FileStore) path(username string) string {
+	return filepath.Join(store.Directory, username+".json")
+}
@@ -0,0 +1,200 @@
+package discovery
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// 在局域网中通过 DNS-SD 公布本机的 _doubleratchet._tcp 服务，
+// SRV 记录携带监听端口，TXT 记录携带身份公钥的指纹
+type Advertiser struct {
+	stopChan     chan bool
+	stopOnce     sync.Once
+	waitGroup    sync.WaitGroup
+	conn         *mdnsConn
+	Instance     string
+	Port         int
+	Fingerprint  string
+	GroupAddress string         // mDNS 组播地址
+	Interface    *net.Interface // 为 nil 时由系统选择接口
+}
+
+// localAddress 为 Server.LocalAddress，从中取出监听端口
+func NewAdvertiser(instance, localAddress string, identityKey []byte) (*Advertiser, error) {
+	if instance == "" {
+		return nil, ErrInstanceName
+	}
+	_, portText, err := net.SplitHostPort(localAddress)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Advertiser{
+		stopChan:     make(chan bool),
+		Instance:     instanceLabel(instance),
+		Port:         port,
+		Fingerprint:  utils.Fingerprint(identityKey),
+		GroupAddress: mdnsGroup,
+	}, nil
+}
+
+func (advertiser *Advertiser) StartAdvertiser() error {
+	conn, err := listenMulticast(advertiser.GroupAddress, advertiser.Interface)
+	if err != nil {
+		return err
+	}
+	advertiser.conn = conn
+	log.Printf("📡 已在局域网公布服务: %s\n", advertiser.instanceName())
+
+	advertiser.waitGroup.Add(1)
+	go advertiser.handleQueries()
+	// 启动时主动公布两次，之前已经在浏览的节点可以立即看到
+	advertiser.announce(recordTTL)
+	go func() {
+		select {
+		case <-advertiser.stopChan:
+		case <-time.After(time.Second):
+			advertiser.announce(recordTTL)
+		}
+	}()
+	return nil
+}
+
+// 停止前发送有效期为 0 的记录，通知其他节点立即移除本服务
+func (advertiser *Advertiser) StopAdvertiser() {
+	advertiser.stopOnce.Do(func() {
+		advertiser.announce(0)
+		close(advertiser.stopChan)
+		advertiser.conn.Close()
+		advertiser.waitGroup.Wait()
+		log.Println("✅ 已停止公布局域网服务")
+	})
+}
+
+func (advertiser *Advertiser) instanceName() string {
+	return advertiser.Instance + "." + serviceName()
+}
+
+// 回复询问本服务类型或本实例的查询
+func (advertiser *Advertiser) handleQueries() {
+	defer advertiser.waitGroup.Done()
+
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, source, err := advertiser.conn.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buffer[:n])
+		if err != nil || header.Response {
+			continue
+		}
+		questions, err := parser.AllQuestions()
+		if err != nil {
+			continue
+		}
+		for _, question := range questions {
+			if !sameName(question.Name, serviceName()) && !sameName(question.Name, advertiser.instanceName()) {
+				continue
+			}
+			if source.Port != advertiser.conn.group.Port {
+				advertiser.replyLegacy(header.ID, question, source)
+			} else {
+				advertiser.announce(recordTTL)
+			}
+			break
+		}
+	}
+}
+
+// 从 5353 端口组播发送 PTR、SRV、TXT 与 A 记录
+func (advertiser *Advertiser) announce(ttl uint32) {
+	packet, err := advertiser.response(dnsmessage.Header{Response: true, Authoritative: true}, nil, ttl)
+	if err != nil {
+		log.Printf("❌ 构造 mDNS 回复失败: %s\n", err.Error())
+		return
+	}
+	advertiser.conn.conn.WriteToUDP(packet, advertiser.conn.group)
+}
+
+// 不是从 5353 端口发出的查询来自普通的 DNS 解析器 (RFC 6762 6.7)，只直接回复查询方；
+// 回复带上查询的 ID 与问题，记录不设置缓存刷新位并使用较短的有效期
+func (advertiser *Advertiser) replyLegacy(id uint16, question dnsmessage.Question, source *net.UDPAddr) {
+	header := dnsmessage.Header{ID: id, Response: true, Authoritative: true}
+	packet, err := advertiser.response(header, &question, legacyTTL)
+	if err != nil {
+		log.Printf("❌ 构造 mDNS 回复失败: %s\n", err.Error())
+		return
+	}
+	advertiser.conn.conn.WriteToUDP(packet, source)
+}
+
+// question 不为 nil 时为单播回复，需要重复查询的问题
+func (advertiser *Advertiser) response(header dnsmessage.Header, question *dnsmessage.Question, ttl uint32) ([]byte, error) {
+	instance := mustName(advertiser.instanceName())
+	host := mustName(localHostName())
+	// 组播回复中唯一的记录设置缓存刷新位
+	uniqueClass := dnsmessage.ClassINET | 1<<15
+	if question != nil {
+		uniqueClass = dnsmessage.ClassINET
+	}
+
+	builder := dnsmessage.NewBuilder(nil, header)
+	builder.EnableCompression()
+	if question != nil {
+		if err := builder.StartQuestions(); err != nil {
+			return nil, err
+		}
+		if err := builder.Question(*question); err != nil {
+			return nil, err
+		}
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	err := builder.PTRResource(
+		dnsmessage.ResourceHeader{Name: mustName(serviceName()), Class: dnsmessage.ClassINET, TTL: ttl},
+		dnsmessage.PTRResource{PTR: instance},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := builder.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	err = builder.SRVResource(
+		dnsmessage.ResourceHeader{Name: instance, Class: uniqueClass, TTL: ttl},
+		dnsmessage.SRVResource{Port: uint16(advertiser.Port), Target: host},
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = builder.TXTResource(
+		dnsmessage.ResourceHeader{Name: instance, Class: uniqueClass, TTL: ttl},
+		dnsmessage.TXTResource{TXT: []string{fingerprintKey + advertiser.Fingerprint}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, address := range localAddresses() {
+		var a dnsmessage.AResource
+		copy(a.A[:], address)
+		err = builder.AResource(dnsmessage.ResourceHeader{Name: host, Class: uniqueClass, TTL: ttl}, a)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
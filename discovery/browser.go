@@ -0,0 +1,281 @@
+package discovery
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// 定期重新查询的间隔，记录在有效期内没有刷新时被移除
+const browseInterval = 10 * time.Second
+
+// 局域网中发现的一个节点
+type Peer struct {
+	Instance    string
+	Host        string
+	Addresses   []net.IP
+	Port        int
+	Fingerprint string
+	ExpiresAt   time.Time
+	source      net.IP // 发出回复的地址
+}
+
+// 在局域网中浏览 _doubleratchet._tcp 服务
+type Browser struct {
+	stopChan     chan bool
+	stopOnce     sync.Once
+	waitGroup    sync.WaitGroup
+	conn         *mdnsConn
+	mutex        sync.Mutex
+	peers        map[string]*Peer
+	GroupAddress string         // mDNS 组播地址
+	Interface    *net.Interface // 为 nil 时由系统选择接口
+	OnChange     func()         // 节点列表变化时调用
+}
+
+// 回复中的记录按名称分组后再组合为节点
+type mdnsRecords struct {
+	instances []string
+	srv       map[string]dnsmessage.SRVResource
+	txt       map[string][]string
+	a         map[string][]net.IP
+	ttl       map[string]uint32
+}
+
+func NewBrowser() *Browser {
+	return &Browser{
+		stopChan:     make(chan bool),
+		peers:        make(map[string]*Peer),
+		GroupAddress: mdnsGroup,
+	}
+}
+
+func (browser *Browser) StartBrowser() error {
+	conn, err := listenMulticast(browser.GroupAddress, browser.Interface)
+	if err != nil {
+		return err
+	}
+	browser.conn = conn
+
+	// 查询从 5353 端口组播发出，回复同样通过组播到达
+	browser.waitGroup.Add(2)
+	go browser.handleResponses()
+	go browser.handleQuery()
+	return nil
+}
+
+func (browser *Browser) StopBrowser() {
+	browser.stopOnce.Do(func() {
+		close(browser.stopChan)
+		browser.conn.Close()
+		browser.waitGroup.Wait()
+	})
+}
+
+// 返回尚未过期的节点，按实例名排序
+func (browser *Browser) Peers() []*Peer {
+	browser.mutex.Lock()
+	defer browser.mutex.Unlock()
+
+	now := time.Now()
+	peers := []*Peer{}
+	for _, peer := range browser.peers {
+		if peer.ExpiresAt.After(now) {
+			peers = append(peers, peer)
+		}
+	}
+	slices.SortFunc(peers, func(a, b *Peer) int {
+		return strings.Compare(a.Instance, b.Instance)
+	})
+	return peers
+}
+
+// 节点可以连接的地址，对方有多个地址时优先使用发出回复的地址，该地址与本机一定可达
+func (peer *Peer) Address() string {
+	if len(peer.Addresses) == 0 {
+		return ""
+	}
+	address := peer.Addresses[0]
+	for _, candidate := range peer.Addresses {
+		if candidate.Equal(peer.source) {
+			address = candidate
+			break
+		}
+	}
+	return net.JoinHostPort(address.String(), strconv.Itoa(peer.Port))
+}
+
+// 定期组播 PTR 查询，并清理过期的节点
+func (browser *Browser) handleQuery() {
+	defer browser.waitGroup.Done()
+
+	ticker := time.NewTicker(browseInterval)
+	defer ticker.Stop()
+	for {
+		browser.query()
+		browser.expire()
+
+		select {
+		case <-browser.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (browser *Browser) query() {
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	builder.StartQuestions()
+	builder.Question(dnsmessage.Question{
+		Name:  mustName(serviceName()),
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	})
+	packet, err := builder.Finish()
+	if err != nil {
+		return
+	}
+	browser.conn.conn.WriteToUDP(packet, browser.conn.group)
+}
+
+func (browser *Browser) expire() {
+	browser.mutex.Lock()
+	now := time.Now()
+	changed := false
+	for name, peer := range browser.peers {
+		if !peer.ExpiresAt.After(now) {
+			delete(browser.peers, name)
+			changed = true
+		}
+	}
+	browser.mutex.Unlock()
+
+	if changed && browser.OnChange != nil {
+		browser.OnChange()
+	}
+}
+
+func (browser *Browser) handleResponses() {
+	defer browser.waitGroup.Done()
+
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, source, err := browser.conn.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		records, err := parseResponse(buffer[:n])
+		if err != nil || len(records.instances) == 0 {
+			continue
+		}
+		if browser.update(records, source) && browser.OnChange != nil {
+			browser.OnChange()
+		}
+	}
+}
+
+// 根据回复更新节点列表，返回列表是否发生变化
+func (browser *Browser) update(records *mdnsRecords, source *net.UDPAddr) bool {
+	browser.mutex.Lock()
+	defer browser.mutex.Unlock()
+
+	changed := false
+	for _, instance := range records.instances {
+		// 有效期为 0 表示该服务已经下线
+		if records.ttl[instance] == 0 {
+			if _, ok := browser.peers[instance]; ok {
+				delete(browser.peers, instance)
+				changed = true
+			}
+			continue
+		}
+		srv, ok := records.srv[instance]
+		if !ok {
+			continue
+		}
+
+		host := strings.ToLower(srv.Target.String())
+		addresses := records.a[host]
+		if len(addresses) == 0 {
+			addresses = []net.IP{source.IP}
+		}
+		fingerprint := ""
+		for _, text := range records.txt[instance] {
+			if value, ok := strings.CutPrefix(text, fingerprintKey); ok {
+				fingerprint = value
+			}
+		}
+
+		label, _, _ := strings.Cut(instance, ".")
+		if _, ok := browser.peers[instance]; !ok {
+			changed = true
+		}
+		browser.peers[instance] = &Peer{
+			Instance:    label,
+			Host:        host,
+			Addresses:   addresses,
+			Port:        int(srv.Port),
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().Add(time.Duration(records.ttl[instance]) * time.Second),
+			source:      source.IP,
+		}
+	}
+	return changed
+}
+
+// 解析回复中所有段落的记录
+func parseResponse(packet []byte) (*mdnsRecords, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(packet)
+	if err != nil {
+		return nil, err
+	}
+	records := &mdnsRecords{
+		srv: make(map[string]dnsmessage.SRVResource),
+		txt: make(map[string][]string),
+		a:   make(map[string][]net.IP),
+		ttl: make(map[string]uint32),
+	}
+	if !header.Response {
+		return records, nil
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+
+	answers, err := parser.AllAnswers()
+	if err != nil {
+		return nil, err
+	}
+	if err := parser.SkipAllAuthorities(); err != nil {
+		return nil, err
+	}
+	additionals, err := parser.AllAdditionals()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, resource := range append(answers, additionals...) {
+		name := strings.ToLower(resource.Header.Name.String())
+		switch body := resource.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if sameName(resource.Header.Name, serviceName()) {
+				instance := strings.ToLower(body.PTR.String())
+				records.instances = append(records.instances, instance)
+				records.ttl[instance] = resource.Header.TTL
+			}
+		case *dnsmessage.SRVResource:
+			records.srv[name] = *body
+		case *dnsmessage.TXTResource:
+			records.txt[name] = body.TXT
+		case *dnsmessage.AResource:
+			records.a[name] = append(records.a[name], net.IP(body.A[:]))
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	ServiceType    = "_doubleratchet._tcp"
+	mdnsDomain     = "local."
+	mdnsGroup      = "224.0.0.251:5353"
+	recordTTL      = 120 // 记录的有效期 (秒)
+	legacyTTL      = 10  // 回复普通 DNS 查询时的有效期 (秒)
+	maxPacketSize  = 9000
+	fingerprintKey = "fp="
+)
+
+var ErrInstanceName = errors.New("服务实例名不能为空")
+
+// 绑定 5353 端口的 mDNS 套接字，组播的查询与回复都从该端口收发
+type mdnsConn struct {
+	conn  *net.UDPConn
+	group *net.UDPAddr
+}
+
+// 服务类型的完整域名，浏览时查询该名称的 PTR 记录
+func serviceName() string {
+	return ServiceType + "." + mdnsDomain
+}
+
+// 实例名作为 DNS 的一个标签，不能包含点并且不能超过 63 字节
+func instanceLabel(instance string) string {
+	label := strings.ReplaceAll(instance, ".", "-")
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return label
+}
+
+// 本机在 .local 域中的主机名
+func localHostName() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "doubleratchet"
+	}
+	return instanceLabel(strings.Split(hostname, ".")[0]) + "." + mdnsDomain
+}
+
+// 本机所有已启用接口上的 IPv4 地址，没有其他地址时才使用回环地址
+func localAddresses() []net.IP {
+	var addresses, loopback []net.IP
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			if ipNet.IP.IsLoopback() {
+				loopback = append(loopback, ipNet.IP.To4())
+			} else {
+				addresses = append(addresses, ipNet.IP.To4())
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return loopback
+	}
+	return addresses
+}
+
+// 加入 mDNS 组播组，套接字绑定通配地址与组播端口，组播的回复必须从 5353 端口发出；
+// 重新打开组播回环，同一台主机上的其他节点也能收到查询与回复
+func listenMulticast(groupAddress string, iface *net.Interface) (*mdnsConn, error) {
+	group, err := net.ResolveUDPAddr("udp4", groupAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", iface, group)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadBuffer(maxPacketSize * 8)
+	if err := ipv4.NewPacketConn(conn).SetMulticastLoopback(true); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &mdnsConn{conn: conn, group: group}, nil
+}
+
+func (conn *mdnsConn) Close() {
+	conn.conn.Close()
+}
+
+func mustName(name string) dnsmessage.Name {
+	return dnsmessage.MustNewName(name)
+}
+
+// DNS 名称不区分大小写
+func sameName(name dnsmessage.Name, expected string) bool {
+	return strings.EqualFold(name.String(), expected)
+}
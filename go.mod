@@ -3,8 +3,13 @@ module github.com/reagin/double_ratchet
 go 1.24.1
 
 require (
+	filippo.io/edwards25519 v1.1.0
 	fyne.io/fyne/v2 v2.5.5
+	github.com/gorilla/websocket v1.5.3
 	github.com/libp2p/go-reuseport v0.4.0
+	github.com/makiuchi-d/gozxing v0.1.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/net v0.25.0
 )
 
 require (
@@ -34,8 +39,8 @@ require (
 	github.com/yuin/goldmark v1.7.1 // indirect
 	golang.org/x/image v0.18.0 // indirect
 	golang.org/x/mobile v0.0.0-20231127183840-76ac6878050a // indirect
-	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
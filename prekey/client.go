@@ -0,0 +1,322 @@
+package prekey
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+var ErrBundleSignature = errors.New("预密钥包的签名预密钥签名无效")
+
+// 预密钥服务的客户端，保存自己上传的预密钥对应的私钥
+type Client struct {
+	mutex          sync.Mutex
+	nextPreKeyID   uint32
+	signedPreKeyID uint32
+	preKeys        map[uint32]*utils.DiffeHellmanKeyPair
+	signedPreKeys  map[uint32]*utils.DiffeHellmanKeyPair
+	keyStore       KeyStore // 为空时私钥只保存在内存中
+	IdentityKey    *utils.DiffeHellmanKeyPair
+	ServerAddress  string
+	Timeout        time.Duration
+}
+
+func NewClient(serverAddress string, identityKey *utils.DiffeHellmanKeyPair) *Client {
+	// 预密钥编号从随机值开始，客户端重启后上传的新预密钥不会与旧编号冲突
+	return &Client{
+		nextPreKeyID:   rand.Uint32() >> 1,
+		signedPreKeyID: rand.Uint32() >> 1,
+		preKeys:        make(map[uint32]*utils.DiffeHellmanKeyPair),
+		signedPreKeys:  make(map[uint32]*utils.DiffeHellmanKeyPair),
+		IdentityKey:    identityKey,
+		ServerAddress:  serverAddress,
+		Timeout:        10 * time.Second,
+	}
+}
+
+// 创建将私钥保存到 store 的客户端，并恢复之前保存的私钥
+func NewClientWithStore(serverAddress string, identityKey *utils.DiffeHellmanKeyPair, store KeyStore) (*Client, error) {
+	client := NewClient(serverAddress, identityKey)
+	client.keyStore = store
+
+	record, err := store.LoadKeys(client.identity())
+	if err != nil || record == nil {
+		return client, err
+	}
+	client.nextPreKeyID = record.NextPreKeyID
+	client.signedPreKeyID = record.SignedPreKeyID
+	if client.preKeys, err = keyPairs(record.PreKeys); err != nil {
+		return nil, err
+	}
+	if client.signedPreKeys, err = keyPairs(record.SignedPreKeys); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// 生成并上传 count 个一次性预密钥，首次上传时同时生成签名预密钥，
+// 返回服务端剩余的一次性预密钥数量
+func (client *Client) Upload(count int) (int, error) {
+	client.mutex.Lock()
+	request := &Message{Type: UploadType}
+	if len(client.signedPreKeys) == 0 {
+		signedPreKey, err := client.newSignedPreKey()
+		if err != nil {
+			client.mutex.Unlock()
+			return 0, err
+		}
+		request.SignedPreKey = signedPreKey
+	}
+	for range count {
+		client.nextPreKeyID++
+		keyPair := utils.NewDiffeHellmanKeyPair()
+		client.preKeys[client.nextPreKeyID] = keyPair
+		request.OneTimePreKeys = append(request.OneTimePreKeys, &PreKey{
+			ID:        client.nextPreKeyID,
+			PublicKey: keyPair.PublicKey.Bytes(),
+		})
+	}
+	// 先保存私钥再上传，避免对端取得预密钥后本地却已丢失私钥
+	if err := client.saveKeys(); err != nil {
+		client.mutex.Unlock()
+		return 0, err
+	}
+	client.mutex.Unlock()
+
+	response, err := client.request(request)
+	if err != nil {
+		return 0, err
+	}
+	return response.Remaining, nil
+}
+
+// 生成新的签名预密钥并上传，旧的私钥仍然保留，以便处理使用旧预密钥发起的会话
+func (client *Client) RotateSignedPreKey() error {
+	client.mutex.Lock()
+	signedPreKey, err := client.newSignedPreKey()
+	if err == nil {
+		err = client.saveKeys()
+	}
+	client.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.request(&Message{Type: UploadType, SignedPreKey: signedPreKey})
+	return err
+}
+
+// 查询服务端剩余的一次性预密钥数量，以及是否需要补充
+func (client *Client) Status() (int, bool, error) {
+	response, err := client.request(&Message{Type: StatusType})
+	if err != nil {
+		return 0, false, err
+	}
+	return response.Remaining, response.Replenish, nil
+}
+
+// 获取对端的预密钥包，并验证签名预密钥的签名
+func (client *Client) Fetch(identityKey []byte) (*Bundle, error) {
+	response, err := client.roundTrip(&Message{Type: FetchType, IdentityKey: identityKey})
+	if err != nil {
+		return nil, err
+	}
+	bundle := response.Bundle
+	if bundle == nil || bundle.SignedPreKey == nil {
+		return nil, ErrInvalidRequest
+	}
+
+	if _, err := utils.BytesToPublicKey(bundle.SignedPreKey.PublicKey); err != nil {
+		return nil, err
+	}
+	if !utils.XEdDSAVerify(identityKey, bundle.SignedPreKey.PublicKey, bundle.SignedPreKey.Signature) {
+		return nil, ErrBundleSignature
+	}
+	if bundle.OneTimePreKey != nil {
+		if _, err := utils.BytesToPublicKey(bundle.OneTimePreKey.PublicKey); err != nil {
+			return nil, err
+		}
+	}
+	return bundle, nil
+}
+
+// 订阅低水位通知，通道中为服务端剩余的一次性预密钥数量，
+// 关闭 stopChan 或连接断开时通道被关闭
+func (client *Client) Subscribe(stopChan chan bool) (<-chan int, error) {
+	connect, err := net.DialTimeout("tcp", client.ServerAddress, client.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(connect)
+	writer := bufio.NewWriter(connect)
+
+	request, err := client.sign(&Message{Type: SubscribeType})
+	if err == nil {
+		err = writeMessage(writer, request)
+	}
+	var response *Message
+	if err == nil {
+		response, err = readMessage(reader)
+	}
+	if err == nil && response.Error != "" {
+		err = errors.New(response.Error)
+	}
+	if err != nil {
+		connect.Close()
+		return nil, err
+	}
+
+	notices := make(chan int, 1)
+	// 订阅时已低于水位，立即通知一次
+	if response.Replenish {
+		notices <- response.Remaining
+	}
+	go func() {
+		<-stopChan
+		connect.Close()
+	}()
+	go func() {
+		defer close(notices)
+		for {
+			notice, err := readMessage(reader)
+			if err != nil {
+				return
+			}
+			if notice.Type == NoticeType {
+				select {
+				case notices <- notice.Remaining:
+				default:
+				}
+			}
+		}
+	}()
+	return notices, nil
+}
+
+// 取出一次性预密钥对应的密钥对，每个一次性预密钥只能使用一次
+func (client *Client) OneTimePreKey(id uint32) *utils.DiffeHellmanKeyPair {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	keyPair, ok := client.preKeys[id]
+	if !ok {
+		return nil
+	}
+	delete(client.preKeys, id)
+	if err := client.saveKeys(); err != nil {
+		log.Printf("❌ 保存预密钥私钥失败: %s\n", err.Error())
+	}
+	return keyPair
+}
+
+func (client *Client) SignedPreKey(id uint32) *utils.DiffeHellmanKeyPair {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.signedPreKeys[id]
+}
+
+// 调用方需持有 client.mutex
+func (client *Client) newSignedPreKey() (*SignedPreKey, error) {
+	keyPair := utils.NewDiffeHellmanKeyPair()
+	signature, err := client.IdentityKey.Sign(keyPair.PublicKey.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	client.signedPreKeyID++
+	client.signedPreKeys[client.signedPreKeyID] = keyPair
+	return &SignedPreKey{
+		ID:        client.signedPreKeyID,
+		PublicKey: keyPair.PublicKey.Bytes(),
+		Signature: signature,
+		Timestamp: time.Now().UnixNano(),
+	}, nil
+}
+
+// 将私钥写入存储后端，调用方需持有 client.mutex
+func (client *Client) saveKeys() error {
+	if client.keyStore == nil {
+		return nil
+	}
+	record := &KeyRecord{
+		NextPreKeyID:   client.nextPreKeyID,
+		SignedPreKeyID: client.signedPreKeyID,
+		PreKeys:        make(map[uint32][]byte, len(client.preKeys)),
+		SignedPreKeys:  make(map[uint32][]byte, len(client.signedPreKeys)),
+	}
+	for id, keyPair := range client.preKeys {
+		record.PreKeys[id] = keyPair.PrivateKey.Bytes()
+	}
+	for id, keyPair := range client.signedPreKeys {
+		record.SignedPreKeys[id] = keyPair.PrivateKey.Bytes()
+	}
+	return client.keyStore.SaveKeys(client.identity(), record)
+}
+
+func (client *Client) identity() string {
+	return hex.EncodeToString(client.IdentityKey.PublicKey.Bytes())
+}
+
+func keyPairs(privateKeys map[uint32][]byte) (map[uint32]*utils.DiffeHellmanKeyPair, error) {
+	keyPairs := make(map[uint32]*utils.DiffeHellmanKeyPair, len(privateKeys))
+	for id, keyBytes := range privateKeys {
+		privateKey, err := ecdh.X25519().NewPrivateKey(keyBytes)
+		if err != nil {
+			return nil, err
+		}
+		keyPairs[id] = &utils.DiffeHellmanKeyPair{PrivateKey: privateKey, PublicKey: privateKey.PublicKey()}
+	}
+	return keyPairs, nil
+}
+
+// 使用身份密钥签名请求
+func (client *Client) sign(request *Message) (*Message, error) {
+	request.IdentityKey = client.IdentityKey.PublicKey.Bytes()
+	request.Timestamp = time.Now().UnixNano()
+	signature, err := client.IdentityKey.Sign(request.authPayload())
+	if err != nil {
+		return nil, err
+	}
+	request.Signature = signature
+	return request, nil
+}
+
+// 签名并发送需要认证的请求
+func (client *Client) request(request *Message) (*Message, error) {
+	request, err := client.sign(request)
+	if err != nil {
+		return nil, err
+	}
+	return client.roundTrip(request)
+}
+
+// 建立连接发送请求并读取回复
+func (client *Client) roundTrip(request *Message) (*Message, error) {
+	connect, err := net.DialTimeout("tcp", client.ServerAddress, client.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer connect.Close()
+	connect.SetDeadline(time.Now().Add(client.Timeout))
+
+	if err := writeMessage(bufio.NewWriter(connect), request); err != nil {
+		return nil, err
+	}
+	response, err := readMessage(bufio.NewReader(connect))
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response, nil
+}
@@ -0,0 +1,90 @@
+package prekey
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	UploadType    = "upload"    // 上传签名预密钥与一次性预密钥
+	FetchType     = "fetch"     // 获取预密钥包，同时消耗一个一次性预密钥
+	StatusType    = "status"    // 查询剩余的一次性预密钥数量
+	SubscribeType = "subscribe" // 订阅一次性预密钥不足的通知
+	ResultType    = "result"    // 服务端对请求的回复
+	BundleType    = "bundle"    // 服务端返回的预密钥包
+	NoticeType    = "notice"    // 一次性预密钥低于水位时推送给订阅者
+)
+
+// 单条信息的最大长度，足以容纳一次上传上万个一次性预密钥
+const maxMessageSize = 1 << 20
+
+// 一次性预密钥，每个只会被分发一次
+type PreKey struct {
+	ID        uint32
+	PublicKey []byte
+}
+
+// 使用身份密钥 XEdDSA 签名的预密钥，定期轮换
+type SignedPreKey struct {
+	ID        uint32
+	PublicKey []byte
+	Signature []byte
+	Timestamp int64
+}
+
+// 发起异步会话所需的对端公钥，一次性预密钥耗尽时 OneTimePreKey 为空
+type Bundle struct {
+	IdentityKey   []byte
+	SignedPreKey  *SignedPreKey
+	OneTimePreKey *PreKey
+}
+
+// 客户端与预密钥服务之间交换的信息
+type Message struct {
+	Type           string
+	IdentityKey    []byte
+	SignedPreKey   *SignedPreKey
+	OneTimePreKeys []*PreKey
+	Bundle         *Bundle
+	Remaining      int    // 剩余的一次性预密钥数量
+	Replenish      bool   // 是否需要补充一次性预密钥
+	Timestamp      int64  // 请求时间，用于拒绝重放的请求
+	Signature      []byte // 身份密钥对请求内容的签名
+	Error          string
+}
+
+// 需要证明身份的请求所签名的内容：类型、身份公钥、时间戳与上传的预密钥
+func (message *Message) authPayload() []byte {
+	preKeys, _ := json.Marshal([]any{message.SignedPreKey, message.OneTimePreKeys})
+	preKeysHash := sha256.Sum256(preKeys)
+
+	payload := []byte("DoubleRatchetDemo prekey " + message.Type)
+	payload = append(payload, message.IdentityKey...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(message.Timestamp))
+	return append(payload, preKeysHash[:]...)
+}
+
+func writeMessage(writer *bufio.Writer, message *Message) error {
+	messageBytes, _ := json.Marshal(message)
+	messageBytes, _ = utils.EncodeMessage(messageBytes)
+	if _, err := writer.Write(messageBytes); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func readMessage(reader *bufio.Reader) (*Message, error) {
+	messageBytes, err := utils.DecodeMessageLimit(reader, maxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+	message := &Message{}
+	if err := json.Unmarshal(messageBytes, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
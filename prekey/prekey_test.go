@@ -0,0 +1,191 @@
+package prekey
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+func startTestServer(t *testing.T, store Store) *Server {
+	t.Helper()
+	server := NewServer("127.0.0.1:0", store)
+	server.LowWatermark = 2
+	if err := server.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.StopServer)
+	return server
+}
+
+func TestUploadAndFetch(t *testing.T) {
+	server := startTestServer(t, NewMemoryStore())
+	address := server.netListener.Addr().String()
+	owner := NewClient(address, utils.NewDiffeHellmanKeyPair())
+	if remaining, err := owner.Upload(3); err != nil || remaining != 3 {
+		t.Fatalf("upload: %d, %v", remaining, err)
+	}
+
+	identityKey := owner.IdentityKey.PublicKey.Bytes()
+	fetcher := NewClient(address, utils.NewDiffeHellmanKeyPair())
+	seen := make(map[uint32]bool)
+	for range 3 {
+		bundle, err := fetcher.Fetch(identityKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		preKey := bundle.OneTimePreKey
+		if preKey == nil || seen[preKey.ID] {
+			t.Fatalf("expected a fresh one-time prekey, got %+v", preKey)
+		}
+		seen[preKey.ID] = true
+
+		// 分发的公钥必须与上传方保存的私钥对应
+		keyPair := owner.OneTimePreKey(preKey.ID)
+		if keyPair == nil || !bytes.Equal(keyPair.PublicKey.Bytes(), preKey.PublicKey) {
+			t.Fatalf("missing private key for prekey #%d", preKey.ID)
+		}
+		if owner.OneTimePreKey(preKey.ID) != nil {
+			t.Fatal("a one-time prekey was returned twice")
+		}
+		if owner.SignedPreKey(bundle.SignedPreKey.ID) == nil {
+			t.Fatal("missing private key for the signed prekey")
+		}
+	}
+
+	// 一次性预密钥耗尽后仍然返回签名预密钥
+	bundle, err := fetcher.Fetch(identityKey)
+	if err != nil || bundle.OneTimePreKey != nil {
+		t.Fatalf("expected a bundle without one-time prekey, got %+v, %v", bundle, err)
+	}
+	remaining, replenish, err := owner.Status()
+	if err != nil || remaining != 0 || !replenish {
+		t.Fatalf("status: %d, %v, %v", remaining, replenish, err)
+	}
+	if _, err := fetcher.Fetch(fetcher.IdentityKey.PublicKey.Bytes()); err == nil {
+		t.Fatal("fetched a bundle for an unknown identity")
+	}
+}
+
+func TestRejectReplayedRequest(t *testing.T) {
+	server := startTestServer(t, NewMemoryStore())
+	client := NewClient(server.netListener.Addr().String(), utils.NewDiffeHellmanKeyPair())
+	if _, err := client.Upload(1); err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := client.sign(&Message{Type: StatusType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.roundTrip(request); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.roundTrip(request); err == nil || err.Error() != ErrStaleRequest.Error() {
+		t.Fatalf("expected a stale request error, got %v", err)
+	}
+
+	request, err = client.sign(&Message{Type: StatusType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Signature[0] ^= 0x01
+	if _, err := client.roundTrip(request); err == nil || err.Error() != ErrSignature.Error() {
+		t.Fatalf("expected a signature error, got %v", err)
+	}
+}
+
+func TestPersistPrivateKeys(t *testing.T) {
+	server := startTestServer(t, NewMemoryStore())
+	address := server.netListener.Addr().String()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identityKey := utils.NewDiffeHellmanKeyPair()
+	client, err := NewClientWithStore(address, identityKey, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Upload(2); err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := NewClient(address, utils.NewDiffeHellmanKeyPair()).Fetch(identityKey.PublicKey.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后的客户端仍能取回已分发预密钥的私钥
+	restarted, err := NewClientWithStore(address, identityKey, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedPreKey := restarted.SignedPreKey(bundle.SignedPreKey.ID)
+	if signedPreKey == nil || !bytes.Equal(signedPreKey.PublicKey.Bytes(), bundle.SignedPreKey.PublicKey) {
+		t.Fatal("lost the signed prekey after restart")
+	}
+	oneTimePreKey := restarted.OneTimePreKey(bundle.OneTimePreKey.ID)
+	if oneTimePreKey == nil || !bytes.Equal(oneTimePreKey.PublicKey.Bytes(), bundle.OneTimePreKey.PublicKey) {
+		t.Fatal("lost the one-time prekey after restart")
+	}
+
+	// 已使用的一次性预密钥不会在下次重启后恢复
+	restarted, err = NewClientWithStore(address, identityKey, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted.OneTimePreKey(bundle.OneTimePreKey.ID) != nil {
+		t.Fatal("a used one-time prekey was restored")
+	}
+	if remaining, err := restarted.Upload(1); err != nil || remaining != 2 {
+		t.Fatalf("upload after restart: %d, %v", remaining, err)
+	}
+}
+
+func TestStopServerWithIdleConnection(t *testing.T) {
+	server := NewServer("127.0.0.1:0", NewMemoryStore())
+	if err := server.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	connect, err := net.Dial("tcp", server.netListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connect.Close()
+	// 等待服务端开始处理该连接
+	time.Sleep(100 * time.Millisecond)
+
+	stopped := make(chan bool)
+	go func() {
+		server.StopServer()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("StopServer blocked on an idle connection")
+	}
+}
+
+// 声明超长长度的请求在分配内存前被拒绝，服务端直接关闭连接
+func TestRejectOversizedMessage(t *testing.T) {
+	server := startTestServer(t, NewMemoryStore())
+	connect, err := net.Dial("tcp", server.netListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connect.Close()
+
+	if _, err := connect.Write([]byte{0xff, 0xff, 0xff, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+	connect.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := connect.Read(make([]byte, 1)); err == nil {
+		t.Fatal("the server answered an oversized request")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatal("the server kept reading an oversized request")
+	}
+}
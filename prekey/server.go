@@ -0,0 +1,322 @@
+package prekey
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 请求时间戳与服务端时间允许的最大偏差
+const maxClockSkew = 5 * time.Minute
+
+// 读取请求与写入回复的最长时间，避免空闲连接使 StopServer 无法返回
+const requestTimeout = 10 * time.Second
+
+var ErrNotFound = errors.New("未找到该身份的预密钥")
+var ErrSignature = errors.New("签名验证失败")
+var ErrStaleRequest = errors.New("请求已过期或被重放")
+var ErrInvalidRequest = errors.New("无效的请求")
+
+// 保存各身份上传的预密钥，并在获取时分发一次性预密钥
+type Server struct {
+	stopChan     chan bool
+	stopOnce     sync.Once
+	waitGroup    sync.WaitGroup
+	netListener  net.Listener
+	mutex        sync.Mutex
+	subscribers  map[string]map[chan int]bool
+	Store        Store
+	LowWatermark int // 一次性预密钥少于该数量时通知补充
+	LocalAddress string
+}
+
+func NewServer(localAddress string, store Store) *Server {
+	return &Server{
+		stopChan:     make(chan bool),
+		subscribers:  make(map[string]map[chan int]bool),
+		Store:        store,
+		LowWatermark: 10,
+		LocalAddress: localAddress,
+	}
+}
+
+func (server *Server) StartServer() error {
+	listener, err := net.Listen("tcp", server.LocalAddress)
+	if err != nil {
+		return err
+	}
+	server.netListener = listener
+	log.Printf("🎉 预密钥服务已开始监听: %s\n", listener.Addr().String())
+
+	server.waitGroup.Add(1)
+	go server.handleServer()
+	return nil
+}
+
+func (server *Server) StopServer() {
+	server.stopOnce.Do(func() {
+		close(server.stopChan)
+		server.netListener.Close()
+		server.waitGroup.Wait()
+		log.Println("✅ 预密钥服务已关闭")
+	})
+}
+
+func (server *Server) handleServer() {
+	defer server.waitGroup.Done()
+
+	for {
+		connect, err := server.netListener.Accept()
+		if err != nil {
+			return
+		}
+		server.waitGroup.Add(1)
+		go server.handleConnection(connect)
+	}
+}
+
+// 每条连接处理一个请求，订阅请求会保持连接直到任意一方关闭
+func (server *Server) handleConnection(connect net.Conn) {
+	defer server.waitGroup.Done()
+	defer connect.Close()
+
+	// 服务关闭时立即断开仍在处理的连接
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-server.stopChan:
+			connect.Close()
+		case <-done:
+		}
+	}()
+
+	connect.SetDeadline(time.Now().Add(requestTimeout))
+	reader := bufio.NewReader(connect)
+	writer := bufio.NewWriter(connect)
+	request, err := readMessage(reader)
+	if err != nil {
+		return
+	}
+
+	var response *Message
+	switch request.Type {
+	case UploadType:
+		response, err = server.handleUpload(request)
+	case FetchType:
+		response, err = server.handleFetch(request)
+	case StatusType:
+		response, err = server.handleStatus(request)
+	case SubscribeType:
+		server.handleSubscribe(request, connect, reader, writer)
+		return
+	default:
+		err = ErrInvalidRequest
+	}
+	if err != nil {
+		response = &Message{Type: ResultType, Error: err.Error()}
+	}
+	writeMessage(writer, response)
+}
+
+// 上传签名预密钥与一次性预密钥，签名预密钥的时间戳较新时替换旧的签名预密钥
+func (server *Server) handleUpload(request *Message) (*Message, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	identity, record, err := server.authenticate(request)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		if request.SignedPreKey == nil {
+			return nil, ErrInvalidRequest
+		}
+		record = &Record{IdentityKey: request.IdentityKey}
+	}
+	record.LastRequest = request.Timestamp
+
+	if signedPreKey := request.SignedPreKey; signedPreKey != nil {
+		if _, err := utils.BytesToPublicKey(signedPreKey.PublicKey); err != nil {
+			return nil, err
+		}
+		if !utils.XEdDSAVerify(request.IdentityKey, signedPreKey.PublicKey, signedPreKey.Signature) {
+			return nil, ErrSignature
+		}
+		if record.SignedPreKey == nil || signedPreKey.Timestamp > record.SignedPreKey.Timestamp {
+			record.SignedPreKey = signedPreKey
+			log.Printf("🔄 身份 %s 的签名预密钥已更新为 #%d\n", identity[:8], signedPreKey.ID)
+		}
+	}
+
+	known := make(map[uint32]bool)
+	for _, preKey := range record.OneTimePreKeys {
+		known[preKey.ID] = true
+	}
+	for _, preKey := range request.OneTimePreKeys {
+		if _, err := utils.BytesToPublicKey(preKey.PublicKey); err != nil {
+			return nil, err
+		}
+		if !known[preKey.ID] {
+			known[preKey.ID] = true
+			record.OneTimePreKeys = append(record.OneTimePreKeys, preKey)
+		}
+	}
+
+	if err := server.Store.Save(identity, record); err != nil {
+		return nil, err
+	}
+	return server.result(record), nil
+}
+
+// 返回预密钥包并删除其中的一次性预密钥，剩余数量低于水位时通知订阅者
+func (server *Server) handleFetch(request *Message) (*Message, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	identity := hex.EncodeToString(request.IdentityKey)
+	record, err := server.Store.Load(identity)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || record.SignedPreKey == nil {
+		return nil, ErrNotFound
+	}
+
+	bundle := &Bundle{
+		IdentityKey:  record.IdentityKey,
+		SignedPreKey: record.SignedPreKey,
+	}
+	if len(record.OneTimePreKeys) > 0 {
+		bundle.OneTimePreKey = record.OneTimePreKeys[0]
+		record.OneTimePreKeys = record.OneTimePreKeys[1:]
+		if err := server.Store.Save(identity, record); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(record.OneTimePreKeys) < server.LowWatermark {
+		for subscriber := range server.subscribers[identity] {
+			select {
+			case subscriber <- len(record.OneTimePreKeys):
+			default:
+			}
+		}
+	}
+
+	return &Message{Type: BundleType, Bundle: bundle}, nil
+}
+
+func (server *Server) handleStatus(request *Message) (*Message, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	identity, record, err := server.authenticate(request)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrNotFound
+	}
+	record.LastRequest = request.Timestamp
+	if err := server.Store.Save(identity, record); err != nil {
+		return nil, err
+	}
+	return server.result(record), nil
+}
+
+// 订阅者在连接上持续接收低水位通知，连接断开时取消订阅
+func (server *Server) handleSubscribe(request *Message, connect net.Conn, reader *bufio.Reader, writer *bufio.Writer) {
+	server.mutex.Lock()
+	identity, record, err := server.authenticate(request)
+	if err == nil && record == nil {
+		err = ErrNotFound
+	}
+	if err != nil {
+		server.mutex.Unlock()
+		writeMessage(writer, &Message{Type: ResultType, Error: err.Error()})
+		return
+	}
+	record.LastRequest = request.Timestamp
+	server.Store.Save(identity, record)
+
+	notices := make(chan int, 1)
+	if server.subscribers[identity] == nil {
+		server.subscribers[identity] = make(map[chan int]bool)
+	}
+	server.subscribers[identity][notices] = true
+	response := server.result(record)
+	server.mutex.Unlock()
+
+	defer func() {
+		server.mutex.Lock()
+		delete(server.subscribers[identity], notices)
+		if len(server.subscribers[identity]) == 0 {
+			delete(server.subscribers, identity)
+		}
+		server.mutex.Unlock()
+	}()
+	if err := writeMessage(writer, response); err != nil {
+		return
+	}
+	connect.SetDeadline(time.Time{})
+
+	// 订阅者不会再发送数据，读取返回说明连接已断开
+	closed := make(chan bool)
+	go func() {
+		reader.Peek(1)
+		close(closed)
+	}()
+	for {
+		select {
+		case remaining := <-notices:
+			notice := &Message{Type: NoticeType, Remaining: remaining, Replenish: true}
+			connect.SetWriteDeadline(time.Now().Add(requestTimeout))
+			if err := writeMessage(writer, notice); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-server.stopChan:
+			return
+		}
+	}
+}
+
+// 校验请求的签名与时间戳，调用方需持有 server.mutex
+func (server *Server) authenticate(request *Message) (string, *Record, error) {
+	if _, err := utils.BytesToPublicKey(request.IdentityKey); err != nil {
+		return "", nil, err
+	}
+	if !utils.XEdDSAVerify(request.IdentityKey, request.authPayload(), request.Signature) {
+		return "", nil, ErrSignature
+	}
+	requestTime := time.Unix(0, request.Timestamp)
+	if time.Since(requestTime).Abs() > maxClockSkew {
+		return "", nil, ErrStaleRequest
+	}
+
+	identity := hex.EncodeToString(request.IdentityKey)
+	record, err := server.Store.Load(identity)
+	if err != nil {
+		return "", nil, err
+	}
+	if record != nil && request.Timestamp <= record.LastRequest {
+		return "", nil, ErrStaleRequest
+	}
+	return identity, record, nil
+}
+
+func (server *Server) result(record *Record) *Message {
+	return &Message{
+		Type:      ResultType,
+		Remaining: len(record.OneTimePreKeys),
+		Replenish: len(record.OneTimePreKeys) < server.LowWatermark,
+	}
+}
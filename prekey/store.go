@@ -0,0 +1,143 @@
+package prekey
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 单个身份在服务端保存的预密钥
+type Record struct {
+	IdentityKey    []byte
+	SignedPreKey   *SignedPreKey
+	OneTimePreKeys []*PreKey
+	LastRequest    int64 // 最近一次通过认证的请求时间戳
+}
+
+// 预密钥的存储后端，Load 在记录不存在时返回 nil, nil
+type Store interface {
+	Load(identity string) (*Record, error)
+	Save(identity string, record *Record) error
+}
+
+// 客户端保存的预密钥私钥，服务端只保存公钥；私钥丢失后，
+// 对端使用这些预密钥发起的会话将无法完成
+type KeyRecord struct {
+	NextPreKeyID   uint32
+	SignedPreKeyID uint32
+	PreKeys        map[uint32][]byte
+	SignedPreKeys  map[uint32][]byte
+}
+
+// 预密钥私钥的存储后端，LoadKeys 在记录不存在时返回 nil, nil
+type KeyStore interface {
+	LoadKeys(identity string) (*KeyRecord, error)
+	SaveKeys(identity string, record *KeyRecord) error
+}
+
+// 保存在内存中的存储后端，服务重启后数据丢失
+type MemoryStore struct {
+	mutex   sync.Mutex
+	records map[string][]byte
+}
+
+// 每个身份保存为目录下的一个 JSON 文件，客户端的私钥另存为 .keys.json 文件
+type FileStore struct {
+	Directory string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string][]byte)}
+}
+
+func (store *MemoryStore) Load(identity string) (*Record, error) {
+	store.mutex.Lock()
+	data, ok := store.records[identity]
+	store.mutex.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	record := &Record{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (store *MemoryStore) Save(identity string, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	store.records[identity] = data
+	store.mutex.Unlock()
+	return nil
+}
+
+func NewFileStore(directory string) (*FileStore, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{Directory: directory}, nil
+}
+
+func (store *FileStore) Load(identity string) (*Record, error) {
+	record := &Record{}
+	if ok, err := readFile(store.path(identity, ".json"), record); !ok {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (store *FileStore) Save(identity string, record *Record) error {
+	return writeFile(store.path(identity, ".json"), record)
+}
+
+func (store *FileStore) LoadKeys(identity string) (*KeyRecord, error) {
+	record := &KeyRecord{}
+	if ok, err := readFile(store.path(identity, ".keys.json"), record); !ok {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (store *FileStore) SaveKeys(identity string, record *KeyRecord) error {
+	return writeFile(store.path(identity, ".keys.json"), record)
+}
+
+// identity 为身份公钥的十六进制编码，可以直接作为文件名
+func (store *FileStore) path(identity, suffix string) string {
+	return filepath.Join(store.Directory, identity+suffix)
+}
+
+// 读取并解析 JSON 文件，文件不存在时返回 false, nil
+func readFile(path string, value any) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 先写入临时文件再重命名，避免写入中断导致记录损坏
+func writeFile(path string, value any) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
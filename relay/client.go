@@ -0,0 +1,229 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 中继服务的客户端，连接后使用身份密钥认证，之后可以在同一连接上收发消息
+type Client struct {
+	mutex         sync.Mutex
+	netConnect    net.Conn
+	reader        *bufio.Reader
+	writer        *bufio.Writer
+	IdentityKey   *utils.DiffeHellmanKeyPair
+	ServerAddress string
+	Timeout       time.Duration
+	// 以下字段仅在中继之间转发消息时使用
+	Relay    string // 自身作为中继的地址
+	RelayKey []byte // 对方中继的身份公钥，用于验证对方的签名
+}
+
+func NewClient(serverAddress string, identityKey *utils.DiffeHellmanKeyPair) *Client {
+	return &Client{
+		IdentityKey:   identityKey,
+		ServerAddress: serverAddress,
+		Timeout:       10 * time.Second,
+	}
+}
+
+// 连接中继服务并签名服务端下发的挑战
+func (client *Client) Connect() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	connect, err := net.DialTimeout("tcp", client.ServerAddress, client.Timeout)
+	if err != nil {
+		return err
+	}
+	client.netConnect = connect
+	client.reader = bufio.NewReader(connect)
+	client.writer = bufio.NewWriter(connect)
+
+	connect.SetDeadline(time.Now().Add(client.Timeout))
+	challenge, err := readMessage(client.reader)
+	if err == nil && challenge.Type != ChallengeType {
+		err = ErrInvalidRequest
+	}
+	if err == nil {
+		err = client.authenticate(challenge.Nonce)
+	}
+	if err != nil {
+		connect.Close()
+		client.netConnect = nil
+		return err
+	}
+	return nil
+}
+
+func (client *Client) Close() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.netConnect != nil {
+		client.netConnect.Close()
+		client.netConnect = nil
+	}
+}
+
+// 将密文存入收件人的邮箱，address 为 user@relayhost 格式，收件人在其他中继时需要使用 SendSealed；
+// ttl 为 0 时使用服务端的默认保存时间
+func (client *Client) Send(address string, payload []byte, ttl time.Duration) error {
+	recipient, destination, err := ParseAddress(address)
+	if err != nil {
+		return err
+	}
+	_, err = client.request(&Message{
+		Type:        SendType,
+		Recipient:   recipient,
+		Destination: destination,
+		Payload:     payload,
+		TTL:         int64(ttl / time.Second),
+	})
+	return err
+}
+
+// 读取邮箱中的消息，处理完成后需要调用 Ack 删除
+func (client *Client) Fetch() ([]*Envelope, error) {
+	response, err := client.request(&Message{Type: FetchType})
+	if err != nil {
+		return nil, err
+	}
+	return response.Envelopes, nil
+}
+
+// 在中继登记投递令牌，持有令牌的联系人可以匿名向自己投递消息
+func (client *Client) RegisterDeliveryToken(token []byte) error {
+	_, err := client.request(&Message{Type: TokenType, Token: token})
+	return err
+}
+
+// 将内容封装为密封发件人信封，通过新的未认证连接匿名投递，
+// 中继无法将该消息与已认证的连接关联
+func (client *Client) SendSealed(address string, token, payload []byte, ttl time.Duration) error {
+	recipient, destination, err := ParseAddress(address)
+	if err != nil {
+		return err
+	}
+	sealed, err := SealSender(client.IdentityKey, recipient, payload)
+	if err != nil {
+		return err
+	}
+
+	anonymous := &Client{ServerAddress: client.ServerAddress, Timeout: client.Timeout}
+	connect, err := net.DialTimeout("tcp", anonymous.ServerAddress, anonymous.Timeout)
+	if err != nil {
+		return err
+	}
+	defer connect.Close()
+	anonymous.netConnect = connect
+	anonymous.reader = bufio.NewReader(connect)
+	anonymous.writer = bufio.NewWriter(connect)
+
+	connect.SetDeadline(time.Now().Add(anonymous.Timeout))
+	if _, err := readMessage(anonymous.reader); err != nil {
+		return err
+	}
+	_, err = anonymous.request(&Message{
+		Type:        SealedType,
+		Recipient:   recipient,
+		Destination: destination,
+		Payload:     sealed,
+		Token:       token,
+		TTL:         int64(ttl / time.Second),
+	})
+	return err
+}
+
+// 返回消息的发送方身份公钥与内容，密封发件人信封使用自己的身份密钥解开
+func (client *Client) OpenEnvelope(envelope *Envelope) ([]byte, []byte, error) {
+	if !envelope.Sealed {
+		return envelope.Sender, envelope.Payload, nil
+	}
+	return OpenSealedSender(client.IdentityKey, envelope.Payload)
+}
+
+func (client *Client) Ack(ids ...string) error {
+	_, err := client.request(&Message{Type: AckType, IDs: ids})
+	return err
+}
+
+// 转发消息给对方中继，返回对方已处理的消息 ID
+func (client *Client) forward(envelopes []*Envelope) ([]string, error) {
+	response, err := client.request(&Message{Type: ForwardType, Envelopes: envelopes})
+	if err != nil {
+		return nil, err
+	}
+	return response.IDs, nil
+}
+
+func (client *Client) authenticate(nonce []byte) error {
+	signature, err := client.IdentityKey.Sign(authPayload(nonce))
+	if err != nil {
+		return err
+	}
+	request := &Message{
+		Type:        AuthType,
+		IdentityKey: client.IdentityKey.PublicKey.Bytes(),
+		Signature:   signature,
+		Relay:       client.Relay,
+	}
+	if client.Relay == "" {
+		return client.roundTrip(request, nil)
+	}
+
+	// 中继链路需要确认对方就是配置的中继
+	request.Nonce = make([]byte, 32)
+	rand.Read(request.Nonce)
+	response := &Message{}
+	if err := client.roundTrip(request, response); err != nil {
+		return err
+	}
+	if !bytes.Equal(response.IdentityKey, client.RelayKey) ||
+		!utils.XEdDSAVerify(client.RelayKey, linkPayload(request.Nonce), response.Signature) {
+		return ErrRelayLink
+	}
+	return nil
+}
+
+func (client *Client) request(request *Message) (*Message, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.netConnect == nil {
+		return nil, net.ErrClosed
+	}
+	response := &Message{}
+	if err := client.roundTrip(request, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// 发送请求并读取回复，调用方需持有 client.mutex
+func (client *Client) roundTrip(request *Message, response *Message) error {
+	client.netConnect.SetDeadline(time.Now().Add(client.Timeout))
+	defer client.netConnect.SetDeadline(time.Time{})
+
+	if err := writeMessage(client.writer, request); err != nil {
+		return err
+	}
+	result, err := readMessage(client.reader)
+	if err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	if response != nil {
+		*response = *result
+	}
+	return nil
+}
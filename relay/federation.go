@@ -0,0 +1,281 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	forwardRetryMin = 1 * time.Second  // 转发失败后的首次重试间隔
+	forwardRetryMax = 1 * time.Minute  // 重试间隔翻倍的上限
+	forwardBatch    = 64               // 每次转发的最大消息数量
+	seenTTL         = 24 * time.Hour   // 记录已处理消息摘要的时间，用于去重
+	forwardInterval = 30 * time.Second // 没有新消息时检查发件箱的间隔
+)
+
+var ErrAddress = errors.New("地址格式应为 身份公钥@中继地址")
+var ErrUnknownRelay = errors.New("没有通往目的中继的路由")
+var ErrRelayLink = errors.New("中继链路认证失败")
+var ErrSealedRequired = errors.New("发往其他中继的消息必须使用密封发件人")
+
+// 通往另一个中继的链路，发件箱中的消息由独立的协程转发
+type relayPeer struct {
+	host        string
+	identityKey []byte
+	wakeChan    chan bool
+}
+
+// 解析 user@relayhost 格式的地址，user 为收件人身份公钥的十六进制编码，
+// 没有 @ 时表示收件人在当前连接的中继上
+func ParseAddress(address string) ([]byte, string, error) {
+	user, host, _ := strings.Cut(address, "@")
+	identity, err := hex.DecodeString(user)
+	if err != nil {
+		return nil, "", ErrAddress
+	}
+	if _, err := utils.BytesToPublicKey(identity); err != nil {
+		return nil, "", ErrAddress
+	}
+	return identity, host, nil
+}
+
+func FormatAddress(identity []byte, host string) string {
+	if host == "" {
+		return hex.EncodeToString(identity)
+	}
+	return hex.EncodeToString(identity) + "@" + host
+}
+
+// 中继链路认证时由被连接方签名的内容，与客户端认证使用不同的前缀
+func linkPayload(nonce []byte) []byte {
+	return append([]byte("DoubleRatchetDemo relay link"), nonce...)
+}
+
+// 发往某个中继的消息保存在对应的发件箱中，与邮箱一同参与过期清理
+func outboxMailbox(host string) string {
+	return "outbox-" + hex.EncodeToString([]byte(host))
+}
+
+// 添加可以互相转发消息的中继，identityKey 为对方的身份公钥，需要在启动服务前调用
+func (server *Server) AddPeer(host string, identityKey []byte) error {
+	if _, err := utils.BytesToPublicKey(identityKey); err != nil {
+		return err
+	}
+	server.peers[host] = &relayPeer{
+		host:        host,
+		identityKey: identityKey,
+		wakeChan:    make(chan bool, 1),
+	}
+	return nil
+}
+
+// 目的中继是直连的中继时直接转发，否则交给上游中继
+func (server *Server) route(destination string) (*relayPeer, error) {
+	if server.Host == "" {
+		return nil, ErrUnknownRelay
+	}
+	if peer, ok := server.peers[destination]; ok {
+		return peer, nil
+	}
+	if peer, ok := server.peers[server.Upstream]; ok {
+		return peer, nil
+	}
+	return nil, ErrUnknownRelay
+}
+
+// 收件人在本中继时存入邮箱，否则放入下一跳的发件箱
+func (server *Server) deposit(envelope *Envelope) error {
+	if envelope.Destination == "" || envelope.Destination == server.Host {
+		envelope.Destination = ""
+		envelope.Token = nil
+		return server.appendEnvelope(hex.EncodeToString(envelope.Recipient), envelope)
+	}
+
+	peer, err := server.route(envelope.Destination)
+	if err != nil {
+		return err
+	}
+	envelope.Via = append(envelope.Via, server.Host)
+	if err := server.appendEnvelope(outboxMailbox(peer.host), envelope); err != nil {
+		return err
+	}
+	select {
+	case peer.wakeChan <- true:
+	default:
+	}
+	return nil
+}
+
+// 处理其他中继转发来的消息，返回已处理完毕、对方可以从发件箱删除的消息 ID；
+// 配额已满等暂时性错误不返回 ID，由对方稍后重试
+func (server *Server) receive(linkRelay string, envelopes []*Envelope) []string {
+	done := []string{}
+	now := time.Now().UnixNano()
+	for _, envelope := range envelopes {
+		// 经过过本中继或超过跳数限制的消息说明存在转发环路，直接丢弃
+		if slices.Contains(envelope.Via, server.Host) || len(envelope.Via) >= server.MaxHops {
+			log.Printf("⚠️ 丢弃来自 %s 的环路消息: %s\n", linkRelay, envelope.ID)
+			done = append(done, envelope.ID)
+			continue
+		}
+		digest := envelopeDigest(envelope)
+		if server.markSeen(digest, now) {
+			done = append(done, envelope.ID)
+			continue
+		}
+
+		err := ErrInvalidRequest
+		if _, keyErr := utils.BytesToPublicKey(envelope.Recipient); keyErr == nil && envelope.ExpiresAt > now {
+			err = nil
+		}
+		// 转发来的 Sender 只是对方中继的声明，本中继无法验证，因此只接受密封发件人信封
+		if err == nil && !envelope.Sealed {
+			err = ErrSealedRequired
+		}
+		if err == nil && envelope.Sealed && (envelope.Destination == "" || envelope.Destination == server.Host) {
+			err = server.checkToken(envelope.Recipient, envelope.Token)
+		}
+		if err == nil {
+			envelope.ExpiresAt = min(envelope.ExpiresAt, now+int64(server.MaxTTL))
+			err = server.deposit(envelope)
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			server.unmarkSeen(digest)
+			continue
+		}
+		if err != nil {
+			log.Printf("⚠️ 丢弃来自 %s 的消息 %s: %s\n", linkRelay, envelope.ID, err.Error())
+		}
+		done = append(done, envelope.ID)
+	}
+	return done
+}
+
+// 去重使用消息内容的摘要而不是对方选择的 ID，对方中继换用新的 ID 也无法重放同一条消息；
+// Via 与 ExpiresAt 在每一跳都会变化，不计入摘要
+func envelopeDigest(envelope *Envelope) string {
+	digest := sha256.New()
+	for _, field := range [][]byte{envelope.Sender, envelope.Recipient, envelope.Payload, envelope.Token, []byte(envelope.Destination)} {
+		digest.Write(binary.BigEndian.AppendUint32(nil, uint32(len(field))))
+		digest.Write(field)
+	}
+	if envelope.Sealed {
+		digest.Write([]byte{1})
+	} else {
+		digest.Write([]byte{0})
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// 记录已处理的消息摘要，返回该摘要之前是否已经出现过
+func (server *Server) markSeen(digest string, now int64) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if _, ok := server.seen[digest]; ok {
+		return true
+	}
+	server.seen[digest] = now + int64(seenTTL)
+	return false
+}
+
+func (server *Server) unmarkSeen(digest string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	delete(server.seen, digest)
+}
+
+func (server *Server) expireSeen(now int64) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for id, expiresAt := range server.seen {
+		if expiresAt <= now {
+			delete(server.seen, id)
+		}
+	}
+}
+
+// 持续将发件箱中的消息转发给对方中继，失败时按指数退避重试
+func (server *Server) handleForward(peer *relayPeer) {
+	defer server.waitGroup.Done()
+
+	retry := forwardRetryMin
+	for {
+		wait := forwardInterval
+		if err := server.flush(peer); err != nil {
+			log.Printf("⚠️ 向中继 %s 转发消息失败，%s 后重试: %s\n", peer.host, retry, err.Error())
+			wait = retry
+			retry = min(retry*2, forwardRetryMax)
+		} else {
+			retry = forwardRetryMin
+		}
+
+		select {
+		case <-server.stopChan:
+			return
+		case <-peer.wakeChan:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// 将发件箱中未过期的消息分批转发，对方确认后从发件箱删除
+func (server *Server) flush(peer *relayPeer) error {
+	mailbox := outboxMailbox(peer.host)
+	envelopes, err := server.Store.List(mailbox)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	envelopes = removeEnvelopes(envelopes, func(envelope *Envelope) bool {
+		return envelope.ExpiresAt <= now
+	})
+	if len(envelopes) == 0 {
+		return nil
+	}
+
+	client := NewClient(peer.host, server.IdentityKey)
+	client.Relay = server.Host
+	client.RelayKey = peer.identityKey
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for len(envelopes) > 0 {
+		batch := envelopes[:min(len(envelopes), forwardBatch)]
+		envelopes = envelopes[len(batch):]
+
+		ids, err := client.forward(batch)
+		if err != nil {
+			return err
+		}
+		if err := server.Store.Delete(mailbox, ids); err != nil {
+			return err
+		}
+		if len(ids) != len(batch) {
+			return ErrQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// 校验中继链路的身份公钥与配置的一致
+func (server *Server) checkLink(host string, identityKey []byte) error {
+	peer, ok := server.peers[host]
+	if !ok || !bytes.Equal(peer.identityKey, identityKey) {
+		return ErrRelayLink
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package relay
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 预先取得空闲端口，中继地址需要在启动前配置给对方
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// 启动两个互相转发的中继
+func startFederation(t *testing.T) (*Server, *Server) {
+	t.Helper()
+	servers := []*Server{}
+	for range 2 {
+		address := freeAddress(t)
+		server := NewServer(address, NewMemoryStore())
+		server.Host = address
+		server.IdentityKey = utils.NewDiffeHellmanKeyPair()
+		servers = append(servers, server)
+	}
+	left, right := servers[0], servers[1]
+	if err := left.AddPeer(right.Host, right.IdentityKey.PublicKey.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := right.AddPeer(left.Host, left.IdentityKey.PublicKey.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	startTestServer(t, left)
+	startTestServer(t, right)
+	return left, right
+}
+
+func TestFederationForward(t *testing.T) {
+	left, right := startFederation(t)
+	aliceKey, bobKey := utils.NewDiffeHellmanKeyPair(), utils.NewDiffeHellmanKeyPair()
+	alice := connectClient(t, left.Host, aliceKey)
+	bob := connectClient(t, right.Host, bobKey)
+
+	token := NewDeliveryToken()
+	if err := bob.RegisterDeliveryToken(token); err != nil {
+		t.Fatal(err)
+	}
+	bobAddress := FormatAddress(bobKey.PublicKey.Bytes(), right.Host)
+	// 目的中继无法验证本中继声明的发件人，跨中继只能发送密封发件人信封
+	if err := alice.Send(bobAddress, []byte("hello"), 0); err == nil || err.Error() != ErrSealedRequired.Error() {
+		t.Fatalf("expected a sealed sender error, got %v", err)
+	}
+	if err := alice.SendSealed(bobAddress, token, []byte("sealed"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// 发件箱由转发协程立即处理，等待消息到达 bob 的邮箱
+	var envelopes []*Envelope
+	for deadline := time.Now().Add(5 * time.Second); len(envelopes) < 1 && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+		var err error
+		if envelopes, err = bob.Fetch(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(envelopes) != 1 {
+		t.Fatalf("expected one forwarded envelope, got %d", len(envelopes))
+	}
+	sender, payload, err := bob.OpenEnvelope(envelopes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(payload) != "sealed" || !bytes.Equal(sender, aliceKey.PublicKey.Bytes()) {
+		t.Fatalf("expected the sealed envelope from alice, got %q", payload)
+	}
+	if outbox, err := left.Store.List(outboxMailbox(right.Host)); err != nil || len(outbox) != 0 {
+		t.Fatalf("the outbox was not emptied: %d, %v", len(outbox), err)
+	}
+}
+
+func TestFederationDeduplicate(t *testing.T) {
+	left, right := startFederation(t)
+	bobKey := utils.NewDiffeHellmanKeyPair()
+	bob := connectClient(t, right.Host, bobKey)
+	token := NewDeliveryToken()
+	if err := bob.RegisterDeliveryToken(token); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UnixNano()
+	envelope := &Envelope{
+		ID:        "first",
+		Recipient: bobKey.PublicKey.Bytes(),
+		Payload:   []byte("hello"),
+		Sealed:    true,
+		Timestamp: now,
+		ExpiresAt: now + int64(time.Hour),
+		Via:       []string{left.Host},
+		Token:     token,
+	}
+	replayed := *envelope
+	replayed.ID = "second"
+	replayed.Via = []string{"somewhere"}
+	replayed.ExpiresAt += int64(time.Minute)
+	other := *envelope
+	other.ID = "third"
+	other.Payload = []byte("other")
+	// 对方中继声明的发件人无法验证，非密封的信封被丢弃
+	asserted := *envelope
+	asserted.ID = "fourth"
+	asserted.Sender = left.IdentityKey.PublicKey.Bytes()
+	asserted.Sealed = false
+	asserted.Token = nil
+
+	// 换用新 ID 的重放消息被丢弃，但仍然确认给对方，避免对方无限重试
+	link := NewClient(right.Host, left.IdentityKey)
+	link.Relay = left.Host
+	link.RelayKey = right.IdentityKey.PublicKey.Bytes()
+	if err := link.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer link.Close()
+	ids, err := link.forward([]*Envelope{envelope, &replayed, &other, &asserted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 4 {
+		t.Fatalf("expected all envelopes to be acknowledged, got %v", ids)
+	}
+
+	envelopes, err := bob.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envelopes) != 2 {
+		t.Fatalf("expected the replay and the unsealed envelope to be dropped, got %d envelopes", len(envelopes))
+	}
+}
+
+func TestFederationRejectsUnknownRelay(t *testing.T) {
+	_, right := startFederation(t)
+
+	link := NewClient(right.Host, utils.NewDiffeHellmanKeyPair())
+	link.Relay = freeAddress(t)
+	link.RelayKey = right.IdentityKey.PublicKey.Bytes()
+	if err := link.Connect(); err == nil || err.Error() != ErrRelayLink.Error() {
+		t.Fatalf("expected a relay link error, got %v", err)
+	}
+}
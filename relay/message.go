@@ -0,0 +1,88 @@
+package relay
+
+import (
+	"bufio"
+	"encoding/json"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 客户端接收的单条信息的最大长度
+const maxMessageSize = 64 << 20
+
+const (
+	ChallengeType = "challenge" // 服务端下发的随机挑战
+	AuthType      = "auth"      // 客户端使用身份密钥签名挑战
+	SendType      = "send"      // 将密文存入收件人的邮箱
+	FetchType     = "fetch"     // 读取自己邮箱中的消息
+	AckType       = "ack"       // 确认收到，服务端删除对应的消息
+	TokenType     = "token"     // 登记自己邮箱的投递令牌
+	SealedType    = "sealed"    // 匿名投递密封发件人信封，需要收件人的投递令牌
+	ForwardType   = "forward"   // 中继之间转发发往其他中继的消息
+	CircuitType   = "circuit"   // 建立洋葱线路，之后连接只传输洋葱单元
+	ResultType    = "result"    // 服务端对请求的回复
+)
+
+// 邮箱中保存的一条消息，Payload 为发送方加密后的棘轮消息，中继无法解密
+type Envelope struct {
+	ID        string
+	Sender    []byte
+	Recipient []byte
+	Payload   []byte
+	Sealed    bool  // Payload 是否为密封发件人信封，此时 Sender 为空
+	Timestamp int64 // 存入邮箱的时间
+	ExpiresAt int64 // 超过该时间后消息被丢弃
+	// 以下字段仅在中继之间转发时使用
+	Destination string   `json:",omitempty"` // 收件人所在的中继
+	Via         []string `json:",omitempty"` // 已经过的中继，用于防止转发环路
+	Token       []byte   `json:",omitempty"` // 密封发件人信封的投递令牌，由目的中继校验
+}
+
+// 客户端与中继服务之间交换的信息
+type Message struct {
+	Type        string
+	Nonce       []byte
+	IdentityKey []byte
+	Signature   []byte
+	Relay       string // 中继之间认证时声明的自身地址
+	Recipient   []byte
+	Destination string // 收件人所在的中继，为空时表示本中继
+	Payload     []byte
+	Token       []byte // 收件人的投递令牌
+	TTL         int64  // 消息的保存时间 (秒)，为 0 时使用服务端默认值
+	Envelopes   []*Envelope
+	IDs         []string
+	Error       string
+}
+
+// 认证时签名的内容，绑定本协议避免签名被挪作他用
+func authPayload(nonce []byte) []byte {
+	return append([]byte("DoubleRatchetDemo relay auth"), nonce...)
+}
+
+func writeMessage(writer *bufio.Writer, message *Message) error {
+	messageBytes, _ := json.Marshal(message)
+	messageBytes, _ = utils.EncodeMessage(messageBytes)
+	if _, err := writer.Write(messageBytes); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// 读取中继的回复，长度上限足以容纳默认配额下整个邮箱的消息
+func readMessage(reader *bufio.Reader) (*Message, error) {
+	return readMessageLimit(reader, maxMessageSize)
+}
+
+// 读取信息，声明的长度超过 maxLength 时在分配内存前返回错误
+func readMessageLimit(reader *bufio.Reader, maxLength int) (*Message, error) {
+	messageBytes, err := utils.DecodeMessageLimit(reader, maxLength)
+	if err != nil {
+		return nil, err
+	}
+	message := &Message{}
+	if err := json.Unmarshal(messageBytes, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
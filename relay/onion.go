@@ -0,0 +1,471 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	MinOnionHops   = 2
+	MaxOnionHops   = 3
+	onionCellSize  = 1 << 10 // 单个洋葱单元携带的最大字节数
+	onionTagSize   = 16      // 每一层加密增加的认证标签长度
+	onionRouteSize = 512     // 每一跳的路由信息填充后的长度
+
+	// 线路上的单元与建立请求都是固定长度，每一跳解开一层后使用随机字节重新填充，
+	// 观察者无法根据长度判断单元所处的位置
+	onionWireSize    = 2 + onionCellSize + onionTagSize*MaxOnionHops
+	onionBlockSize   = 32 + onionRouteSize + onionTagSize
+	onionRequestSize = onionBlockSize * MaxOnionHops
+
+	circuitDialTimeout = 10 * time.Second // 中继连接下一跳的超时时间
+)
+
+// 出口中继连接目标后返回的第一个单元
+var onionConnected = []byte("CONNECTED")
+
+var ErrOnionPath = errors.New("洋葱线路需要经过 2 到 3 个中继")
+var ErrOnionLayer = errors.New("洋葱单元解密失败")
+var ErrCircuit = errors.New("建立洋葱线路失败")
+var ErrCircuitTarget = errors.New("洋葱线路的下一跳不在允许的地址中")
+
+// 洋葱线路上的一个中继，IdentityKey 为中继的身份公钥
+type OnionHop struct {
+	Address     string
+	IdentityKey []byte
+}
+
+// 每一跳解密后得到的路由信息
+type onionRoute struct {
+	Next     string
+	Exit     bool // 下一跳是最终目标而不是中继
+	CellSize int  // 本跳解开的单元密文长度，其余部分为填充
+}
+
+// 发起方与一个中继共享的一层加密，两个方向使用不同的密钥与递增的 nonce
+type onionLayer struct {
+	forward       cipher.AEAD
+	backward      cipher.AEAD
+	forwardNonce  uint64
+	backwardNonce uint64
+	cellSize      int
+}
+
+// 经过洋葱线路的连接，每次写入作为一个单元逐层加密，读取时逐层解密
+type onionConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+	layers     []*onionLayer
+	readMutex  sync.Mutex
+	writeMutex sync.Mutex
+	readBuffer []byte
+}
+
+// 使用临时密钥与中继身份公钥的 DH 结果派生该跳的建立密钥、两个方向的单元密钥，
+// 以及加密建立请求中后续各跳的流密钥
+func newOnionLayer(sharedSecret, ephemeral, identity []byte) (*onionLayer, cipher.AEAD, cipher.Stream, error) {
+	chainKey, setupKey := utils.DevirateChainKey(sharedSecret, sealedSalt(ephemeral, identity))
+	forwardKey, backwardKey := utils.DevirateChainKey(chainKey, []byte("DoubleRatchetDemo onion"))
+	requestKey, _ := utils.DevirateChainKey(chainKey, []byte("DoubleRatchetDemo onion request"))
+
+	setup, err := newOnionAEAD(setupKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	forward, err := newOnionAEAD(forwardKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	backward, err := newOnionAEAD(backwardKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	block, err := aes.NewCipher(requestKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stream := cipher.NewCTR(block, make([]byte, aes.BlockSize))
+	return &onionLayer{forward: forward, backward: backward}, setup, stream, nil
+}
+
+func newOnionAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce 为 32 位的 0 加上 64 位大端序计数，单元被重放或重排时解密失败
+func onionNonce(counter *uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], *counter)
+	*counter++
+	return nonce
+}
+
+// 解开 layer 层数的单元密文长度，出口中继为 1 层
+func onionCellLength(layers int) int {
+	return 2 + onionCellSize + onionTagSize*layers
+}
+
+// 单元内容为 2 字节长度加上数据，并补零到固定长度
+func packCell(data []byte) []byte {
+	cell := make([]byte, onionCellLength(0))
+	binary.BigEndian.PutUint16(cell, uint16(len(data)))
+	copy(cell[2:], data)
+	return cell
+}
+
+func unpackCell(cell []byte) ([]byte, error) {
+	length := int(binary.BigEndian.Uint16(cell))
+	if length > len(cell)-2 {
+		return nil, ErrOnionLayer
+	}
+	return cell[2 : 2+length], nil
+}
+
+// 使用随机字节将单元填充到线路上传输的固定长度
+func padCell(cell []byte, size int) []byte {
+	padded := make([]byte, size)
+	copy(padded, cell)
+	rand.Read(padded[len(cell):])
+	return padded
+}
+
+func (layer *onionLayer) sealForward(cell []byte) []byte {
+	return layer.forward.Seal(nil, onionNonce(&layer.forwardNonce), cell, nil)
+}
+
+func (layer *onionLayer) openForward(cell []byte) ([]byte, error) {
+	plaintext, err := layer.forward.Open(nil, onionNonce(&layer.forwardNonce), cell, nil)
+	if err != nil {
+		return nil, ErrOnionLayer
+	}
+	return plaintext, nil
+}
+
+func (layer *onionLayer) sealBackward(cell []byte) []byte {
+	return layer.backward.Seal(nil, onionNonce(&layer.backwardNonce), cell, nil)
+}
+
+func (layer *onionLayer) openBackward(cell []byte) ([]byte, error) {
+	plaintext, err := layer.backward.Open(nil, onionNonce(&layer.backwardNonce), cell, nil)
+	if err != nil {
+		return nil, ErrOnionLayer
+	}
+	return plaintext, nil
+}
+
+// 从最后一跳开始逐层构造建立请求，每一跳使用独立的临时 X25519 密钥，
+// 中继只能解开属于自己的一层，得知上一跳与下一跳而不知道完整路径；
+// 请求由每一跳的固定长度块组成，后续各跳的块使用本跳的流密钥加密，
+// 同一个块在不同链路上的内容各不相同
+func buildCircuit(path []OnionHop, destination string) ([]byte, []*onionLayer, error) {
+	layers := make([]*onionLayer, len(path))
+	request := make([]byte, onionRequestSize)
+	rand.Read(request)
+	for i := len(path) - 1; i >= 0; i-- {
+		identityKey, err := utils.BytesToPublicKey(path[i].IdentityKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		ephemeralKey := utils.NewDiffeHellmanKeyPair()
+		sharedSecret, err := ephemeralKey.PrivateKey.ECDH(identityKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		layer, setup, stream, err := newOnionLayer(sharedSecret, ephemeralKey.PublicKey.Bytes(), path[i].IdentityKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		layer.cellSize = onionCellLength(len(path) - i)
+		layers[i] = layer
+
+		route := &onionRoute{Next: destination, Exit: true, CellSize: layer.cellSize}
+		if i+1 < len(path) {
+			route = &onionRoute{Next: path[i+1].Address, CellSize: layer.cellSize}
+		}
+		routeBytes, _ := json.Marshal(route)
+		if len(routeBytes) > onionRouteSize {
+			return nil, nil, ErrCircuit
+		}
+		// 使用空格填充，不影响 JSON 解析
+		routeBytes = append(routeBytes, bytes.Repeat([]byte(" "), onionRouteSize-len(routeBytes))...)
+
+		rest := request[:onionRequestSize-onionBlockSize]
+		stream.XORKeyStream(rest, rest)
+		block := append(ephemeralKey.PublicKey.Bytes(), setup.Seal(nil, onionNonce(new(uint64)), routeBytes, nil)...)
+		request = append(block, rest...)
+	}
+	return request, layers, nil
+}
+
+// 解开建立请求中属于本中继的块，返回交给下一跳的请求：
+// 解密后续各跳的块并在末尾补上随机块，使请求长度保持不变
+func openCircuit(identityKey *utils.DiffeHellmanKeyPair, request []byte) (*onionRoute, *onionLayer, []byte, error) {
+	if len(request) != onionRequestSize {
+		return nil, nil, nil, ErrCircuit
+	}
+	ephemeralKey, err := utils.BytesToPublicKey(request[:32])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sharedSecret, err := identityKey.PrivateKey.ECDH(ephemeralKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	layer, setup, stream, err := newOnionLayer(sharedSecret, request[:32], identityKey.PublicKey.Bytes())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	routeBytes, err := setup.Open(nil, onionNonce(new(uint64)), request[32:onionBlockSize], nil)
+	if err != nil {
+		return nil, nil, nil, ErrOnionLayer
+	}
+	route := &onionRoute{}
+	if err := json.Unmarshal(routeBytes, route); err != nil {
+		return nil, nil, nil, ErrCircuit
+	}
+	// 出口中继只解开最后一层，其他中继的单元至少还有一层
+	layers := (route.CellSize - onionCellLength(0)) / onionTagSize
+	if route.CellSize != onionCellLength(layers) || layers < 1 || layers > MaxOnionHops || route.Exit != (layers == 1) {
+		return nil, nil, nil, ErrCircuit
+	}
+	layer.cellSize = route.CellSize
+
+	next := padCell(request[onionBlockSize:], onionRequestSize)
+	stream.XORKeyStream(next[:onionRequestSize-onionBlockSize], next[:onionRequestSize-onionBlockSize])
+	return route, layer, next, nil
+}
+
+// 通过 2 到 3 个中继建立到 destination 的洋葱线路，返回的连接在出口中继连接目标后可用
+func DialCircuit(path []OnionHop, destination string, timeout time.Duration) (net.Conn, error) {
+	if len(path) < MinOnionHops || len(path) > MaxOnionHops {
+		return nil, ErrOnionPath
+	}
+	request, layers, err := buildCircuit(path, destination)
+	if err != nil {
+		return nil, err
+	}
+
+	connect, err := net.DialTimeout("tcp", path[0].Address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &onionConn{
+		Conn:   connect,
+		reader: bufio.NewReader(connect),
+		writer: bufio.NewWriter(connect),
+		layers: layers,
+	}
+	connect.SetDeadline(time.Now().Add(timeout))
+	if err := extendCircuit(conn.reader, conn.writer, request); err != nil {
+		connect.Close()
+		return nil, err
+	}
+	cell, err := conn.readCell()
+	if err != nil || !bytes.Equal(cell, onionConnected) {
+		connect.Close()
+		return nil, ErrCircuit
+	}
+	connect.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// 读取中继下发的挑战后提交建立请求，之后连接只传输洋葱单元
+func extendCircuit(reader *bufio.Reader, writer *bufio.Writer, request []byte) error {
+	challenge, err := readMessage(reader)
+	if err != nil {
+		return err
+	}
+	if challenge.Type != ChallengeType {
+		return ErrCircuit
+	}
+	return writeMessage(writer, &Message{Type: CircuitType, Payload: request})
+}
+
+// 读取一个单元并按从近到远的顺序解开每一层
+func (conn *onionConn) readCell() ([]byte, error) {
+	cell, err := readCell(conn.reader)
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range conn.layers {
+		if cell, err = layer.openBackward(cell[:layer.cellSize]); err != nil {
+			return nil, err
+		}
+	}
+	return unpackCell(cell)
+}
+
+func (conn *onionConn) Read(b []byte) (int, error) {
+	conn.readMutex.Lock()
+	defer conn.readMutex.Unlock()
+
+	for len(conn.readBuffer) == 0 {
+		cell, err := conn.readCell()
+		if err != nil {
+			return 0, err
+		}
+		conn.readBuffer = cell
+	}
+	n := copy(b, conn.readBuffer)
+	conn.readBuffer = conn.readBuffer[n:]
+	return n, nil
+}
+
+// 按从远到近的顺序逐层加密，第一跳的加密在最外层
+func (conn *onionConn) Write(b []byte) (int, error) {
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	for offset := 0; offset < len(b); offset += onionCellSize {
+		cell := packCell(b[offset:min(offset+onionCellSize, len(b))])
+		for i := len(conn.layers) - 1; i >= 0; i-- {
+			cell = conn.layers[i].sealForward(cell)
+		}
+		if err := writeCell(conn.writer, padCell(cell, onionWireSize)); err != nil {
+			return offset, err
+		}
+	}
+	return len(b), nil
+}
+
+// 读取一个固定长度的单元
+func readCell(reader *bufio.Reader) ([]byte, error) {
+	cell, err := utils.DecodeMessageLimit(reader, onionWireSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(cell) != onionWireSize {
+		return nil, ErrOnionLayer
+	}
+	return cell, nil
+}
+
+func writeCell(writer *bufio.Writer, cell []byte) error {
+	frame, err := utils.EncodeMessage(cell)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(frame); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// 下一跳只能是配置的中继或允许的目标，避免中继被用来连接内网等任意地址
+func (server *Server) checkCircuitTarget(address string) error {
+	if _, ok := server.peers[address]; ok {
+		return nil
+	}
+	if slices.Contains(server.CircuitTargets, address) {
+		return nil
+	}
+	return ErrCircuitTarget
+}
+
+// 解开本中继的一层后连接下一跳，之后在两个方向上转发单元：
+// 向前的单元解开一层，向后的单元加上一层，都重新填充到固定长度；
+// 出口中继与目标之间直接传输字节流
+func (server *Server) handleCircuit(connect net.Conn, reader *bufio.Reader, writer *bufio.Writer, request []byte) error {
+	route, layer, inner, err := openCircuit(server.IdentityKey, request)
+	if err != nil {
+		return err
+	}
+	if err := server.checkCircuitTarget(route.Next); err != nil {
+		return err
+	}
+
+	next, err := net.DialTimeout("tcp", route.Next, circuitDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer next.Close()
+	nextReader := bufio.NewReader(next)
+	nextWriter := bufio.NewWriter(next)
+	if route.Exit {
+		if err := writeCell(writer, padCell(layer.sealBackward(packCell(onionConnected)), onionWireSize)); err != nil {
+			return err
+		}
+	} else {
+		next.SetDeadline(time.Now().Add(circuitDialTimeout))
+		if err := extendCircuit(nextReader, nextWriter, inner); err != nil {
+			return err
+		}
+		next.SetDeadline(time.Time{})
+	}
+
+	// 线路建立后连接可以长时间保持，取消认证前读取请求的超时
+	connect.SetDeadline(time.Time{})
+
+	// 任意一个方向结束时关闭两端的连接，另一个方向随之结束
+	done := make(chan bool, 2)
+	go func() {
+		defer func() { done <- true }()
+		for {
+			cell, err := readCell(reader)
+			if err != nil {
+				return
+			}
+			if cell, err = layer.openForward(cell[:layer.cellSize]); err != nil {
+				return
+			}
+			if route.Exit {
+				var data []byte
+				if data, err = unpackCell(cell); err == nil {
+					_, err = next.Write(data)
+				}
+			} else {
+				err = writeCell(nextWriter, padCell(cell, onionWireSize))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer func() { done <- true }()
+		buffer := make([]byte, onionCellSize)
+		for {
+			if route.Exit {
+				n, err := next.Read(buffer)
+				if n > 0 && writeCell(writer, padCell(layer.sealBackward(packCell(buffer[:n])), onionWireSize)) != nil {
+					return
+				}
+				if err != nil {
+					return
+				}
+				continue
+			}
+			cell, err := readCell(nextReader)
+			if err != nil {
+				return
+			}
+			cell = layer.sealBackward(cell[:layer.cellSize-onionTagSize])
+			if err := writeCell(writer, padCell(cell, onionWireSize)); err != nil {
+				return
+			}
+		}
+	}()
+
+	<-done
+	connect.Close()
+	next.Close()
+	<-done
+	return nil
+}
@@ -0,0 +1,176 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 把收到的数据原样发回的目标
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			connect, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer connect.Close()
+				io.Copy(connect, connect)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// 启动 count 个允许建立线路的中继，所有中继都允许连接 targets
+func startCircuitRelays(t *testing.T, count int, targets ...string) []OnionHop {
+	t.Helper()
+	servers := []*Server{}
+	path := []OnionHop{}
+	for range count {
+		server := NewServer("127.0.0.1:0", NewMemoryStore())
+		server.IdentityKey = utils.NewDiffeHellmanKeyPair()
+		server.AllowCircuits = true
+		address := startTestServer(t, server)
+		servers = append(servers, server)
+		path = append(path, OnionHop{Address: address, IdentityKey: server.IdentityKey.PublicKey.Bytes()})
+	}
+	for _, server := range servers {
+		server.CircuitTargets = append([]string{}, targets...)
+		for _, hop := range path {
+			server.CircuitTargets = append(server.CircuitTargets, hop.Address)
+		}
+	}
+	return path
+}
+
+func TestDialCircuit(t *testing.T) {
+	echoAddress := startEchoServer(t)
+	path := startCircuitRelays(t, MaxOnionHops, echoAddress)
+
+	for hops := MinOnionHops; hops <= MaxOnionHops; hops++ {
+		connect, err := DialCircuit(path[:hops], echoAddress, 2*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 超过一个单元的数据被拆分后按顺序到达
+		message := bytes.Repeat([]byte("onion"), onionCellSize)
+		if _, err := connect.Write(message); err != nil {
+			t.Fatal(err)
+		}
+		connect.SetReadDeadline(time.Now().Add(2 * time.Second))
+		received := make([]byte, len(message))
+		if _, err := io.ReadFull(connect, received); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received, message) {
+			t.Fatal("the echoed data does not match")
+		}
+		connect.Close()
+	}
+
+	if _, err := DialCircuit(path[:1], echoAddress, time.Second); err != ErrOnionPath {
+		t.Fatalf("expected a path error, got %v", err)
+	}
+}
+
+func TestCircuitRejectsUnknownTarget(t *testing.T) {
+	echoAddress := startEchoServer(t)
+	path := startCircuitRelays(t, MinOnionHops)
+
+	if connect, err := DialCircuit(path, echoAddress, 2*time.Second); err == nil {
+		connect.Close()
+		t.Fatal("the exit connected to a target that is not allowed")
+	}
+}
+
+// 线路上每一段链路的建立请求与单元长度都相同
+func TestCircuitFixedSize(t *testing.T) {
+	relayKeys := []*utils.DiffeHellmanKeyPair{}
+	path := []OnionHop{}
+	for i := range MaxOnionHops {
+		relayKeys = append(relayKeys, utils.NewDiffeHellmanKeyPair())
+		path = append(path, OnionHop{Address: string(rune('a' + i)), IdentityKey: relayKeys[i].PublicKey.Bytes()})
+	}
+	request, clientLayers, err := buildCircuit(path, "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relayLayers := []*onionLayer{}
+	for i, relayKey := range relayKeys {
+		if len(request) != onionRequestSize {
+			t.Fatalf("hop %d received a request of %d bytes", i, len(request))
+		}
+		route, layer, inner, err := openCircuit(relayKey, request)
+		if err != nil {
+			t.Fatalf("hop %d: %v", i, err)
+		}
+		if route.Exit != (i == len(path)-1) {
+			t.Fatalf("hop %d: unexpected route %+v", i, route)
+		}
+		if bytes.Contains(inner, request[onionBlockSize:2*onionBlockSize]) {
+			t.Fatalf("hop %d forwarded the next block unchanged", i)
+		}
+		relayLayers = append(relayLayers, layer)
+		request = inner
+	}
+
+	// 向前的单元每经过一跳解开一层并重新填充
+	conn := &onionConn{layers: clientLayers}
+	var wire bytes.Buffer
+	conn.writer = bufio.NewWriter(&wire)
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	cell, err := readCell(bufio.NewReader(&wire))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, layer := range relayLayers {
+		if len(cell) != onionWireSize {
+			t.Fatalf("hop %d received a cell of %d bytes", i, len(cell))
+		}
+		opened, err := layer.openForward(cell[:layer.cellSize])
+		if err != nil {
+			t.Fatalf("hop %d: %v", i, err)
+		}
+		cell = padCell(opened, onionWireSize)
+	}
+	data, err := unpackCell(cell)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+
+	// 向后的单元每经过一跳加上一层并重新填充
+	cell = padCell(relayLayers[len(relayLayers)-1].sealBackward(packCell([]byte("world"))), onionWireSize)
+	for i := len(relayLayers) - 2; i >= 0; i-- {
+		cell = padCell(relayLayers[i].sealBackward(cell[:relayLayers[i].cellSize-onionTagSize]), onionWireSize)
+	}
+	frame, _ := utils.EncodeMessage(cell)
+	conn.reader = bufio.NewReader(bytes.NewReader(frame))
+	data, err = conn.readCell()
+	if err != nil || string(data) != "world" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+}
+
+// 声明的长度超过固定单元长度时在分配内存前返回错误
+func TestReadCellLimit(t *testing.T) {
+	reader := bufio.NewReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	if _, err := readCell(reader); err != utils.ErrMessageTooLarge {
+		t.Fatalf("expected %v, got %v", utils.ErrMessageTooLarge, err)
+	}
+}
@@ -0,0 +1,235 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+func startTestServer(t *testing.T, server *Server) string {
+	t.Helper()
+	if err := server.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.StopServer)
+	return server.netListener.Addr().String()
+}
+
+func connectClient(t *testing.T, address string, identityKey *utils.DiffeHellmanKeyPair) *Client {
+	t.Helper()
+	client := NewClient(address, identityKey)
+	client.Timeout = 2 * time.Second
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func fetchOne(t *testing.T, client *Client) *Envelope {
+	t.Helper()
+	envelopes, err := client.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envelopes) != 1 {
+		t.Fatalf("expected one envelope, got %d", len(envelopes))
+	}
+	return envelopes[0]
+}
+
+func TestStoreAndForward(t *testing.T) {
+	address := startTestServer(t, NewServer("127.0.0.1:0", NewMemoryStore()))
+	aliceKey, bobKey := utils.NewDiffeHellmanKeyPair(), utils.NewDiffeHellmanKeyPair()
+	alice := connectClient(t, address, aliceKey)
+
+	// 收件人离线时消息保存在中继，上线后取回
+	bobAddress := FormatAddress(bobKey.PublicKey.Bytes(), "")
+	if err := alice.Send(bobAddress, []byte("hello"), 0); err != nil {
+		t.Fatal(err)
+	}
+	bob := connectClient(t, address, bobKey)
+	envelope := fetchOne(t, bob)
+	sender, payload, err := bob.OpenEnvelope(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sender, aliceKey.PublicKey.Bytes()) || string(payload) != "hello" {
+		t.Fatalf("got %x: %q", sender, payload)
+	}
+
+	// 确认之前消息一直保留，确认后删除
+	fetchOne(t, bob)
+	if err := bob.Ack(envelope.ID); err != nil {
+		t.Fatal(err)
+	}
+	if envelopes, err := bob.Fetch(); err != nil || len(envelopes) != 0 {
+		t.Fatalf("expected an empty mailbox, got %d, %v", len(envelopes), err)
+	}
+
+	// 其他人无法读取或确认 bob 的消息
+	if err := alice.Send(bobAddress, []byte("again"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if envelopes, err := alice.Fetch(); err != nil || len(envelopes) != 0 {
+		t.Fatalf("alice read bob's mailbox: %d, %v", len(envelopes), err)
+	}
+	if err := alice.Ack(fetchOne(t, bob).ID); err != nil {
+		t.Fatal(err)
+	}
+	fetchOne(t, bob)
+}
+
+func TestRequireAuthentication(t *testing.T) {
+	address := startTestServer(t, NewServer("127.0.0.1:0", NewMemoryStore()))
+	client := connectClient(t, address, utils.NewDiffeHellmanKeyPair())
+
+	// 认证失败的连接不能读取邮箱
+	forged := NewClient(address, utils.NewDiffeHellmanKeyPair())
+	forged.IdentityKey = &utils.DiffeHellmanKeyPair{
+		PrivateKey: forged.IdentityKey.PrivateKey,
+		PublicKey:  client.IdentityKey.PublicKey,
+	}
+	if err := forged.Connect(); err == nil || err.Error() != ErrSignature.Error() {
+		t.Fatalf("expected a signature error, got %v", err)
+	}
+
+	// 未认证的连接只能匿名投递
+	connect, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous := &Client{netConnect: connect, reader: bufio.NewReader(connect), writer: bufio.NewWriter(connect), Timeout: 2 * time.Second}
+	defer anonymous.Close()
+	if _, err := readMessage(anonymous.reader); err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range []*Message{{Type: FetchType}, {Type: AckType}, {Type: TokenType, Token: NewDeliveryToken()}} {
+		if _, err := anonymous.request(request); err == nil || err.Error() != ErrUnauthenticated.Error() {
+			t.Fatalf("%s: expected an unauthenticated error, got %v", request.Type, err)
+		}
+	}
+}
+
+// 声明的长度超过单个请求的上限时，服务端在分配内存前关闭连接
+func TestRejectOversizedRequest(t *testing.T) {
+	server := NewServer("127.0.0.1:0", NewMemoryStore())
+	address := startTestServer(t, server)
+	connect, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connect.Close()
+	reader := bufio.NewReader(connect)
+	if _, err := readMessage(reader); err != nil {
+		t.Fatal(err)
+	}
+
+	frame, _ := utils.EncodeMessage(make([]byte, 4))
+	binary.LittleEndian.PutUint32(frame, uint32(server.maxRequestSize()+1))
+	if _, err := connect.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	connect.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := reader.ReadByte(); err == nil {
+		t.Fatal("expected the server to close the connection")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatal("the server kept reading an oversized request")
+	}
+}
+
+func TestMailboxQuota(t *testing.T) {
+	server := NewServer("127.0.0.1:0", NewMemoryStore())
+	server.MaxMessages = 2
+	address := startTestServer(t, server)
+	alice := connectClient(t, address, utils.NewDiffeHellmanKeyPair())
+
+	bobAddress := FormatAddress(utils.NewDiffeHellmanKeyPair().PublicKey.Bytes(), "")
+	for range server.MaxMessages {
+		if err := alice.Send(bobAddress, []byte("hello"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := alice.Send(bobAddress, []byte("hello"), 0); err == nil || err.Error() != ErrQuotaExceeded.Error() {
+		t.Fatalf("expected a quota error, got %v", err)
+	}
+}
+
+func TestSealedSenderDelivery(t *testing.T) {
+	address := startTestServer(t, NewServer("127.0.0.1:0", NewMemoryStore()))
+	aliceKey, bobKey := utils.NewDiffeHellmanKeyPair(), utils.NewDiffeHellmanKeyPair()
+	alice := connectClient(t, address, aliceKey)
+	bob := connectClient(t, address, bobKey)
+
+	token := NewDeliveryToken()
+	if err := bob.RegisterDeliveryToken(token); err != nil {
+		t.Fatal(err)
+	}
+	bobAddress := FormatAddress(bobKey.PublicKey.Bytes(), "")
+	if err := alice.SendSealed(bobAddress, token, []byte("hello"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// 中继只能看到收件人，发送方身份由 bob 解开信封后得到
+	envelope := fetchOne(t, bob)
+	if !envelope.Sealed || envelope.Sender != nil {
+		t.Fatalf("the relay learned the sender: %+v", envelope)
+	}
+	if bytes.Contains(envelope.Payload, []byte("hello")) || bytes.Contains(envelope.Payload, aliceKey.PublicKey.Bytes()) {
+		t.Fatal("the sealed envelope leaks its content")
+	}
+	sender, payload, err := bob.OpenEnvelope(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sender, aliceKey.PublicKey.Bytes()) || string(payload) != "hello" {
+		t.Fatalf("got %x: %q", sender, payload)
+	}
+	if _, _, err := alice.OpenEnvelope(envelope); err == nil {
+		t.Fatal("opened an envelope sealed for someone else")
+	}
+
+	// 没有令牌或令牌错误时拒绝匿名投递
+	for _, wrong := range [][]byte{nil, NewDeliveryToken()} {
+		if err := alice.SendSealed(bobAddress, wrong, []byte("spam"), 0); err == nil || err.Error() != ErrDeliveryToken.Error() {
+			t.Fatalf("expected a delivery token error, got %v", err)
+		}
+	}
+	fetchOne(t, bob)
+}
+
+func TestOpenTamperedSealedSender(t *testing.T) {
+	senderKey, recipientKey := utils.NewDiffeHellmanKeyPair(), utils.NewDiffeHellmanKeyPair()
+	sealed, err := SealSender(senderKey, recipientKey.PublicKey.Bytes(), []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 将发送方替换为其他身份后，内容的密钥随之改变，无法伪造发送方
+	envelope := &sealedEnvelope{}
+	if err := json.Unmarshal(sealed, envelope); err != nil {
+		t.Fatal(err)
+	}
+	mallory := utils.NewDiffeHellmanKeyPair()
+	forged, err := SealSender(mallory, recipientKey.PublicKey.Bytes(), []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedEnvelope := &sealedEnvelope{}
+	if err := json.Unmarshal(forged, forgedEnvelope); err != nil {
+		t.Fatal(err)
+	}
+	envelope.Ephemeral = forgedEnvelope.Ephemeral
+	envelope.SenderNonce = forgedEnvelope.SenderNonce
+	envelope.SenderCiphertext = forgedEnvelope.SenderCiphertext
+	tampered, _ := json.Marshal(envelope)
+	if _, _, err := OpenSealedSender(recipientKey, tampered); err == nil {
+		t.Fatal("opened an envelope with a swapped sender")
+	}
+}
@@ -0,0 +1,110 @@
+package relay
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+var ErrSealedEnvelope = errors.New("无法解开密封发件人信封")
+
+// 密封发件人信封：发送方身份与消息内容 (包括棘轮消息头) 都加密给收件人的身份公钥，
+// 中继只能看到收件人邮箱
+type sealedEnvelope struct {
+	Ephemeral        []byte
+	SenderNonce      []byte
+	SenderCiphertext []byte
+	Nonce            []byte
+	Ciphertext       []byte
+}
+
+// 生成随机的投递令牌，收件人在中继登记后分享给联系人，持有令牌才能匿名投递
+func NewDeliveryToken() []byte {
+	token := make([]byte, 32)
+	rand.Read(token)
+	return token
+}
+
+// 使用临时密钥与收件人身份公钥的 DH 结果加密发送方身份，
+// 再使用双方身份密钥的 DH 结果加密内容，使收件人能够确认发送方身份
+func SealSender(senderKey *utils.DiffeHellmanKeyPair, recipient, payload []byte) ([]byte, error) {
+	recipientKey, err := utils.BytesToPublicKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralKey := utils.NewDiffeHellmanKeyPair()
+	ephemeralSecret, err := ephemeralKey.PrivateKey.ECDH(recipientKey)
+	if err != nil {
+		return nil, err
+	}
+	chainKey, senderCipherKey := utils.DevirateChainKey(ephemeralSecret, sealedSalt(ephemeralKey.PublicKey.Bytes(), recipient))
+	senderNonce, senderCiphertext, err := utils.EncryptAESGCM(senderCipherKey, senderKey.PublicKey.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	staticSecret, err := senderKey.PrivateKey.ECDH(recipientKey)
+	if err != nil {
+		return nil, err
+	}
+	_, messageKey := utils.DevirateChainKey(staticSecret, sealedSalt(chainKey, senderCiphertext))
+	nonce, ciphertext, err := utils.EncryptAESGCM(messageKey, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&sealedEnvelope{
+		Ephemeral:        ephemeralKey.PublicKey.Bytes(),
+		SenderNonce:      senderNonce,
+		SenderCiphertext: senderCiphertext,
+		Nonce:            nonce,
+		Ciphertext:       ciphertext,
+	})
+}
+
+// 使用收件人身份密钥解开信封，返回发送方的身份公钥与消息内容
+func OpenSealedSender(recipientKey *utils.DiffeHellmanKeyPair, sealed []byte) ([]byte, []byte, error) {
+	envelope := &sealedEnvelope{}
+	if err := json.Unmarshal(sealed, envelope); err != nil {
+		return nil, nil, ErrSealedEnvelope
+	}
+	ephemeralKey, err := utils.BytesToPublicKey(envelope.Ephemeral)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ephemeralSecret, err := recipientKey.PrivateKey.ECDH(ephemeralKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	chainKey, senderCipherKey := utils.DevirateChainKey(ephemeralSecret, sealedSalt(envelope.Ephemeral, recipientKey.PublicKey.Bytes()))
+	sender, err := utils.DecryptAESGCM(senderCipherKey, envelope.SenderNonce, envelope.SenderCiphertext)
+	if err != nil {
+		return nil, nil, ErrSealedEnvelope
+	}
+	senderKey, err := utils.BytesToPublicKey(sender)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	staticSecret, err := recipientKey.PrivateKey.ECDH(senderKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, messageKey := utils.DevirateChainKey(staticSecret, sealedSalt(chainKey, envelope.SenderCiphertext))
+	payload, err := utils.DecryptAESGCM(messageKey, envelope.Nonce, envelope.Ciphertext)
+	if err != nil {
+		return nil, nil, ErrSealedEnvelope
+	}
+	return sender, payload, nil
+}
+
+// 拼接派生密钥使用的 salt，不修改传入切片的底层数组
+func sealedSalt(left, right []byte) []byte {
+	salt := make([]byte, 0, len(left)+len(right))
+	salt = append(salt, left...)
+	return append(salt, right...)
+}
@@ -0,0 +1,362 @@
+package relay
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+// 清理过期消息的间隔
+const expireInterval = time.Minute
+
+// 认证前读取请求与写入回复的最长时间，未认证的空闲连接超时后关闭
+const requestTimeout = 10 * time.Second
+
+// 请求中除密文以外的字段所占长度的上限
+const requestOverhead = 64 << 10
+
+var ErrUnauthenticated = errors.New("请先使用身份密钥认证")
+var ErrSignature = errors.New("签名验证失败")
+var ErrQuotaExceeded = errors.New("收件人的邮箱已满")
+var ErrInvalidRequest = errors.New("无效的请求")
+var ErrDeliveryToken = errors.New("投递令牌无效")
+
+// 存储转发中继：为每个收件人保存密文，直到收件人上线取回并确认
+type Server struct {
+	stopChan     chan bool
+	stopOnce     sync.Once
+	waitGroup    sync.WaitGroup
+	netListener  net.Listener
+	mutex        sync.Mutex
+	Store        Store
+	MaxMessages  int           // 每个邮箱最多保存的消息数量
+	MaxBytes     int           // 每个邮箱最多保存的密文字节数
+	DefaultTTL   time.Duration // 未指定时消息的保存时间
+	MaxTTL       time.Duration // 消息的最长保存时间
+	LocalAddress string
+	// 以下字段用于中继之间的转发
+	Host        string                     // 本中继对外的地址，即 user@relayhost 中的 relayhost
+	IdentityKey *utils.DiffeHellmanKeyPair // 与其他中继互相认证使用的身份密钥
+	Upstream    string                     // 目的中继不是直连中继时，消息交给该中继转发
+	MaxHops     int                        // 消息最多经过的中继数量
+	// 是否作为洋葱线路的一跳，需要设置 IdentityKey
+	AllowCircuits bool
+	// 洋葱线路允许连接的下一跳与出口目标，AddPeer 添加的中继总是允许
+	CircuitTargets []string
+	peers          map[string]*relayPeer
+	seen           map[string]int64
+}
+
+func NewServer(localAddress string, store Store) *Server {
+	return &Server{
+		stopChan:     make(chan bool),
+		Store:        store,
+		MaxMessages:  1000,
+		MaxBytes:     16 << 20,
+		DefaultTTL:   7 * 24 * time.Hour,
+		MaxTTL:       30 * 24 * time.Hour,
+		LocalAddress: localAddress,
+		MaxHops:      8,
+		peers:        make(map[string]*relayPeer),
+		seen:         make(map[string]int64),
+	}
+}
+
+func (server *Server) StartServer() error {
+	listener, err := net.Listen("tcp", server.LocalAddress)
+	if err != nil {
+		return err
+	}
+	server.netListener = listener
+	log.Printf("🎉 中继服务已开始监听: %s\n", listener.Addr().String())
+
+	server.waitGroup.Add(2)
+	go server.handleServer()
+	go server.handleExpire()
+	for _, peer := range server.peers {
+		server.waitGroup.Add(1)
+		go server.handleForward(peer)
+	}
+	return nil
+}
+
+func (server *Server) StopServer() {
+	server.stopOnce.Do(func() {
+		close(server.stopChan)
+		server.netListener.Close()
+		server.waitGroup.Wait()
+		log.Println("✅ 中继服务已关闭")
+	})
+}
+
+func (server *Server) handleServer() {
+	defer server.waitGroup.Done()
+
+	for {
+		connect, err := server.netListener.Accept()
+		if err != nil {
+			return
+		}
+		server.waitGroup.Add(1)
+		go server.handleConnection(connect)
+	}
+}
+
+// 定期清理过期的消息
+func (server *Server) handleExpire() {
+	defer server.waitGroup.Done()
+
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-server.stopChan:
+			return
+		case <-ticker.C:
+			now := time.Now().UnixNano()
+			if err := server.Store.Expire(now); err != nil {
+				log.Printf("❌ 清理过期消息失败: %s\n", err.Error())
+			}
+			server.expireSeen(now)
+		}
+	}
+}
+
+// 连接建立后先下发挑战，客户端认证后才能收发消息
+func (server *Server) handleConnection(connect net.Conn) {
+	defer server.waitGroup.Done()
+	defer connect.Close()
+
+	// 服务停止时关闭连接，中断阻塞的读取
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-server.stopChan:
+			connect.Close()
+		case <-done:
+		}
+	}()
+
+	reader := bufio.NewReader(connect)
+	writer := bufio.NewWriter(connect)
+	connect.SetDeadline(time.Now().Add(requestTimeout))
+	nonce := make([]byte, 32)
+	rand.Read(nonce)
+	if err := writeMessage(writer, &Message{Type: ChallengeType, Nonce: nonce}); err != nil {
+		return
+	}
+
+	var identity []byte
+	var linkRelay string // 对方是已认证的中继时为其地址
+	for {
+		// 认证后的连接可以长时间保持，用于持续收取消息
+		if identity == nil && linkRelay == "" {
+			connect.SetDeadline(time.Now().Add(requestTimeout))
+		} else {
+			connect.SetDeadline(time.Time{})
+		}
+		request, err := readMessageLimit(reader, server.maxRequestSize())
+		if err != nil {
+			return
+		}
+
+		response := &Message{Type: ResultType}
+		switch request.Type {
+		case AuthType:
+			if _, err = utils.BytesToPublicKey(request.IdentityKey); err == nil {
+				if !utils.XEdDSAVerify(request.IdentityKey, authPayload(nonce), request.Signature) {
+					err = ErrSignature
+				} else if request.Relay != "" {
+					// 中继链路需要双向认证，本中继签名对方的挑战
+					if err = server.checkLink(request.Relay, request.IdentityKey); err == nil && server.IdentityKey != nil {
+						response.IdentityKey = server.IdentityKey.PublicKey.Bytes()
+						response.Signature, err = server.IdentityKey.Sign(linkPayload(request.Nonce))
+					}
+					if err == nil {
+						linkRelay = request.Relay
+					}
+				} else {
+					identity = request.IdentityKey
+				}
+			}
+		case SendType:
+			if identity == nil {
+				err = ErrUnauthenticated
+				break
+			}
+			var envelope *Envelope
+			if envelope, err = server.newEnvelope(identity, false, request); err != nil {
+				break
+			}
+			// 其他中继无法验证本中继声明的发件人，跨中继只转发密封发件人信封
+			if envelope.Destination != "" && envelope.Destination != server.Host {
+				err = ErrSealedRequired
+				break
+			}
+			err = server.deposit(envelope)
+		case SealedType:
+			// 匿名投递不需要认证，由收件人的投递令牌防止滥用；
+			// 发往其他中继时令牌随消息转发，由目的中继校验
+			var envelope *Envelope
+			if envelope, err = server.newEnvelope(nil, true, request); err != nil {
+				break
+			}
+			if envelope.Destination == "" || envelope.Destination == server.Host {
+				err = server.checkToken(request.Recipient, request.Token)
+			} else {
+				envelope.Token = request.Token
+			}
+			if err == nil {
+				err = server.deposit(envelope)
+			}
+		case CircuitType:
+			// 线路建立后连接不再使用请求与回复的格式，失败时直接断开
+			if !server.AllowCircuits || server.IdentityKey == nil {
+				err = ErrInvalidRequest
+				break
+			}
+			if err := server.handleCircuit(connect, reader, writer, request.Payload); err != nil {
+				log.Printf("⚠️ 建立洋葱线路失败: %s\n", err.Error())
+			}
+			return
+		case ForwardType:
+			if linkRelay == "" {
+				err = ErrUnauthenticated
+				break
+			}
+			response.IDs = server.receive(linkRelay, request.Envelopes)
+		case TokenType:
+			if identity == nil {
+				err = ErrUnauthenticated
+				break
+			}
+			if len(request.Token) == 0 {
+				err = ErrInvalidRequest
+				break
+			}
+			verifier := sha256.Sum256(request.Token)
+			err = server.Store.SetToken(hex.EncodeToString(identity), verifier[:])
+		case FetchType:
+			if identity == nil {
+				err = ErrUnauthenticated
+				break
+			}
+			response.Envelopes, err = server.fetch(identity)
+		case AckType:
+			if identity == nil {
+				err = ErrUnauthenticated
+				break
+			}
+			err = server.Store.Delete(hex.EncodeToString(identity), request.IDs)
+		default:
+			err = ErrInvalidRequest
+		}
+
+		if err != nil {
+			response.Error = err.Error()
+		}
+		if err := writeMessage(writer, response); err != nil {
+			return
+		}
+	}
+}
+
+// 单个请求的最大长度：Base64 编码后最长的密文加上其他字段
+func (server *Server) maxRequestSize() int {
+	return base64.StdEncoding.EncodedLen(server.MaxBytes) + requestOverhead
+}
+
+// 校验请求并生成待投递的消息
+func (server *Server) newEnvelope(sender []byte, isSealed bool, request *Message) (*Envelope, error) {
+	if _, err := utils.BytesToPublicKey(request.Recipient); err != nil {
+		return nil, err
+	}
+	if len(request.Payload) == 0 || len(request.Payload) > server.MaxBytes {
+		return nil, ErrInvalidRequest
+	}
+	ttl := server.DefaultTTL
+	if request.TTL > 0 {
+		ttl = min(time.Duration(request.TTL)*time.Second, server.MaxTTL)
+	}
+
+	now := time.Now().UnixNano()
+	idBytes := make([]byte, 16)
+	rand.Read(idBytes)
+	return &Envelope{
+		ID:          hex.EncodeToString(idBytes),
+		Sender:      sender,
+		Recipient:   request.Recipient,
+		Payload:     request.Payload,
+		Sealed:      isSealed,
+		Timestamp:   now,
+		ExpiresAt:   now + int64(ttl),
+		Destination: request.Destination,
+	}, nil
+}
+
+// 检查邮箱的配额后存入消息，发件箱同样受配额限制
+func (server *Server) appendEnvelope(mailbox string, envelope *Envelope) error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	envelopes, err := server.Store.List(mailbox)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	count, size := 0, 0
+	for _, envelope := range envelopes {
+		if envelope.ExpiresAt > now {
+			count++
+			size += len(envelope.Payload)
+		}
+	}
+	if count+1 > server.MaxMessages || size+len(envelope.Payload) > server.MaxBytes {
+		return ErrQuotaExceeded
+	}
+	return server.Store.Append(mailbox, envelope)
+}
+
+// 中继只保存令牌的哈希，比较时使用常数时间比较
+func (server *Server) checkToken(recipient, token []byte) error {
+	if _, err := utils.BytesToPublicKey(recipient); err != nil {
+		return err
+	}
+	expected, err := server.Store.Token(hex.EncodeToString(recipient))
+	if err != nil {
+		return err
+	}
+	verifier := sha256.Sum256(token)
+	if expected == nil || subtle.ConstantTimeCompare(expected, verifier[:]) != 1 {
+		return ErrDeliveryToken
+	}
+	return nil
+}
+
+// 返回邮箱中尚未过期的消息，消息在收件人确认前一直保留
+func (server *Server) fetch(identity []byte) ([]*Envelope, error) {
+	envelopes, err := server.Store.List(hex.EncodeToString(identity))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixNano()
+	valid := []*Envelope{}
+	for _, envelope := range envelopes {
+		if envelope.ExpiresAt > now {
+			valid = append(valid, envelope)
+		}
+	}
+	return valid, nil
+}
@@ -0,0 +1,236 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// 邮箱的存储后端，mailbox 为收件人身份公钥的十六进制编码
+type Store interface {
+	Append(mailbox string, envelope *Envelope) error
+	List(mailbox string) ([]*Envelope, error)
+	Delete(mailbox string, ids []string) error
+	// 删除所有在 now 之前过期的消息
+	Expire(now int64) error
+	// 保存与读取邮箱投递令牌的哈希，未登记时返回 nil
+	SetToken(mailbox string, verifier []byte) error
+	Token(mailbox string) ([]byte, error)
+}
+
+// 保存在内存中的存储后端，服务重启后数据丢失
+type MemoryStore struct {
+	mutex     sync.Mutex
+	mailboxes map[string][]*Envelope
+	tokens    map[string][]byte
+}
+
+// 每个邮箱保存为目录下的一个 JSON 文件
+type FileStore struct {
+	mutex     sync.Mutex
+	Directory string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mailboxes: make(map[string][]*Envelope),
+		tokens:    make(map[string][]byte),
+	}
+}
+
+func (store *MemoryStore) Append(mailbox string, envelope *Envelope) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.mailboxes[mailbox] = append(store.mailboxes[mailbox], envelope)
+	return nil
+}
+
+func (store *MemoryStore) List(mailbox string) ([]*Envelope, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return append([]*Envelope(nil), store.mailboxes[mailbox]...), nil
+}
+
+func (store *MemoryStore) Delete(mailbox string, ids []string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.mailboxes[mailbox] = removeEnvelopes(store.mailboxes[mailbox], func(envelope *Envelope) bool {
+		return slices.Contains(ids, envelope.ID)
+	})
+	if len(store.mailboxes[mailbox]) == 0 {
+		delete(store.mailboxes, mailbox)
+	}
+	return nil
+}
+
+func (store *MemoryStore) Expire(now int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for mailbox, envelopes := range store.mailboxes {
+		store.mailboxes[mailbox] = removeEnvelopes(envelopes, func(envelope *Envelope) bool {
+			return envelope.ExpiresAt <= now
+		})
+		if len(store.mailboxes[mailbox]) == 0 {
+			delete(store.mailboxes, mailbox)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) SetToken(mailbox string, verifier []byte) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.tokens[mailbox] = verifier
+	return nil
+}
+
+func (store *MemoryStore) Token(mailbox string) ([]byte, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.tokens[mailbox], nil
+}
+
+func NewFileStore(directory string) (*FileStore, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{Directory: directory}, nil
+}
+
+func (store *FileStore) Append(mailbox string, envelope *Envelope) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	envelopes, err := store.load(mailbox)
+	if err != nil {
+		return err
+	}
+	return store.save(mailbox, append(envelopes, envelope))
+}
+
+func (store *FileStore) List(mailbox string) ([]*Envelope, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.load(mailbox)
+}
+
+func (store *FileStore) Delete(mailbox string, ids []string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	envelopes, err := store.load(mailbox)
+	if err != nil {
+		return err
+	}
+	return store.save(mailbox, removeEnvelopes(envelopes, func(envelope *Envelope) bool {
+		return slices.Contains(ids, envelope.ID)
+	}))
+}
+
+func (store *FileStore) Expire(now int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(store.Directory, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		mailbox := strings.TrimSuffix(filepath.Base(path), ".json")
+		envelopes, err := store.load(mailbox)
+		if err != nil {
+			return err
+		}
+		remaining := removeEnvelopes(envelopes, func(envelope *Envelope) bool {
+			return envelope.ExpiresAt <= now
+		})
+		if len(remaining) != len(envelopes) {
+			if err := store.save(mailbox, remaining); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 投递令牌的哈希保存在邮箱旁的 .token 文件中
+func (store *FileStore) SetToken(mailbox string, verifier []byte) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return os.WriteFile(filepath.Join(store.Directory, mailbox+".token"), verifier, 0600)
+}
+
+func (store *FileStore) Token(mailbox string) ([]byte, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	verifier, err := os.ReadFile(filepath.Join(store.Directory, mailbox+".token"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return verifier, err
+}
+
+func (store *FileStore) load(mailbox string) ([]*Envelope, error) {
+	data, err := os.ReadFile(store.path(mailbox))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	envelopes := []*Envelope{}
+	if err := json.Unmarshal(data, &envelopes); err != nil {
+		return nil, err
+	}
+	return envelopes, nil
+}
+
+// 邮箱为空时删除文件，否则先写入临时文件再重命名
+func (store *FileStore) save(mailbox string, envelopes []*Envelope) error {
+	if len(envelopes) == 0 {
+		err := os.Remove(store.path(mailbox))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	data, err := json.Marshal(envelopes)
+	if err != nil {
+		return err
+	}
+	tempPath := store.path(mailbox) + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, store.path(mailbox))
+}
+
+func (store *FileStore) path(mailbox string) string {
+	return filepath.Join(store.Directory, mailbox+".json")
+}
+
+// 返回不满足 remove 条件的消息
+func removeEnvelopes(envelopes []*Envelope, remove func(*Envelope) bool) []*Envelope {
+	remaining := []*Envelope{}
+	for _, envelope := range envelopes {
+		if !remove(envelope) {
+			remaining = append(remaining, envelope)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,369 @@
+package rendezvous
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-reuseport"
+	"github.com/reagin/double_ratchet/utils"
+)
+
+var ErrPunchFailed = errors.New("打洞与中继均失败")
+
+// 监听方确认所选直连连接时回复的信息
+var punchAck = []byte("ACK")
+
+// 通过 rendezvous 服务建立连接的传输层，远端地址为双方约定的令牌
+type Transport struct {
+	ServerAddress string        // rendezvous 服务地址
+	Token         string        // 监听时登记的令牌
+	PunchTimeout  time.Duration // 打洞的最长时间，超时后改用中继
+	DisablePunch  bool          // 跳过打洞直接使用中继
+}
+
+type punchListener struct {
+	transport    *Transport
+	localAddress string
+	closeOnce    sync.Once
+	closeChan    chan bool
+	mutex        sync.Mutex
+	current      net.Conn
+}
+
+func NewTransport(serverAddress, token string) *Transport {
+	return &Transport{
+		ServerAddress: serverAddress,
+		Token:         token,
+		PunchTimeout:  5 * time.Second,
+	}
+}
+
+// 以拨号方身份与登记了 token 的对端建立连接
+func (transport *Transport) Dial(localAddress, token string) (net.Conn, error) {
+	return transport.connect(localAddress, token, DialRole, nil)
+}
+
+// 返回的监听器每次 Accept 都会以 Token 重新登记，等待新的拨号方
+func (transport *Transport) Listen(localAddress string) (net.Listener, error) {
+	return &punchListener{
+		transport:    transport,
+		localAddress: localAddress,
+		closeChan:    make(chan bool),
+	}, nil
+}
+
+func (listener *punchListener) Accept() (net.Conn, error) {
+	for {
+		connect, err := listener.transport.connect(listener.localAddress, listener.transport.Token, ListenRole, listener)
+		select {
+		case <-listener.closeChan:
+			if connect != nil {
+				connect.Close()
+			}
+			return nil, net.ErrClosed
+		default:
+		}
+		if err != nil {
+			log.Printf("🤯 等待对端打洞失败: %s\n", err.Error())
+			time.Sleep(time.Second)
+			continue
+		}
+		return connect, nil
+	}
+}
+
+func (listener *punchListener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.closeChan)
+		listener.mutex.Lock()
+		if listener.current != nil {
+			listener.current.Close()
+		}
+		listener.mutex.Unlock()
+	})
+	return nil
+}
+
+func (listener *punchListener) Addr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", listener.localAddress)
+	return addr
+}
+
+// 记录正在登记的连接，以便 Close 时中断等待
+func (listener *punchListener) track(connect net.Conn) bool {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+
+	select {
+	case <-listener.closeChan:
+		return false
+	default:
+		listener.current = connect
+		return true
+	}
+}
+
+// 在 rendezvous 服务登记，交换地址后打洞，失败时复用登记连接作为中继
+func (transport *Transport) connect(localAddress, token, role string, listener *punchListener) (net.Conn, error) {
+	if localAddress == "" {
+		localAddress = "0.0.0.0:0"
+	}
+	rendezvousConn, err := reuseport.Dial("tcp", localAddress, transport.ServerAddress)
+	if err != nil {
+		return nil, err
+	}
+	if listener != nil && !listener.track(rendezvousConn) {
+		rendezvousConn.Close()
+		return nil, net.ErrClosed
+	}
+	// 后续的监听与拨号都复用登记连接的本地端口，使 NAT 映射保持一致
+	localAddress = rendezvousConn.LocalAddr().String()
+
+	reader := bufio.NewReader(rendezvousConn)
+	writer := bufio.NewWriter(rendezvousConn)
+	register := &Message{Type: RegisterType, Token: token, Role: role, LocalAddress: localAddress}
+	if err := writeMessage(writer, register); err != nil {
+		rendezvousConn.Close()
+		return nil, err
+	}
+
+	peer, err := readMessage(reader)
+	if err != nil || peer.Type != PeerType {
+		rendezvousConn.Close()
+		return nil, ErrPunchFailed
+	}
+	log.Printf("🎉 获取到对端地址: 公网 %s, 内网 %s\n", peer.PublicAddress, peer.LocalAddress)
+
+	var directConn net.Conn
+	decisions := make(chan *Message, 1)
+	if role == DialRole {
+		if !transport.DisablePunch {
+			directConn = transport.punch(localAddress, peer, token, true)
+		}
+		result := &Message{Type: ResultType, Token: token, Direct: directConn != nil}
+		if err := writeMessage(writer, result); err != nil {
+			rendezvousConn.Close()
+			return nil, err
+		}
+		decision, err := readMessage(reader)
+		if err != nil || decision.Type != DecisionType {
+			rendezvousConn.Close()
+			return nil, ErrPunchFailed
+		}
+		decisions <- decision
+	} else {
+		// 监听方同时等待打洞结果与服务端的决定
+		go func() {
+			decision, err := readMessage(reader)
+			if err != nil || decision.Type != DecisionType {
+				decision = nil
+			}
+			decisions <- decision
+		}()
+		if !transport.DisablePunch {
+			directConn = transport.punch(localAddress, peer, token, false)
+		}
+	}
+
+	decision := <-decisions
+	switch {
+	case decision == nil:
+		if directConn != nil {
+			directConn.Close()
+		}
+		rendezvousConn.Close()
+		return nil, ErrPunchFailed
+	case decision.Direct && directConn != nil:
+		rendezvousConn.Close()
+		log.Printf("✅ 与对端 %s 打洞成功\n", directConn.RemoteAddr().String())
+		return directConn, nil
+	case decision.Direct:
+		rendezvousConn.Close()
+		return nil, ErrPunchFailed
+	default:
+		if directConn != nil {
+			directConn.Close()
+		}
+		log.Println("⚠️ 打洞失败，通过 rendezvous 服务中继")
+		return &bufferedConn{rendezvousConn, reader}, nil
+	}
+}
+
+// 同时监听本地端口并向对端的公网、内网地址发起连接，实现 TCP 同时打开
+// 拨号方在候选连接上发送令牌，监听方确认第一条送达令牌的连接，双方都以该连接为准
+func (transport *Transport) punch(localAddress string, peer *Message, token string, isDialer bool) net.Conn {
+	deadline := time.Now().Add(transport.PunchTimeout)
+	candidates := make(chan net.Conn, 8)
+	done := make(chan bool)
+	var waitGroup sync.WaitGroup
+
+	if listener, err := reuseport.Listen("tcp", localAddress); err == nil {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			<-done
+			listener.Close()
+		}()
+		go func() {
+			for {
+				connect, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				candidates <- connect
+			}
+		}()
+	}
+
+	targets := []string{peer.PublicAddress}
+	if peer.LocalAddress != "" && peer.LocalAddress != peer.PublicAddress {
+		targets = append(targets, peer.LocalAddress)
+	}
+	for _, target := range targets {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for time.Now().Before(deadline) {
+				connect, err := reuseport.DialTimeout("tcp", localAddress, target, 500*time.Millisecond)
+				if err == nil {
+					candidates <- connect
+					return
+				}
+				select {
+				case <-done:
+					return
+				case <-time.After(200 * time.Millisecond):
+				}
+			}
+		}()
+	}
+
+	var selected net.Conn
+	if isDialer {
+		selected = selectCandidate(candidates, deadline, token)
+	} else {
+		selected = confirmCandidate(candidates, deadline, token)
+	}
+	close(done)
+
+	// 关闭未被选中的连接
+	go func() {
+		waitGroup.Wait()
+		for {
+			select {
+			case connect := <-candidates:
+				connect.Close()
+			case <-time.After(time.Second):
+				return
+			}
+		}
+	}()
+
+	return selected
+}
+
+// 拨号方：在每条候选连接上发送令牌，以监听方确认的连接为准；
+// 监听方只确认一条连接，因此双方最终选定的是同一条连接
+func selectCandidate(candidates chan net.Conn, deadline time.Time, token string) net.Conn {
+	// 比监听方多等待一秒，使监听方在超时前发出的确认能够送达
+	timeout := time.Until(deadline) + 3*time.Second
+	return raceCandidates(candidates, timeout, func(connect net.Conn) (net.Conn, bool) {
+		connect.SetDeadline(time.Now().Add(timeout))
+		writer := bufio.NewWriter(connect)
+		if writeFrame(writer, []byte(token)) != nil {
+			return nil, false
+		}
+		reader := bufio.NewReader(connect)
+		ack, err := utils.DecodeMessageLimit(reader, len(punchAck))
+		if err != nil || !bytes.Equal(ack, punchAck) {
+			return nil, false
+		}
+		connect.SetDeadline(time.Time{})
+		return &bufferedConn{connect, reader}, true
+	}, nil)
+}
+
+// 监听方：在所有候选连接上等待令牌，只向第一条送达令牌的连接回复确认
+func confirmCandidate(candidates chan net.Conn, deadline time.Time, token string) net.Conn {
+	// 拨号方在截止时间前选定的连接可能稍后才送达令牌
+	timeout := time.Until(deadline) + 2*time.Second
+	return raceCandidates(candidates, timeout, func(connect net.Conn) (net.Conn, bool) {
+		connect.SetReadDeadline(time.Now().Add(timeout))
+		reader := bufio.NewReader(connect)
+		tokenBytes, err := utils.DecodeMessageLimit(reader, maxMessageSize)
+		if err != nil || string(tokenBytes) != token {
+			return nil, false
+		}
+		connect.SetReadDeadline(time.Time{})
+		return &bufferedConn{connect, reader}, true
+	}, func(connect net.Conn) bool {
+		return writeFrame(bufio.NewWriter(connect), punchAck) == nil
+	})
+}
+
+// 并发地在每条候选连接上执行 check，返回第一条通过检查且 accept 成功的连接；
+// 选定或超时后关闭其余所有候选连接，仍在读取的检查随之结束
+func raceCandidates(candidates chan net.Conn, timeout time.Duration, check func(net.Conn) (net.Conn, bool), accept func(net.Conn) bool) net.Conn {
+	type result struct {
+		raw       net.Conn
+		confirmed net.Conn
+	}
+	results := make(chan result)
+	stop := make(chan bool)
+	var conns []net.Conn
+	var selected result
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+loop:
+	for {
+		select {
+		case connect := <-candidates:
+			conns = append(conns, connect)
+			go func() {
+				confirmed, ok := check(connect)
+				if !ok {
+					connect.Close()
+					return
+				}
+				select {
+				case results <- result{connect, confirmed}:
+				case <-stop:
+				}
+			}()
+		case current := <-results:
+			if accept != nil && !accept(current.confirmed) {
+				current.raw.Close()
+				continue
+			}
+			selected = current
+			break loop
+		case <-timer.C:
+			break loop
+		}
+	}
+
+	close(stop)
+	for _, connect := range conns {
+		if connect != selected.raw {
+			connect.Close()
+		}
+	}
+	return selected.confirmed
+}
+
+func writeFrame(writer *bufio.Writer, data []byte) error {
+	frame, err := utils.EncodeMessage(data)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(frame); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,65 @@
+package rendezvous
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	RegisterType = "register" // 客户端登记令牌与内网地址
+	PeerType     = "peer"     // 服务端下发对端的公网与内网地址
+	ResultType   = "result"   // 拨号方上报打洞结果
+	DecisionType = "decision" // 服务端通知双方使用直连还是中继
+)
+
+const (
+	DialRole   = "dial"
+	ListenRole = "listen"
+)
+
+// 单条信息的最大长度，信息只包含令牌与地址
+const maxMessageSize = 4 << 10
+
+// 客户端与 rendezvous 服务之间交换的信息
+type Message struct {
+	Type          string
+	Token         string
+	Role          string
+	LocalAddress  string // 客户端自报的内网地址
+	PublicAddress string // 服务端观察到的公网地址
+	Direct        bool   // 是否打洞成功
+}
+
+// 使用 bufio.Reader 读取数据后仍保留缓冲区中未读取的字节
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+func writeMessage(writer *bufio.Writer, message *Message) error {
+	messageBytes, _ := json.Marshal(message)
+	messageBytes, _ = utils.EncodeMessage(messageBytes)
+	if _, err := writer.Write(messageBytes); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func readMessage(reader *bufio.Reader) (*Message, error) {
+	messageBytes, err := utils.DecodeMessageLimit(reader, maxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+	message := &Message{}
+	if err := json.Unmarshal(messageBytes, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
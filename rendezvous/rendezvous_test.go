@@ -0,0 +1,107 @@
+package rendezvous
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startTestServer(t *testing.T) string {
+	t.Helper()
+	server := NewServer("127.0.0.1:0")
+	if err := server.StartServer(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.StopServer)
+	return server.netListener.Addr().String()
+}
+
+// 在回环地址上建立一对连接，返回拨号方与监听方的连接
+func connectPair(t *testing.T, serverAddress, token string, disablePunch bool) (net.Conn, net.Conn) {
+	t.Helper()
+	listenTransport := NewTransport(serverAddress, token)
+	listenTransport.PunchTimeout = 2 * time.Second
+	listenTransport.DisablePunch = disablePunch
+	listener, err := listenTransport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		connect, err := listener.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- connect
+	}()
+	// 等待监听方先完成登记
+	time.Sleep(200 * time.Millisecond)
+
+	dialTransport := NewTransport(serverAddress, "")
+	dialTransport.PunchTimeout = 2 * time.Second
+	dialTransport.DisablePunch = disablePunch
+	dialConn, err := dialTransport.Dial("127.0.0.1:0", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dialConn.Close() })
+
+	select {
+	case listenConn := <-accepted:
+		if listenConn == nil {
+			t.Fatal("listener failed to accept")
+		}
+		t.Cleanup(func() { listenConn.Close() })
+		return dialConn, listenConn
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the listener")
+	}
+	return nil, nil
+}
+
+func exchange(t *testing.T, a, b net.Conn) {
+	t.Helper()
+	for _, pair := range [][2]net.Conn{{a, b}, {b, a}} {
+		if _, err := pair[0].Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		buffer := make([]byte, 4)
+		pair[1].SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(pair[1], buffer); err != nil {
+			t.Fatal(err)
+		}
+		if string(buffer) != "ping" {
+			t.Fatalf("got %q", buffer)
+		}
+	}
+}
+
+func TestDirectConnection(t *testing.T) {
+	serverAddress := startTestServer(t)
+	for i := 0; i < 3; i++ {
+		dialConn, listenConn := connectPair(t, serverAddress, "direct-token", false)
+		// 双方都应使用直连，而不是一方直连、另一方中继
+		if dialConn.RemoteAddr().String() == serverAddress || listenConn.RemoteAddr().String() == serverAddress {
+			t.Fatalf("expected a direct connection on both sides, got %s and %s", dialConn.RemoteAddr(), listenConn.RemoteAddr())
+		}
+		if dialConn.LocalAddr().String() != listenConn.RemoteAddr().String() {
+			t.Fatalf("the two sides selected different connections: %s and %s", dialConn.LocalAddr(), listenConn.RemoteAddr())
+		}
+		exchange(t, dialConn, listenConn)
+		dialConn.Close()
+		listenConn.Close()
+	}
+}
+
+func TestRelayConnection(t *testing.T) {
+	serverAddress := startTestServer(t)
+	dialConn, listenConn := connectPair(t, serverAddress, "relay-token", true)
+	if dialConn.RemoteAddr().String() != serverAddress || listenConn.RemoteAddr().String() != serverAddress {
+		t.Fatalf("expected both sides to be relayed, got %s and %s", dialConn.RemoteAddr(), listenConn.RemoteAddr())
+	}
+	exchange(t, dialConn, listenConn)
+}
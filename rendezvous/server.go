@@ -0,0 +1,251 @@
+package rendezvous
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// 等待拨号方上报打洞结果的最长时间
+const resultTimeout = 30 * time.Second
+
+// 交换双方观察到的地址以协调打洞，打洞失败时在两条登记连接之间中继数据
+type Server struct {
+	stopChan     chan bool
+	stopOnce     sync.Once
+	waitGroup    sync.WaitGroup
+	netListener  net.Listener
+	mutex        sync.Mutex
+	waiting      map[string]*registration
+	LocalAddress string
+}
+
+// 已登记、等待配对的客户端
+type registration struct {
+	connect  net.Conn
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	message  *Message
+	paired   bool // 是否已被配对，由 Server.mutex 保护
+	matched  chan bool
+	released chan bool
+}
+
+func NewServer(localAddress string) *Server {
+	return &Server{
+		stopChan:     make(chan bool),
+		waiting:      make(map[string]*registration),
+		LocalAddress: localAddress,
+	}
+}
+
+func (server *Server) StartServer() error {
+	listener, err := net.Listen("tcp", server.LocalAddress)
+	if err != nil {
+		return err
+	}
+	server.netListener = listener
+	log.Printf("🎉 Rendezvous 服务已开始监听: %s\n", listener.Addr().String())
+
+	server.waitGroup.Add(1)
+	go server.handleServer()
+	return nil
+}
+
+func (server *Server) StopServer() {
+	server.stopOnce.Do(func() {
+		close(server.stopChan)
+		server.netListener.Close()
+
+		// 关闭仍在等待配对的连接
+		server.mutex.Lock()
+		for _, waiting := range server.waiting {
+			waiting.connect.Close()
+		}
+		server.mutex.Unlock()
+
+		server.waitGroup.Wait()
+		log.Println("✅ Rendezvous 服务已关闭")
+	})
+}
+
+func (server *Server) handleServer() {
+	defer server.waitGroup.Done()
+
+	for {
+		connect, err := server.netListener.Accept()
+		if err != nil {
+			return
+		}
+		server.waitGroup.Add(1)
+		go server.handleConnection(connect)
+	}
+}
+
+func (server *Server) handleConnection(connect net.Conn) {
+	defer server.waitGroup.Done()
+
+	reader := bufio.NewReader(connect)
+	writer := bufio.NewWriter(connect)
+	message, err := readMessage(reader)
+	if err != nil || message.Type != RegisterType || message.Token == "" {
+		connect.Close()
+		return
+	}
+	if message.Role != DialRole && message.Role != ListenRole {
+		connect.Close()
+		return
+	}
+	message.PublicAddress = connect.RemoteAddr().String()
+
+	current := &registration{
+		connect:  connect,
+		reader:   reader,
+		writer:   writer,
+		message:  message,
+		matched:  make(chan bool, 1),
+		released: make(chan bool),
+	}
+	key := message.Token + "/" + message.Role
+	peerKey := message.Token + "/" + DialRole
+	if message.Role == DialRole {
+		peerKey = message.Token + "/" + ListenRole
+	}
+
+	server.mutex.Lock()
+	peer, ok := server.waiting[peerKey]
+	if ok {
+		peer.paired = true
+		delete(server.waiting, peerKey)
+	} else {
+		// 同一令牌与角色只保留最新的登记
+		if previous, ok := server.waiting[key]; ok {
+			previous.connect.Close()
+		}
+		server.waiting[key] = current
+	}
+	server.mutex.Unlock()
+
+	if !ok {
+		// 由后到达的一方负责协调，这里只检测等待期间连接是否断开
+		server.waitForPeer(key, current)
+		return
+	}
+
+	peer.matched <- true
+	select {
+	case <-peer.released:
+	case <-server.stopChan:
+		connect.Close()
+		return
+	}
+	if current.message.Role == DialRole {
+		server.coordinate(current, peer)
+	} else {
+		server.coordinate(peer, current)
+	}
+}
+
+// 等待配对期间客户端不会发送数据，读取返回说明连接已断开
+func (server *Server) waitForPeer(key string, current *registration) {
+	watchDone := make(chan error, 1)
+	go func() {
+		_, err := current.reader.Peek(1)
+		watchDone <- err
+	}()
+
+	select {
+	case <-current.matched:
+		// 中断读取，将连接交给负责协调的一方
+		current.connect.SetReadDeadline(time.Now())
+		<-watchDone
+		current.connect.SetReadDeadline(time.Time{})
+		close(current.released)
+	case <-watchDone:
+		server.mutex.Lock()
+		paired := current.paired
+		if !paired && server.waiting[key] == current {
+			delete(server.waiting, key)
+		}
+		server.mutex.Unlock()
+
+		current.connect.Close()
+		if paired {
+			// 已被配对，由协调方处理后续的读写错误
+			<-current.matched
+			close(current.released)
+		}
+	case <-server.stopChan:
+		current.connect.Close()
+	}
+}
+
+// 同时向双方下发对端地址，并根据拨号方的结果决定直连或中继
+func (server *Server) coordinate(dialer, listener *registration) {
+	defer dialer.connect.Close()
+	defer listener.connect.Close()
+	token := dialer.message.Token
+	log.Printf("🎉 令牌 %s 配对成功: %s <-> %s\n", token, dialer.message.PublicAddress, listener.message.PublicAddress)
+
+	for _, pair := range [][2]*registration{{dialer, listener}, {listener, dialer}} {
+		peerMessage := &Message{
+			Type:          PeerType,
+			Token:         token,
+			LocalAddress:  pair[1].message.LocalAddress,
+			PublicAddress: pair[1].message.PublicAddress,
+		}
+		if err := writeMessage(pair[0].writer, peerMessage); err != nil {
+			log.Printf("🤯 下发对端地址失败: %s\n", err.Error())
+			return
+		}
+	}
+
+	dialer.connect.SetReadDeadline(time.Now().Add(resultTimeout))
+	result, err := readMessage(dialer.reader)
+	if err != nil || result.Type != ResultType {
+		log.Printf("🤯 未收到令牌 %s 的打洞结果\n", token)
+		return
+	}
+	dialer.connect.SetReadDeadline(time.Time{})
+
+	// 先通知监听方，监听方失效时拨号方会因连接关闭而得知失败
+	decision := &Message{Type: DecisionType, Token: token, Direct: result.Direct}
+	for _, current := range []*registration{listener, dialer} {
+		if err := writeMessage(current.writer, decision); err != nil {
+			log.Printf("🤯 下发打洞结果失败: %s\n", err.Error())
+			return
+		}
+	}
+	if result.Direct {
+		log.Printf("✅ 令牌 %s 打洞成功\n", token)
+		return
+	}
+
+	// 打洞失败，中继双方之间的数据
+	log.Printf("⚠️ 令牌 %s 打洞失败，改用中继\n", token)
+	var waitGroup sync.WaitGroup
+	waitGroup.Add(2)
+	go relay(&waitGroup, dialer.connect, listener.reader, listener.connect)
+	go relay(&waitGroup, listener.connect, dialer.reader, dialer.connect)
+
+	stopped := make(chan bool)
+	go func() {
+		waitGroup.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-server.stopChan:
+	}
+}
+
+// 将 src 的数据复制到 dst，任意一方结束时关闭两条连接
+func relay(waitGroup *sync.WaitGroup, dst net.Conn, src io.Reader, srcConn net.Conn) {
+	defer waitGroup.Done()
+	io.Copy(dst, src)
+	dst.Close()
+	srcConn.Close()
+}
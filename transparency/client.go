@@ -0,0 +1,223 @@
+package transparency
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+var ErrHeadSignature = errors.New("树头签名无效")
+var ErrEquivocation = errors.New("日志服务给出了相互矛盾的树头")
+var ErrKeyMismatch = errors.New("身份公钥与日志中的绑定不一致")
+
+// 密钥透明日志的客户端，记录已验证的最新树头，之后收到的树头都必须与之一致
+type Client struct {
+	mutex         sync.Mutex
+	IdentityKey   *utils.DiffeHellmanKeyPair
+	LogKey        []byte    // 日志服务签名树头的公钥
+	TrustedHead   *TreeHead // 已验证的最新树头，可以保存下来在重启后继续使用
+	ServerAddress string
+	Timeout       time.Duration
+}
+
+func NewClient(serverAddress string, logKey []byte, identityKey *utils.DiffeHellmanKeyPair) *Client {
+	return &Client{
+		IdentityKey:   identityKey,
+		LogKey:        logKey,
+		ServerAddress: serverAddress,
+		Timeout:       10 * time.Second,
+	}
+}
+
+// 将用户名与自己的身份公钥写入日志，更换身份公钥时需要提供旧的身份密钥
+func (client *Client) Publish(username string, previousKey *utils.DiffeHellmanKeyPair) (uint64, error) {
+	binding := &Binding{
+		Username:    username,
+		IdentityKey: client.IdentityKey.PublicKey.Bytes(),
+		Timestamp:   time.Now().UnixNano(),
+	}
+	signature, err := client.IdentityKey.Sign(binding.signedPayload())
+	if err != nil {
+		return 0, err
+	}
+	binding.Signature = signature
+	if previousKey != nil {
+		if binding.PreviousSignature, err = previousKey.Sign(binding.signedPayload()); err != nil {
+			return 0, err
+		}
+	}
+
+	response, err := client.roundTrip(&Message{Type: AppendType, Binding: binding})
+	if err != nil {
+		return 0, err
+	}
+	if err := client.updateHead(response.Head); err != nil {
+		return 0, err
+	}
+	return response.Index, nil
+}
+
+// 获取并验证最新的树头
+func (client *Client) Head() (*TreeHead, error) {
+	response, err := client.roundTrip(&Message{Type: HeadType})
+	if err != nil {
+		return nil, err
+	}
+	if err := client.updateHead(response.Head); err != nil {
+		return nil, err
+	}
+	return response.Head, nil
+}
+
+// 查询用户名的最新绑定，并验证其包含在与已知树头一致的树中
+func (client *Client) Lookup(username string) (*Binding, error) {
+	response, err := client.roundTrip(&Message{Type: LookupType, Username: username})
+	if err != nil {
+		return nil, err
+	}
+	binding, head := response.Binding, response.Head
+	if binding == nil || binding.Username != username {
+		return nil, ErrInvalidRequest
+	}
+	if err := binding.verify(); err != nil {
+		return nil, err
+	}
+	if err := client.updateHead(head); err != nil {
+		return nil, err
+	}
+	if err := VerifyInclusion(binding.leaf(), response.Index, head.Size, response.Proof, head.RootHash); err != nil {
+		return nil, err
+	}
+	return binding, nil
+}
+
+// 检查从目录或对端获得的身份公钥与日志中的绑定一致
+func (client *Client) VerifyKey(username string, identityKey []byte) error {
+	binding, err := client.Lookup(username)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(binding.IdentityKey, identityKey) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// 检查从其他客户端收到的树头，与已知树头矛盾时说明日志服务向不同客户端展示了不同的日志
+func (client *Client) CheckHead(head *TreeHead) error {
+	return client.updateHead(head)
+}
+
+// 与另一个客户端交换各自已验证的树头，双方都会检查对方的树头
+func (client *Client) ExchangeHeads(connect io.ReadWriter) error {
+	client.mutex.Lock()
+	head := client.TrustedHead
+	client.mutex.Unlock()
+	if head == nil {
+		var err error
+		if head, err = client.Head(); err != nil {
+			return err
+		}
+	}
+
+	headBytes, _ := json.Marshal(head)
+	frame, _ := utils.EncodeMessage(headBytes)
+	written := make(chan error, 1)
+	go func() {
+		_, err := connect.Write(frame)
+		written <- err
+	}()
+	remoteBytes, err := utils.DecodeMessageLimit(bufio.NewReader(connect), maxMessageSize)
+	if err != nil {
+		return err
+	}
+	if err := <-written; err != nil {
+		return err
+	}
+
+	remoteHead := &TreeHead{}
+	if err := json.Unmarshal(remoteBytes, remoteHead); err != nil {
+		return err
+	}
+	return client.CheckHead(remoteHead)
+}
+
+// 验证树头的签名以及与已知树头的一致性，树更大时将其作为新的已知树头
+func (client *Client) updateHead(head *TreeHead) error {
+	if err := head.Verify(client.LogKey); err != nil {
+		return err
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	trusted := client.TrustedHead
+	if trusted == nil {
+		client.TrustedHead = head
+		return nil
+	}
+	if head.Size == trusted.Size {
+		if !bytes.Equal(head.RootHash, trusted.RootHash) {
+			logEquivocation(trusted, head)
+			return ErrEquivocation
+		}
+		return nil
+	}
+
+	older, newer := trusted, head
+	if head.Size < trusted.Size {
+		older, newer = head, trusted
+	}
+	response, err := client.roundTrip(&Message{Type: ConsistencyType, First: older.Size, Size: newer.Size})
+	// 正常的日志服务不会签发超出自身大小的树头，拒绝提供证明同样说明存在分叉
+	if err != nil && err.Error() == ErrInvalidRequest.Error() {
+		logEquivocation(trusted, head)
+		return ErrEquivocation
+	}
+	if err != nil {
+		return err
+	}
+	if err := VerifyConsistency(older.Size, newer.Size, older.RootHash, newer.RootHash, response.Proof); err != nil {
+		logEquivocation(trusted, head)
+		return ErrEquivocation
+	}
+	client.TrustedHead = newer
+	return nil
+}
+
+// 两个签名树头本身就是日志服务作恶的证据
+func logEquivocation(first, second *TreeHead) {
+	firstBytes, _ := json.Marshal(first)
+	secondBytes, _ := json.Marshal(second)
+	log.Printf("🚨 日志服务分叉: %s 与 %s 不一致\n", firstBytes, secondBytes)
+}
+
+// 建立连接发送请求并读取回复
+func (client *Client) roundTrip(request *Message) (*Message, error) {
+	connect, err := net.DialTimeout("tcp", client.ServerAddress, client.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer connect.Close()
+	connect.SetDeadline(time.Now().Add(client.Timeout))
+
+	if err := writeMessage(bufio.NewWriter(connect), request); err != nil {
+		return nil, err
+	}
+	response, err := readMessage(bufio.NewReader(connect))
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response, nil
+}
@@ -0,0 +1,160 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/bits"
+)
+
+var ErrInclusionProof = errors.New("包含证明验证失败")
+var ErrConsistencyProof = errors.New("一致性证明验证失败")
+
+// RFC 6962 的 Merkle 树哈希，叶子与内部节点使用不同的前缀，避免第二原像攻击
+func leafHash(leaf []byte) []byte {
+	digest := sha256.Sum256(append([]byte{0x00}, leaf...))
+	return digest[:]
+}
+
+func nodeHash(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, 0x01)
+	data = append(data, left...)
+	digest := sha256.Sum256(append(data, right...))
+	return digest[:]
+}
+
+// 小于 n 的最大的 2 的幂
+func splitPoint(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+// 计算叶子哈希列表的根哈希
+func rootHash(hashes [][]byte) []byte {
+	switch len(hashes) {
+	case 0:
+		digest := sha256.Sum256(nil)
+		return digest[:]
+	case 1:
+		return hashes[0]
+	}
+	k := splitPoint(len(hashes))
+	return nodeHash(rootHash(hashes[:k]), rootHash(hashes[k:]))
+}
+
+// 第 index 个叶子到根的审计路径
+func inclusionProof(index int, hashes [][]byte) [][]byte {
+	if len(hashes) <= 1 {
+		return nil
+	}
+	k := splitPoint(len(hashes))
+	if index < k {
+		return append(inclusionProof(index, hashes[:k]), rootHash(hashes[k:]))
+	}
+	return append(inclusionProof(index-k, hashes[k:]), rootHash(hashes[:k]))
+}
+
+// 证明前 first 个叶子构成的树是当前树的前缀
+func consistencyProof(first int, hashes [][]byte) [][]byte {
+	if first <= 0 || first >= len(hashes) {
+		return nil
+	}
+	return subProof(first, hashes, true)
+}
+
+func subProof(first int, hashes [][]byte, isComplete bool) [][]byte {
+	if first == len(hashes) {
+		if isComplete {
+			return nil
+		}
+		return [][]byte{rootHash(hashes)}
+	}
+	k := splitPoint(len(hashes))
+	if first <= k {
+		return append(subProof(first, hashes[:k], isComplete), rootHash(hashes[k:]))
+	}
+	return append(subProof(first-k, hashes[k:], false), rootHash(hashes[:k]))
+}
+
+// 按照 RFC 9162 2.1.3.2 验证包含证明
+func VerifyInclusion(leaf []byte, index, size uint64, proof [][]byte, root []byte) error {
+	if index >= size {
+		return ErrInclusionProof
+	}
+	fn, sn := index, size-1
+	hash := leafHash(leaf)
+	for _, sibling := range proof {
+		if sn == 0 {
+			return ErrInclusionProof
+		}
+		if fn&1 == 1 || fn == sn {
+			hash = nodeHash(sibling, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = nodeHash(hash, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(hash, root) {
+		return ErrInclusionProof
+	}
+	return nil
+}
+
+// 按照 RFC 9162 2.1.4.2 验证大小为 first 与 size 的两棵树的一致性证明
+func VerifyConsistency(first, size uint64, firstRoot, root []byte, proof [][]byte) error {
+	switch {
+	case first > size:
+		return ErrConsistencyProof
+	case first == size:
+		if len(proof) != 0 || !bytes.Equal(firstRoot, root) {
+			return ErrConsistencyProof
+		}
+		return nil
+	case first == 0:
+		// 空树是任何树的前缀
+		if len(proof) != 0 {
+			return ErrConsistencyProof
+		}
+		return nil
+	}
+
+	// first 为 2 的幂时，旧树的根是新树的一个完整子树，证明中省略了它
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return ErrConsistencyProof
+	}
+	fn, sn := first-1, size-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	firstHash, secondHash := proof[0], proof[0]
+	for _, sibling := range proof[1:] {
+		if sn == 0 {
+			return ErrConsistencyProof
+		}
+		if fn&1 == 1 || fn == sn {
+			firstHash = nodeHash(sibling, firstHash)
+			secondHash = nodeHash(sibling, secondHash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			secondHash = nodeHash(secondHash, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(firstHash, firstRoot) || !bytes.Equal(secondHash, root) {
+		return ErrConsistencyProof
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package transparency
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// certificate-transparency 项目中 RFC 6962 Merkle 树的测试向量：叶子数据与前 n 个叶子构成的树的根哈希
+var merkleLeaves = []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+var merkleRoots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func TestRootHashVectors(t *testing.T) {
+	if root := hex.EncodeToString(rootHash(nil)); root != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Fatalf("unexpected empty tree root %s", root)
+	}
+	hashes := [][]byte{}
+	for i, leaf := range merkleLeaves {
+		data, err := hex.DecodeString(leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, leafHash(data))
+		if root := hex.EncodeToString(rootHash(hashes)); root != merkleRoots[i] {
+			t.Fatalf("size %d: got root %s, expected %s", i+1, root, merkleRoots[i])
+		}
+	}
+}
+
+// 大小不超过 16 的每一棵树中，每个叶子的包含证明都能通过验证，被篡改的证明都无法通过
+func TestInclusionProof(t *testing.T) {
+	leaves, hashes := testLeaves(16)
+	for size := 1; size <= len(leaves); size++ {
+		root := rootHash(hashes[:size])
+		for index := 0; index < size; index++ {
+			proof := inclusionProof(index, hashes[:size])
+			if err := VerifyInclusion(leaves[index], uint64(index), uint64(size), proof, root); err != nil {
+				t.Fatalf("size %d index %d: valid proof rejected: %v", size, index, err)
+			}
+
+			for _, tampered := range tamperedProofs(proof) {
+				if VerifyInclusion(leaves[index], uint64(index), uint64(size), tampered, root) == nil {
+					t.Fatalf("size %d index %d: tampered proof accepted", size, index)
+				}
+			}
+			if VerifyInclusion(leaves[index], uint64(index), uint64(size), proof, flipped(root)) == nil {
+				t.Fatalf("size %d index %d: wrong root accepted", size, index)
+			}
+			if VerifyInclusion([]byte("other"), uint64(index), uint64(size), proof, root) == nil {
+				t.Fatalf("size %d index %d: wrong leaf accepted", size, index)
+			}
+			if VerifyInclusion(leaves[index], uint64(size), uint64(size), proof, root) == nil {
+				t.Fatalf("size %d index %d: index out of range accepted", size, index)
+			}
+			if size > 1 && VerifyInclusion(leaves[index], uint64((index+1)%size), uint64(size), proof, root) == nil {
+				t.Fatalf("size %d index %d: wrong index accepted", size, index)
+			}
+		}
+	}
+}
+
+// 任意 first <= size <= 16 的一致性证明都能通过验证，被篡改的证明都无法通过
+func TestConsistencyProof(t *testing.T) {
+	_, hashes := testLeaves(16)
+	for size := 1; size <= len(hashes); size++ {
+		root := rootHash(hashes[:size])
+		for first := 0; first <= size; first++ {
+			firstRoot := rootHash(hashes[:first])
+			proof := consistencyProof(first, hashes[:size])
+			if err := VerifyConsistency(uint64(first), uint64(size), firstRoot, root, proof); err != nil {
+				t.Fatalf("first %d size %d: valid proof rejected: %v", first, size, err)
+			}
+			if first == 0 {
+				// 空树是任何树的前缀，不需要证明
+				continue
+			}
+
+			for _, tampered := range tamperedProofs(proof) {
+				if VerifyConsistency(uint64(first), uint64(size), firstRoot, root, tampered) == nil {
+					t.Fatalf("first %d size %d: tampered proof accepted", first, size)
+				}
+			}
+			if VerifyConsistency(uint64(first), uint64(size), flipped(firstRoot), root, proof) == nil {
+				t.Fatalf("first %d size %d: wrong first root accepted", first, size)
+			}
+			if VerifyConsistency(uint64(first), uint64(size), firstRoot, flipped(root), proof) == nil {
+				t.Fatalf("first %d size %d: wrong root accepted", first, size)
+			}
+			if VerifyConsistency(uint64(size+1), uint64(size), firstRoot, root, proof) == nil {
+				t.Fatalf("first %d size %d: first larger than size accepted", first, size)
+			}
+		}
+	}
+}
+
+func testLeaves(count int) ([][]byte, [][]byte) {
+	leaves := [][]byte{}
+	hashes := [][]byte{}
+	for i := range count {
+		leaf := []byte(fmt.Sprintf("leaf %d", i))
+		leaves = append(leaves, leaf)
+		hashes = append(hashes, leafHash(leaf))
+	}
+	return leaves, hashes
+}
+
+// 依次翻转每个哈希中的一个字节，并截短与加长证明
+func tamperedProofs(proof [][]byte) [][][]byte {
+	tampered := [][][]byte{}
+	for i := range proof {
+		modified := append([][]byte(nil), proof...)
+		modified[i] = flipped(proof[i])
+		tampered = append(tampered, modified)
+	}
+	if len(proof) > 0 {
+		tampered = append(tampered, proof[:len(proof)-1])
+	}
+	extended := append(append([][]byte(nil), proof...), leafHash([]byte("extra")))
+	return append(tampered, extended)
+}
+
+func flipped(hash []byte) []byte {
+	modified := bytes.Clone(hash)
+	modified[0] ^= 1
+	return modified
+}
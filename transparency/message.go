@@ -0,0 +1,112 @@
+package transparency
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	AppendType      = "append"      // 向日志追加用户名与身份公钥的绑定
+	HeadType        = "head"        // 获取最新的签名树头
+	LookupType      = "lookup"      // 查询用户名的最新绑定及其包含证明
+	ConsistencyType = "consistency" // 获取两个树大小之间的一致性证明
+	ResultType      = "result"      // 服务端对请求的回复
+)
+
+// 单条信息的最大长度，证明的长度只随树大小对数增长
+const maxMessageSize = 64 << 10
+
+// 日志中的一个叶子：用户名与身份公钥的绑定，由该身份密钥签名；
+// 更换身份公钥时还需要旧身份密钥的签名
+type Binding struct {
+	Username          string
+	IdentityKey       []byte
+	Timestamp         int64
+	Signature         []byte
+	PreviousSignature []byte `json:",omitempty"`
+}
+
+// 签名树头：日志服务对某一时刻树大小与根哈希的承诺
+type TreeHead struct {
+	Size      uint64
+	RootHash  []byte
+	Timestamp int64
+	Signature []byte
+}
+
+// 客户端与日志服务之间交换的信息
+type Message struct {
+	Type     string
+	Binding  *Binding
+	Username string
+	Index    uint64
+	First    uint64 // 一致性证明中旧树的大小
+	Size     uint64 // 一致性证明中新树的大小
+	Proof    [][]byte
+	Head     *TreeHead
+	Error    string
+}
+
+// 绑定中被签名的内容，新旧身份密钥签名相同的内容
+func (binding *Binding) signedPayload() []byte {
+	payload := []byte("DoubleRatchetDemo transparency binding")
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(binding.Username)))
+	payload = append(payload, binding.Username...)
+	payload = append(payload, binding.IdentityKey...)
+	return binary.BigEndian.AppendUint64(payload, uint64(binding.Timestamp))
+}
+
+// 叶子的编码，包含证明基于该编码计算
+func (binding *Binding) leaf() []byte {
+	leaf, _ := json.Marshal(binding)
+	return leaf
+}
+
+func (binding *Binding) verify() error {
+	if _, err := utils.BytesToPublicKey(binding.IdentityKey); err != nil {
+		return err
+	}
+	if !utils.XEdDSAVerify(binding.IdentityKey, binding.signedPayload(), binding.Signature) {
+		return ErrSignature
+	}
+	return nil
+}
+
+func (head *TreeHead) signedPayload() []byte {
+	payload := []byte("DoubleRatchetDemo transparency tree head")
+	payload = binary.BigEndian.AppendUint64(payload, head.Size)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(head.Timestamp))
+	return append(payload, head.RootHash...)
+}
+
+// 使用日志服务的公钥验证树头的签名
+func (head *TreeHead) Verify(logKey []byte) error {
+	if head == nil || !utils.XEdDSAVerify(logKey, head.signedPayload(), head.Signature) {
+		return ErrHeadSignature
+	}
+	return nil
+}
+
+func writeMessage(writer *bufio.Writer, message *Message) error {
+	messageBytes, _ := json.Marshal(message)
+	messageBytes, _ = utils.EncodeMessage(messageBytes)
+	if _, err := writer.Write(messageBytes); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func readMessage(reader *bufio.Reader) (*Message, error) {
+	messageBytes, err := utils.DecodeMessageLimit(reader, maxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+	message := &Message{}
+	if err := json.Unmarshal(messageBytes, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
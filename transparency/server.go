@@ -0,0 +1,221 @@
+package transparency
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/reagin/double_ratchet/utils"
+)
+
+const (
+	maxClockSkew   = 5 * time.Minute  // 请求时间戳与服务端时间允许的最大偏差
+	requestTimeout = 10 * time.Second // 读取请求与写入回复的最长时间
+)
+
+var ErrUsername = errors.New("用户名不能为空且不能超过 64 字节")
+var ErrUsernameTaken = errors.New("更换身份公钥需要旧身份密钥的签名")
+var ErrNotFound = errors.New("日志中没有该用户名的绑定")
+var ErrSignature = errors.New("签名验证失败")
+var ErrStaleRequest = errors.New("请求已过期或被重放")
+var ErrInvalidRequest = errors.New("无效的请求")
+
+// 密钥透明日志：只追加的 Merkle 树，记录用户名与身份公钥的绑定，
+// 每次追加后签发新的树头，并按需提供包含证明与一致性证明
+type Server struct {
+	stopChan     chan bool
+	stopOnce     sync.Once
+	waitGroup    sync.WaitGroup
+	netListener  net.Listener
+	mutex        sync.Mutex
+	hashes       [][]byte            // 所有叶子的哈希
+	latest       map[string]uint64   // 用户名对应的最新绑定所在的叶子序号
+	bindings     map[uint64]*Binding // 各用户名最新绑定的内容
+	head         *TreeHead
+	Store        Store
+	LogKey       *utils.DiffeHellmanKeyPair // 签名树头使用的密钥
+	LocalAddress string
+}
+
+func NewServer(localAddress string, store Store, logKey *utils.DiffeHellmanKeyPair) *Server {
+	return &Server{
+		stopChan:     make(chan bool),
+		latest:       make(map[string]uint64),
+		bindings:     make(map[uint64]*Binding),
+		Store:        store,
+		LogKey:       logKey,
+		LocalAddress: localAddress,
+	}
+}
+
+// 从存储中重建 Merkle 树后开始监听
+func (server *Server) StartServer() error {
+	leaves, err := server.Store.Leaves()
+	if err != nil {
+		return err
+	}
+	for _, leaf := range leaves {
+		binding := &Binding{}
+		if err := json.Unmarshal(leaf, binding); err != nil {
+			return err
+		}
+		server.insert(binding, leaf)
+	}
+	if err := server.signHead(); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", server.LocalAddress)
+	if err != nil {
+		return err
+	}
+	server.netListener = listener
+	log.Printf("🎉 密钥透明日志已开始监听: %s，共 %d 条记录\n", listener.Addr().String(), len(server.hashes))
+
+	server.waitGroup.Add(1)
+	go server.handleServer()
+	return nil
+}
+
+func (server *Server) StopServer() {
+	server.stopOnce.Do(func() {
+		close(server.stopChan)
+		server.netListener.Close()
+		server.waitGroup.Wait()
+		log.Println("✅ 密钥透明日志已关闭")
+	})
+}
+
+func (server *Server) handleServer() {
+	defer server.waitGroup.Done()
+
+	for {
+		connect, err := server.netListener.Accept()
+		if err != nil {
+			return
+		}
+		server.waitGroup.Add(1)
+		go server.handleConnection(connect)
+	}
+}
+
+// 每条连接处理一个请求
+func (server *Server) handleConnection(connect net.Conn) {
+	defer server.waitGroup.Done()
+	defer connect.Close()
+
+	connect.SetDeadline(time.Now().Add(requestTimeout))
+	reader := bufio.NewReader(connect)
+	writer := bufio.NewWriter(connect)
+	request, err := readMessage(reader)
+	if err != nil {
+		return
+	}
+
+	server.mutex.Lock()
+	response := &Message{Type: ResultType}
+	switch request.Type {
+	case AppendType:
+		response.Index, err = server.handleAppend(request.Binding)
+	case HeadType:
+	case LookupType:
+		response.Binding, response.Index, response.Proof, err = server.handleLookup(request.Username)
+	case ConsistencyType:
+		if request.First > request.Size || request.Size > uint64(len(server.hashes)) {
+			err = ErrInvalidRequest
+			break
+		}
+		response.Proof = consistencyProof(int(request.First), server.hashes[:request.Size])
+	default:
+		err = ErrInvalidRequest
+	}
+	response.Head = server.head
+	server.mutex.Unlock()
+
+	if err != nil {
+		response.Error = err.Error()
+	}
+	writeMessage(writer, response)
+}
+
+// 校验绑定后追加到日志，用户名已绑定其他身份公钥时需要旧密钥的签名，调用方需持有 server.mutex
+func (server *Server) handleAppend(binding *Binding) (uint64, error) {
+	if binding == nil {
+		return 0, ErrInvalidRequest
+	}
+	if binding.Username == "" || len(binding.Username) > 64 {
+		return 0, ErrUsername
+	}
+	if err := binding.verify(); err != nil {
+		return 0, err
+	}
+	if time.Since(time.Unix(0, binding.Timestamp)).Abs() > maxClockSkew {
+		return 0, ErrStaleRequest
+	}
+	if index, ok := server.latest[binding.Username]; ok {
+		previous := server.bindings[index]
+		if binding.Timestamp <= previous.Timestamp {
+			return 0, ErrStaleRequest
+		}
+		if bytes.Equal(previous.IdentityKey, binding.IdentityKey) {
+			// 绑定没有变化，返回已有的叶子
+			return index, nil
+		}
+		if !utils.XEdDSAVerify(previous.IdentityKey, binding.signedPayload(), binding.PreviousSignature) {
+			return 0, ErrUsernameTaken
+		}
+	}
+
+	leaf := binding.leaf()
+	if err := server.Store.Append(leaf); err != nil {
+		return 0, err
+	}
+	index := server.insert(binding, leaf)
+	if err := server.signHead(); err != nil {
+		return 0, err
+	}
+	log.Printf("🌲 用户名 %s 的绑定已写入日志 #%d\n", binding.Username, index)
+	return index, nil
+}
+
+// 返回用户名的最新绑定以及相对于当前树头的包含证明，调用方需持有 server.mutex
+func (server *Server) handleLookup(username string) (*Binding, uint64, [][]byte, error) {
+	index, ok := server.latest[username]
+	if !ok {
+		return nil, 0, nil, ErrNotFound
+	}
+	return server.bindings[index], index, inclusionProof(int(index), server.hashes), nil
+}
+
+// 将叶子加入树中并返回其序号，调用方需持有 server.mutex
+func (server *Server) insert(binding *Binding, leaf []byte) uint64 {
+	index := uint64(len(server.hashes))
+	server.hashes = append(server.hashes, leafHash(leaf))
+	if previous, ok := server.latest[binding.Username]; ok {
+		delete(server.bindings, previous)
+	}
+	server.latest[binding.Username] = index
+	server.bindings[index] = binding
+	return index
+}
+
+// 为当前的树签发新的树头，调用方需持有 server.mutex
+func (server *Server) signHead() error {
+	head := &TreeHead{
+		Size:      uint64(len(server.hashes)),
+		RootHash:  rootHash(server.hashes),
+		Timestamp: time.Now().UnixNano(),
+	}
+	signature, err := server.LogKey.Sign(head.signedPayload())
+	if err != nil {
+		return err
+	}
+	head.Signature = signature
+	server.head = head
+	return nil
+}
@@ -0,0 +1,91 @@
+package transparency
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"os"
+	"sync"
+)
+
+// 日志叶子的存储后端，只允许追加
+type Store interface {
+	Append(leaf []byte) error
+	Leaves() ([][]byte, error)
+}
+
+// 保存在内存中的存储后端，服务重启后数据丢失
+type MemoryStore struct {
+	mutex  sync.Mutex
+	leaves [][]byte
+}
+
+// 每个叶子以 base64 编码保存为文件中的一行，写入后立即同步到磁盘
+type FileStore struct {
+	mutex sync.Mutex
+	Path  string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (store *MemoryStore) Append(leaf []byte) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.leaves = append(store.leaves, leaf)
+	return nil
+}
+
+func (store *MemoryStore) Leaves() ([][]byte, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return append([][]byte(nil), store.leaves...), nil
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+func (store *FileStore) Append(leaf []byte) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	file, err := os.OpenFile(store.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(base64.StdEncoding.EncodeToString(leaf) + "\n"); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func (store *FileStore) Leaves() ([][]byte, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	file, err := os.Open(store.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	leaves := [][]byte{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		leaf, err := base64.StdEncoding.DecodeString(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, leaf)
+	}
+	return leaves, scanner.Err()
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+
+	"filippo.io/edwards25519/field"
+)
+
+// CPace 的域分隔标识，取自 draft-irtf-cfrg-cpace 的 X25519 实例
+const cpaceDSI = "CPace255"
+
+// SHA-512 的分组长度，生成元字符串以零填充使口令位于独立的分组中
+const cpaceHashBlockSize = 128
+
+var ErrCPaceShare = errors.New("CPace 对端公开值无效")
+
+// 基于 X25519 的 CPace 平衡 PAKE，双方使用相同的口令与会话标识，
+// 只有口令一致时才能得到相同的中间会话密钥 (ISK)
+type CPace struct {
+	sid         []byte
+	isInitiator bool
+	privateKey  *ecdh.PrivateKey
+	localShare  []byte
+}
+
+// 由口令与会话标识计算生成元并生成本方的公开值，sid 应由双方此前的握手记录得到
+func NewCPace(password, sid []byte, isInitiator bool) (*CPace, error) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newCPace(password, sid, isInitiator, privateKey)
+}
+
+// 使用指定的私钥创建 CPace，测试向量中使用固定的私钥
+func newCPace(password, sid []byte, isInitiator bool, privateKey *ecdh.PrivateKey) (*CPace, error) {
+	generator, err := ecdh.X25519().NewPublicKey(cpaceGenerator(password, sid))
+	if err != nil {
+		return nil, err
+	}
+	localShare, err := privateKey.ECDH(generator)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CPace{
+		sid:         append([]byte(nil), sid...),
+		isInitiator: isInitiator,
+		privateKey:  privateKey,
+		localShare:  localShare,
+	}, nil
+}
+
+// 发送给对端的公开值
+func (cp *CPace) Share() []byte {
+	return append([]byte(nil), cp.localShare...)
+}
+
+// 使用对端公开值计算中间会话密钥，对端公开值为低阶点时返回错误
+func (cp *CPace) Finish(remoteShare []byte) ([]byte, error) {
+	remoteKey, err := BytesToPublicKey(remoteShare)
+	if err != nil {
+		return nil, ErrCPaceShare
+	}
+	sharedSecret, err := cp.privateKey.ECDH(remoteKey)
+	if err != nil {
+		return nil, ErrCPaceShare
+	}
+
+	// 公开值按发起方、响应方的顺序写入，双方得到相同的记录
+	initiatorShare, responderShare := cp.localShare, remoteShare
+	if !cp.isInitiator {
+		initiatorShare, responderShare = remoteShare, cp.localShare
+	}
+	digest := sha512.New()
+	digest.Write(lvCat([]byte(cpaceDSI+"_ISK"), cp.sid, sharedSecret))
+	digest.Write(lvCat(initiatorShare, nil))
+	digest.Write(lvCat(responderShare, nil))
+	return digest.Sum(nil)[:32], nil
+}
+
+// 计算口令生成元：哈希生成元字符串后经 Elligator2 映射到曲线上
+func cpaceGenerator(password, sid []byte) []byte {
+	zeroPadding := cpaceHashBlockSize - 1 - len(prependLen(password)) - len(prependLen([]byte(cpaceDSI)))
+	if zeroPadding < 0 {
+		zeroPadding = 0
+	}
+	digest := sha512.Sum512(lvCat([]byte(cpaceDSI), password, make([]byte, zeroPadding), nil, sid))
+
+	// SetBytes 会忽略最高位
+	r, _ := new(field.Element).SetBytes(digest[:32])
+	return elligator2Map(r).Bytes()
+}
+
+// 在数据前添加 LEB128 编码的长度
+func prependLen(data []byte) []byte {
+	return append(binary.AppendUvarint(nil, uint64(len(data))), data...)
+}
+
+func lvCat(items ...[]byte) []byte {
+	result := []byte{}
+	for _, item := range items {
+		result = append(result, prependLen(item)...)
+	}
+	return result
+}
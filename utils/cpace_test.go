@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/sha512"
+	"encoding/hex"
+	"math/big"
+	"slices"
+	"testing"
+)
+
+// 测试向量的输入取自 draft-irtf-cfrg-cpace 的 X25519 测试向量：口令 "Password"、
+// 会话标识 7e4b4791d6a8ef019b936c79fb7f2c57 与固定的标量。本实现不携带 CI 与 AD，
+// 因此期望值按照草案的定义独立计算：生成元字符串逐字节写出，Elligator2 使用大整数实现
+var cpaceVector = struct {
+	password string
+	sid      string
+	ya       string
+	yb       string
+}{
+	password: "Password",
+	sid:      "7e4b4791d6a8ef019b936c79fb7f2c57",
+	ya:       "21b4f4bd9e64ed355c3eb676a28ebedaf6d8f17bdc365995b319097153044080",
+	yb:       "848b0779ff415f0af4ea14df9dd1d3c29ac41d836c7808896c4eba19c51ac40a",
+}
+
+func cpaceHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// RFC 9380 第 6.7.1 节的 Elligator2 映射 (Z = 2)，返回 Montgomery 曲线上的 u 坐标
+func elligator2Reference(input []byte) []byte {
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	a := big.NewInt(486662)
+
+	// 小端序解码并忽略最高位
+	encoded := slices.Clone(input[:32])
+	encoded[31] &= 0x7f
+	slices.Reverse(encoded)
+	u := new(big.Int).SetBytes(encoded)
+
+	// x1 = -A / (1 + 2u²)，分母为零时 x1 = -A
+	denominator := new(big.Int).Mul(u, u)
+	denominator.Lsh(denominator, 1).Add(denominator, big.NewInt(1)).Mod(denominator, p)
+	x1 := new(big.Int).Neg(a)
+	if denominator.Sign() != 0 {
+		x1.Mul(x1, new(big.Int).ModInverse(denominator, p))
+	}
+	x1.Mod(x1, p)
+
+	// gx1 = x1³ + A·x1² + x1，由欧拉判别法判断是否为平方数
+	gx1 := new(big.Int).Add(x1, a)
+	gx1.Mul(gx1, x1).Add(gx1, big.NewInt(1)).Mul(gx1, x1).Mod(gx1, p)
+	exponent := new(big.Int).Rsh(new(big.Int).Sub(p, big.NewInt(1)), 1)
+	x := x1
+	if legendre := new(big.Int).Exp(gx1, exponent, p); legendre.Cmp(big.NewInt(1)) > 0 {
+		x = new(big.Int).Neg(x1)
+		x.Sub(x, a).Mod(x, p)
+	}
+
+	output := make([]byte, 32)
+	x.FillBytes(output)
+	slices.Reverse(output)
+	return output
+}
+
+func TestCPaceGeneratorVector(t *testing.T) {
+	sid := cpaceHex(t, cpaceVector.sid)
+
+	// generator_string = lv_cat(DSI, PRS, zero_bytes(109), CI, sid)，CI 为空
+	generatorString := slices.Concat(
+		[]byte{0x08}, []byte("CPace255"),
+		[]byte{0x08}, []byte(cpaceVector.password),
+		[]byte{0x6d}, make([]byte, 109),
+		[]byte{0x00},
+		[]byte{0x10}, sid,
+	)
+	// DSI、口令与填充恰好占满一个 SHA-512 分组
+	if len(generatorString)-len(sid)-2 != cpaceHashBlockSize {
+		t.Fatalf("生成元字符串长度 %d", len(generatorString))
+	}
+	digest := sha512.Sum512(generatorString)
+	expected := elligator2Reference(digest[:32])
+
+	generator := cpaceGenerator([]byte(cpaceVector.password), sid)
+	if !bytes.Equal(generator, expected) {
+		t.Fatalf("生成元\n得到 %x\n期望 %x", generator, expected)
+	}
+	if bytes.Equal(generator, cpaceGenerator([]byte("password"), sid)) {
+		t.Fatal("不同的口令得到了相同的生成元")
+	}
+	if bytes.Equal(generator, cpaceGenerator([]byte(cpaceVector.password), sid[1:])) {
+		t.Fatal("不同的会话标识得到了相同的生成元")
+	}
+}
+
+func TestCPaceISKVector(t *testing.T) {
+	sid := cpaceHex(t, cpaceVector.sid)
+	ya, err := ecdh.X25519().NewPrivateKey(cpaceHex(t, cpaceVector.ya))
+	if err != nil {
+		t.Fatal(err)
+	}
+	yb, err := ecdh.X25519().NewPrivateKey(cpaceHex(t, cpaceVector.yb))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initiator, err := newCPace([]byte(cpaceVector.password), sid, true, ya)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, err := newCPace([]byte(cpaceVector.password), sid, false, yb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initiatorISK, err := initiator.Finish(responder.Share())
+	if err != nil {
+		t.Fatal(err)
+	}
+	responderISK, err := responder.Finish(initiator.Share())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ISK = H(lv_cat(DSI || "_ISK", sid, K) || lv_cat(Ya, ADa) || lv_cat(Yb, ADb))，AD 为空
+	remoteKey, err := ecdh.X25519().NewPublicKey(responder.Share())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedSecret, err := ya.ECDH(remoteKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha512.Sum512(slices.Concat(
+		[]byte{0x0c}, []byte("CPace255_ISK"),
+		[]byte{0x10}, sid,
+		[]byte{0x20}, sharedSecret,
+		[]byte{0x20}, initiator.Share(), []byte{0x00},
+		[]byte{0x20}, responder.Share(), []byte{0x00},
+	))
+
+	if !bytes.Equal(initiatorISK, digest[:32]) {
+		t.Fatalf("ISK\n得到 %x\n期望 %x", initiatorISK, digest[:32])
+	}
+	if !bytes.Equal(initiatorISK, responderISK) {
+		t.Fatal("双方的 ISK 不一致")
+	}
+}
+
+func TestCPaceAgreement(t *testing.T) {
+	sid := []byte("transcript")
+	for _, test := range []struct {
+		name          string
+		initiatorCode string
+		responderCode string
+		isAgreed      bool
+	}{
+		{"相同的口令", "7-guitar-revenge", "7-guitar-revenge", true},
+		{"不同的口令", "7-guitar-revenge", "8-guitar-revenge", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			initiator, err := NewCPace([]byte(test.initiatorCode), sid, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			responder, err := NewCPace([]byte(test.responderCode), sid, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			initiatorISK, err := initiator.Finish(responder.Share())
+			if err != nil {
+				t.Fatal(err)
+			}
+			responderISK, err := responder.Finish(initiator.Share())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(initiatorISK, responderISK) != test.isAgreed {
+				t.Fatalf("ISK 是否一致: %v，期望 %v", !test.isAgreed, test.isAgreed)
+			}
+		})
+	}
+}
+
+// 低阶点作为对端公开值时必须拒绝，否则共享密钥与口令无关
+func TestCPaceRejectsLowOrderShare(t *testing.T) {
+	cpace, err := NewCPace([]byte("7-guitar-revenge"), []byte("transcript"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cpace.Finish(make([]byte, 32)); err != ErrCPaceShare {
+		t.Fatalf("低阶公开值返回 %v，期望 %v", err, ErrCPaceShare)
+	}
+}
+
+func TestNormalizePairingCode(t *testing.T) {
+	if code := NormalizePairingCode(" 7 Guitar-REVENGE\t"); code != "7-guitar-revenge" {
+		t.Fatalf("规范化结果 %q", code)
+	}
+}
@@ -1,15 +1,31 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/ecdh"
 	"crypto/hkdf"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 )
 
+var ErrInvalidPublicKey = errors.New("无效的公钥: 长度错误或为低阶点")
+
+// Curve25519 上阶数较小的点的 u 坐标 (含非规范编码)，与其计算 ECDH 得到的结果可被预测
+var lowOrderPoints = [][]byte{
+	mustDecodeHex("0000000000000000000000000000000000000000000000000000000000000000"),
+	mustDecodeHex("0100000000000000000000000000000000000000000000000000000000000000"),
+	mustDecodeHex("e0eb7a7c3b41b8ae1656e3faf19fc46ada098deb9c32b1fd866205165f49b800"),
+	mustDecodeHex("5f9c95bca3508c24b1d0b1559c83ef5b04445cc4581c8e86d8224eddd09f1157"),
+	mustDecodeHex("ecffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f"),
+	mustDecodeHex("edffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f"),
+	mustDecodeHex("eeffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f"),
+}
+
 // 密钥派生函数，对于 RootChain 的派生应指定 salt 为 DH 计算的输出
 func DevirateChainKey(key []byte, salt []byte) (leftKey []byte, rightKey []byte) {
 	derivatedKey, err := hkdf.Key(sha256.New, key, salt, "", 64)
@@ -64,11 +80,32 @@ func DecryptAESGCM(key, nonce, ciphertext []byte) (plaintext []byte, err error)
 	return plaintext, nil
 }
 
-// 将 []byte 格式的公钥转换为 *ecdh.PublicKey
-func BytesToPublicKey(pubKey []byte) *ecdh.PublicKey {
+// 将 []byte 格式的公钥转换为 *ecdh.PublicKey，拒绝长度错误的数据与低阶点
+func BytesToPublicKey(pubKey []byte) (*ecdh.PublicKey, error) {
+	if len(pubKey) != 32 {
+		return nil, ErrInvalidPublicKey
+	}
+
+	// X25519 忽略 u 坐标的最高位，比较前同样将其清除
+	masked := append([]byte(nil), pubKey...)
+	masked[31] &= 0x7f
+	for _, point := range lowOrderPoints {
+		if bytes.Equal(masked, point) {
+			return nil, ErrInvalidPublicKey
+		}
+	}
+
 	publicKey, err := ecdh.X25519().NewPublicKey(pubKey)
 	if err != nil {
-		return nil
+		return nil, ErrInvalidPublicKey
+	}
+	return publicKey, nil
+}
+
+func mustDecodeHex(data string) []byte {
+	decoded, err := hex.DecodeString(data)
+	if err != nil {
+		panic(err)
 	}
-	return publicKey
+	return decoded
 }
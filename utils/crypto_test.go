@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"testing"
+)
+
+func TestBytesToPublicKey(t *testing.T) {
+	type publicKeyTest struct {
+		name    string
+		pubKey  []byte
+		isValid bool
+	}
+	tests := []publicKeyTest{
+		{"有效公钥", NewDiffeHellmanKeyPair().PublicKey.Bytes(), true},
+		{"长度不足", make([]byte, 31), false},
+		{"长度过长", make([]byte, 33), false},
+		{"空公钥", nil, false},
+	}
+	for _, point := range lowOrderPoints {
+		tests = append(tests, publicKeyTest{"低阶点 " + hex.EncodeToString(point), point, false})
+
+		// X25519 忽略最高位，最高位置 1 的编码表示同一个点
+		highBit := bytes.Clone(point)
+		highBit[31] |= 0x80
+		tests = append(tests, publicKeyTest{"最高位置 1 的低阶点 " + hex.EncodeToString(highBit), highBit, false})
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			publicKey, err := BytesToPublicKey(test.pubKey)
+			if test.isValid {
+				if err != nil || !bytes.Equal(publicKey.Bytes(), test.pubKey) {
+					t.Fatalf("有效公钥返回 %v", err)
+				}
+				return
+			}
+			if err != ErrInvalidPublicKey {
+				t.Fatalf("返回 %v，期望 %v", err, ErrInvalidPublicKey)
+			}
+		})
+	}
+}
+
+// 列表中的每个点与任意私钥的 ECDH 结果都为全零，crypto/ecdh 会返回错误，
+// 因此绕过 BytesToPublicKey 的低阶点也会在 ECDH 时被发现
+func TestLowOrderPointsECDH(t *testing.T) {
+	privateKey := NewDiffeHellmanKeyPair().PrivateKey
+	for _, point := range lowOrderPoints {
+		for _, highBit := range []byte{0x00, 0x80} {
+			encoding := bytes.Clone(point)
+			encoding[31] |= highBit
+			publicKey, err := ecdh.X25519().NewPublicKey(encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := privateKey.ECDH(publicKey); err == nil {
+				t.Fatalf("与 %x 的 ECDH 没有返回错误", encoding)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+
+	"filippo.io/edwards25519"
+	"filippo.io/edwards25519/field"
+)
+
+// Curve25519 的 Montgomery 曲线参数 A = 486662
+var curveA = new(field.Element).Mult32(new(field.Element).One(), 486662)
+
+// Elligator2 映射 (RFC 9380)，将任意域元素映射为 Curve25519 上点的 u 坐标，
+// 映射结果与离散对数无关，可作为 CPace 的口令生成元
+func elligator2Map(r *field.Element) *field.Element {
+	one := new(field.Element).One()
+
+	// tv1 = 2r²，当 tv1 = -1 时置为 0 以避免除零
+	tv1 := new(field.Element).Square(r)
+	tv1.Add(tv1, tv1)
+	minusOne := new(field.Element).Negate(one)
+	tv1.Select(new(field.Element).Zero(), tv1, tv1.Equal(minusOne))
+
+	// x1 = -A / (1 + 2r²)
+	x1 := new(field.Element).Add(tv1, one)
+	x1.Invert(x1)
+	x1.Multiply(x1, curveA)
+	x1.Negate(x1)
+
+	// gx1 = x1³ + A·x1² + x1，为平方数时 x1 在曲线上，否则取 x2 = -x1 - A
+	gx1 := new(field.Element).Add(x1, curveA)
+	gx1.Multiply(gx1, x1)
+	gx1.Add(gx1, one)
+	gx1.Multiply(gx1, x1)
+	_, isSquare := new(field.Element).SqrtRatio(gx1, one)
+
+	x2 := new(field.Element).Add(x1, curveA)
+	x2.Negate(x2)
+
+	return new(field.Element).Select(x1, x2, isSquare)
+}
+
+// Curve25519 上阶为 8 的点 (Edwards 形式)，用于给临时公钥加上随机的低阶分量
+var lowOrderPoint = mustLowOrderPoint("c7176a703d4dd84fba3c0b760d10670f2a2053fa2c39ccc64ec7fd7792ac037a")
+
+// 公钥可以用 Elligator2 表示的临时密钥对，Representative 与均匀随机的 32 字节无法区分
+type ElligatorKeyPair struct {
+	PrivateKey     *ecdh.PrivateKey
+	Representative []byte
+}
+
+func mustLowOrderPoint(encoding string) *edwards25519.Point {
+	point, err := new(edwards25519.Point).SetBytes(mustDecodeHex(encoding))
+	if err != nil {
+		panic(err)
+	}
+	return point
+}
+
+// 约一半的公钥可以用 Elligator2 表示，不断生成直到得到可表示的公钥；
+// 公钥加上随机的低阶分量，否则所有公钥都位于素数阶子群中，可以被区分出来，
+// X25519 的私钥是 8 的倍数，低阶分量不影响 DH 结果。
+// 低阶分量、r 的符号与最高位使用单独的随机数，不能取自私钥，否则编码会泄露私钥的比特
+func NewElligatorKeyPair() (*ElligatorKeyPair, error) {
+	seed := make([]byte, 32)
+	tweak := make([]byte, 1)
+	for {
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
+		if _, err := rand.Read(tweak); err != nil {
+			return nil, err
+		}
+		scalar, err := new(edwards25519.Scalar).SetBytesWithClamping(seed)
+		if err != nil {
+			return nil, err
+		}
+		point := new(edwards25519.Point).ScalarBaseMult(scalar)
+		for i := tweak[0] & 7; i > 0; i-- {
+			point.Add(point, lowOrderPoint)
+		}
+
+		u, err := new(field.Element).SetBytes(point.BytesMontgomery())
+		if err != nil {
+			return nil, err
+		}
+		r, ok := elligator2Inverse(u)
+		if !ok {
+			continue
+		}
+		// 随机选择 r 或 -r，并随机设置不参与编码的最高位
+		r.Select(new(field.Element).Negate(r), r, int(tweak[0]>>3&1))
+		representative := r.Bytes()
+		representative[31] |= tweak[0] & 0x80
+
+		privateKey, err := ecdh.X25519().NewPrivateKey(seed)
+		if err != nil {
+			return nil, err
+		}
+		return &ElligatorKeyPair{PrivateKey: privateKey, Representative: representative}, nil
+	}
+}
+
+// 由 Representative 还原出 X25519 公钥
+func RepresentativeToPublicKey(representative []byte) (*ecdh.PublicKey, error) {
+	if len(representative) != 32 {
+		return nil, ErrInvalidPublicKey
+	}
+	// field.Element.SetBytes 忽略最高位
+	r, err := new(field.Element).SetBytes(representative)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPublicKey(elligator2Map(r).Bytes())
+}
+
+// elligator2Map 的逆映射：u ≠ -A 且 -2u(u+A) 为平方数时存在 r 使得 map(r) = u，
+// 此时 r² = -u / (2(u+A))
+func elligator2Inverse(u *field.Element) (*field.Element, bool) {
+	uPlusA := new(field.Element).Add(u, curveA)
+	if uPlusA.Equal(new(field.Element).Zero()) == 1 {
+		return nil, false
+	}
+	numerator := new(field.Element).Negate(u)
+	denominator := new(field.Element).Add(uPlusA, uPlusA)
+	r, isSquare := new(field.Element).SqrtRatio(numerator, denominator)
+	return r, isSquare == 1
+}
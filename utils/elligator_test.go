@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"filippo.io/edwards25519/field"
+)
+
+// 映射结果与 RFC 9380 的参考实现一致，可逆的点经过逆映射再映射后得到同一个点
+func TestElligatorRoundTrip(t *testing.T) {
+	inverted := 0
+	for range 256 {
+		input := make([]byte, 32)
+		rand.Read(input)
+		r, err := new(field.Element).SetBytes(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u := elligator2Map(r)
+		if !bytes.Equal(u.Bytes(), elligator2Reference(input)) {
+			t.Fatalf("映射结果与参考实现不一致: %x", input)
+		}
+
+		inverse, ok := elligator2Inverse(u)
+		if !ok {
+			continue
+		}
+		inverted++
+		if elligator2Map(inverse).Equal(u) != 1 {
+			t.Fatalf("逆映射后再映射得到不同的点: %x", input)
+		}
+		// r 与 -r 映射到同一个点
+		if elligator2Map(new(field.Element).Negate(inverse)).Equal(u) != 1 {
+			t.Fatalf("-r 映射得到不同的点: %x", input)
+		}
+	}
+	if inverted == 0 {
+		t.Fatal("没有任何映射结果可以逆映射")
+	}
+}
+
+// Representative 还原出的公钥带有随机的低阶分量，与私钥的 DH 结果仍然一致；
+// 最高位不参与编码，修改后还原出同一个公钥
+func TestElligatorKeyAgreement(t *testing.T) {
+	peer := NewDiffeHellmanKeyPair()
+	tweaked := 0
+	for range 64 {
+		keyPair, err := NewElligatorKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKey, err := RepresentativeToPublicKey(keyPair.Representative)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(publicKey.Bytes(), keyPair.PrivateKey.PublicKey().Bytes()) {
+			tweaked++
+		}
+
+		expected, err := peer.PrivateKey.ECDH(keyPair.PrivateKey.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		shared, err := peer.PrivateKey.ECDH(publicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(shared, expected) {
+			t.Fatal("还原出的公钥与私钥的 DH 结果不一致")
+		}
+		reverse, err := keyPair.PrivateKey.ECDH(peer.PrivateKey.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(reverse, expected) {
+			t.Fatal("双方的 DH 结果不一致")
+		}
+
+		flipped := bytes.Clone(keyPair.Representative)
+		flipped[31] ^= 0x80
+		flippedKey, err := RepresentativeToPublicKey(flipped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(flippedKey.Bytes(), publicKey.Bytes()) {
+			t.Fatal("修改最高位后还原出不同的公钥")
+		}
+	}
+	// 每个公钥有 7/8 的概率带有低阶分量
+	if tweaked == 0 {
+		t.Fatal("还原出的公钥都没有低阶分量")
+	}
+
+	if _, err := RepresentativeToPublicKey(make([]byte, 31)); err != ErrInvalidPublicKey {
+		t.Fatalf("长度错误的 Representative 返回 %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	zxingqrcode "github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	fingerprintVersion    = 0
+	fingerprintIterations = 5200
+	fingerprintChunks     = 6 // 每个身份公钥生成 6 组 5 位数字
+)
+
+var ErrQRCodeNotFound = errors.New("图片中未找到二维码")
+
+// 由身份公钥计算 30 位数字的指纹，计算方式与 Signal 的安全码一致，
+// 多次迭代哈希增加了寻找指纹碰撞的成本
+func Fingerprint(identityKey []byte) string {
+	digest := make([]byte, 2, 2+2*len(identityKey))
+	binary.BigEndian.PutUint16(digest, fingerprintVersion)
+	digest = append(digest, identityKey...)
+	// 本项目没有手机号等稳定标识，以公钥本身作为标识
+	digest = append(digest, identityKey...)
+
+	for i := 0; i < fingerprintIterations; i++ {
+		sum := sha512.Sum512(append(digest, identityKey...))
+		digest = sum[:]
+	}
+
+	var builder strings.Builder
+	for i := 0; i < fingerprintChunks; i++ {
+		chunk := make([]byte, 8)
+		copy(chunk[3:], digest[i*5:i*5+5])
+		fmt.Fprintf(&builder, "%05d", binary.BigEndian.Uint64(chunk)%100000)
+	}
+	return builder.String()
+}
+
+// 由双方身份公钥计算 60 位数字的安全码，按指纹大小排序拼接，与双方顺序无关
+func SafetyNumber(localKey, remoteKey []byte) string {
+	localFingerprint := Fingerprint(localKey)
+	remoteFingerprint := Fingerprint(remoteKey)
+	if localFingerprint > remoteFingerprint {
+		localFingerprint, remoteFingerprint = remoteFingerprint, localFingerprint
+	}
+	return localFingerprint + remoteFingerprint
+}
+
+// 将安全码每 5 位分为一组，便于双方朗读比对
+func FormatSafetyNumber(number string) string {
+	groups := []string{}
+	for len(number) > 5 {
+		groups = append(groups, number[:5])
+		number = number[5:]
+	}
+	groups = append(groups, number)
+	return strings.Join(groups, " ")
+}
+
+// 将安全码渲染为边长为 size 像素的二维码 PNG 图片
+func SafetyNumberQRCode(number string, size int) ([]byte, error) {
+	return qrcode.Encode(number, qrcode.Medium, size)
+}
+
+// 识别图片中的二维码并与安全码比对，支持拍摄或扫描得到的旋转、倾斜的图片
+func CompareSafetyNumberQRCode(reader io.Reader, number string) (bool, error) {
+	img, _, err := image.Decode(reader)
+	if err != nil {
+		return false, err
+	}
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return false, err
+	}
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	result, err := zxingqrcode.NewQRCodeReader().Decode(bitmap, hints)
+	if err != nil {
+		return false, ErrQRCodeNotFound
+	}
+	return subtle.ConstantTimeCompare([]byte(result.GetText()), []byte(number)) == 1, nil
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"crypto/ecdh"
+	"errors"
+	"os"
+)
+
+// 从文件读取长期身份密钥，文件不存在时生成新的密钥并保存
+func LoadIdentityKey(path string) (*DiffeHellmanKeyPair, error) {
+	keyBytes, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		keyPair := NewDiffeHellmanKeyPair()
+		if err := os.WriteFile(path, keyPair.PrivateKey.Bytes(), 0600); err != nil {
+			return nil, err
+		}
+		return keyPair, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := ecdh.X25519().NewPrivateKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &DiffeHellmanKeyPair{
+		PrivateKey: privateKey,
+		PublicKey:  privateKey.PublicKey(),
+	}, nil
+}
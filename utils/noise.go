@@ -0,0 +1,366 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	NoiseXX = "XX" // 首次联系，双方在握手过程中交换静态公钥
+	NoiseIK = "IK" // 发起方预先知道响应方的静态公钥
+)
+
+const (
+	noiseHashLen = 32
+	noiseDHLen   = 32
+	noiseTagLen  = 16
+)
+
+var ErrNoisePattern = errors.New("不支持的 Noise 握手模式")
+var ErrNoiseMessage = errors.New("Noise 握手消息格式错误")
+var ErrNoiseComplete = errors.New("Noise 握手已经完成")
+
+// Noise 握手模式，preMessages 为响应方预先公开的令牌
+type noisePattern struct {
+	preMessages []string
+	messages    [][]string
+}
+
+var noisePatterns = map[string]*noisePattern{
+	NoiseXX: {
+		messages: [][]string{
+			{"e"},
+			{"e", "ee", "s", "es"},
+			{"s", "se"},
+		},
+	},
+	NoiseIK: {
+		preMessages: []string{"s"},
+		messages: [][]string{
+			{"e", "es", "s", "ss"},
+			{"e", "ee", "se"},
+		},
+	},
+}
+
+type noiseCipherState struct {
+	key   []byte
+	nonce uint64
+}
+
+type noiseSymmetricState struct {
+	cipherState   noiseCipherState
+	chainingKey   []byte
+	handshakeHash []byte
+}
+
+// Noise_XX_25519_AESGCM_SHA256 与 Noise_IK_25519_AESGCM_SHA256 的握手状态
+type NoiseHandshake struct {
+	symmetric    *noiseSymmetricState
+	pattern      *noisePattern
+	isInitiator  bool
+	messageIndex int
+	localStatic  *DiffeHellmanKeyPair
+	localEphem   *DiffeHellmanKeyPair
+	remoteStatic *ecdh.PublicKey
+	remoteEphem  *ecdh.PublicKey
+	newEphemeral func() *DiffeHellmanKeyPair // 生成临时密钥，测试向量中替换为固定的密钥
+}
+
+// 创建 Noise 握手，IK 模式的发起方必须提供对端静态公钥
+func NewNoiseHandshake(patternName string, isInitiator bool, prologue []byte, staticKey *DiffeHellmanKeyPair, remoteStatic []byte) (*NoiseHandshake, error) {
+	pattern, ok := noisePatterns[patternName]
+	if !ok {
+		return nil, ErrNoisePattern
+	}
+
+	handshake := &NoiseHandshake{
+		symmetric:    newNoiseSymmetricState("Noise_" + patternName + "_25519_AESGCM_SHA256"),
+		pattern:      pattern,
+		isInitiator:  isInitiator,
+		localStatic:  staticKey,
+		newEphemeral: NewDiffeHellmanKeyPair,
+	}
+	handshake.symmetric.mixHash(prologue)
+
+	for _, token := range pattern.preMessages {
+		if token != "s" {
+			continue
+		}
+		if isInitiator {
+			remoteKey, err := BytesToPublicKey(remoteStatic)
+			if err != nil {
+				return nil, err
+			}
+			handshake.remoteStatic = remoteKey
+			handshake.symmetric.mixHash(remoteStatic)
+		} else {
+			handshake.symmetric.mixHash(staticKey.PublicKey.Bytes())
+		}
+	}
+
+	return handshake, nil
+}
+
+// 按照当前轮次的令牌生成握手消息，payload 在密钥可用后被加密
+func (nh *NoiseHandshake) WriteMessage(payload []byte) ([]byte, error) {
+	if nh.IsComplete() {
+		return nil, ErrNoiseComplete
+	}
+
+	message := []byte{}
+	for _, token := range nh.pattern.messages[nh.messageIndex] {
+		switch token {
+		case "e":
+			nh.localEphem = nh.newEphemeral()
+			message = append(message, nh.localEphem.PublicKey.Bytes()...)
+			nh.symmetric.mixHash(nh.localEphem.PublicKey.Bytes())
+		case "s":
+			ciphertext, err := nh.symmetric.encryptAndHash(nh.localStatic.PublicKey.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			message = append(message, ciphertext...)
+		default:
+			if err := nh.mixDH(token); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	ciphertext, err := nh.symmetric.encryptAndHash(payload)
+	if err != nil {
+		return nil, err
+	}
+	nh.messageIndex++
+
+	return append(message, ciphertext...), nil
+}
+
+// 按照当前轮次的令牌解析握手消息，返回解密后的 payload
+func (nh *NoiseHandshake) ReadMessage(message []byte) ([]byte, error) {
+	if nh.IsComplete() {
+		return nil, ErrNoiseComplete
+	}
+
+	for _, token := range nh.pattern.messages[nh.messageIndex] {
+		switch token {
+		case "e":
+			if len(message) < noiseDHLen {
+				return nil, ErrNoiseMessage
+			}
+			remoteKey, err := BytesToPublicKey(message[:noiseDHLen])
+			if err != nil {
+				return nil, err
+			}
+			nh.remoteEphem = remoteKey
+			nh.symmetric.mixHash(message[:noiseDHLen])
+			message = message[noiseDHLen:]
+		case "s":
+			length := noiseDHLen
+			if nh.symmetric.cipherState.key != nil {
+				length += noiseTagLen
+			}
+			if len(message) < length {
+				return nil, ErrNoiseMessage
+			}
+			plaintext, err := nh.symmetric.decryptAndHash(message[:length])
+			if err != nil {
+				return nil, err
+			}
+			remoteKey, err := BytesToPublicKey(plaintext)
+			if err != nil {
+				return nil, err
+			}
+			nh.remoteStatic = remoteKey
+			message = message[length:]
+		default:
+			if err := nh.mixDH(token); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	payload, err := nh.symmetric.decryptAndHash(message)
+	if err != nil {
+		return nil, err
+	}
+	nh.messageIndex++
+
+	return payload, nil
+}
+
+// 根据令牌与自身角色选择参与 DH 计算的密钥
+func (nh *NoiseHandshake) mixDH(token string) error {
+	var localKey *DiffeHellmanKeyPair
+	var remoteKey *ecdh.PublicKey
+
+	switch token {
+	case "ee":
+		localKey, remoteKey = nh.localEphem, nh.remoteEphem
+	case "ss":
+		localKey, remoteKey = nh.localStatic, nh.remoteStatic
+	case "es":
+		if nh.isInitiator {
+			localKey, remoteKey = nh.localEphem, nh.remoteStatic
+		} else {
+			localKey, remoteKey = nh.localStatic, nh.remoteEphem
+		}
+	case "se":
+		if nh.isInitiator {
+			localKey, remoteKey = nh.localStatic, nh.remoteEphem
+		} else {
+			localKey, remoteKey = nh.localEphem, nh.remoteStatic
+		}
+	default:
+		return ErrNoisePattern
+	}
+	if localKey == nil || remoteKey == nil {
+		return ErrNoiseMessage
+	}
+
+	sharedSecret, err := localKey.PrivateKey.ECDH(remoteKey)
+	if err != nil {
+		return err
+	}
+	nh.symmetric.mixKey(sharedSecret)
+	return nil
+}
+
+// 当前轮次是否轮到自己发送
+func (nh *NoiseHandshake) IsMyTurn() bool {
+	return (nh.messageIndex%2 == 0) == nh.isInitiator
+}
+
+func (nh *NoiseHandshake) IsComplete() bool {
+	return nh.messageIndex >= len(nh.pattern.messages)
+}
+
+// 握手哈希绑定了完整的握手记录，握手完成后双方一致
+func (nh *NoiseHandshake) HandshakeHash() []byte {
+	return append([]byte(nil), nh.symmetric.handshakeHash...)
+}
+
+// 握手完成后的链密钥，只有参与握手的双方知道
+func (nh *NoiseHandshake) ChainingKey() []byte {
+	return append([]byte(nil), nh.symmetric.chainingKey...)
+}
+
+// 经过认证的对端静态公钥
+func (nh *NoiseHandshake) RemoteStatic() []byte {
+	if nh.remoteStatic == nil {
+		return nil
+	}
+	return nh.remoteStatic.Bytes()
+}
+
+func newNoiseSymmetricState(protocolName string) *noiseSymmetricState {
+	handshakeHash := make([]byte, noiseHashLen)
+	if len(protocolName) <= noiseHashLen {
+		copy(handshakeHash, protocolName)
+	} else {
+		digest := sha256.Sum256([]byte(protocolName))
+		handshakeHash = digest[:]
+	}
+
+	return &noiseSymmetricState{
+		chainingKey:   append([]byte(nil), handshakeHash...),
+		handshakeHash: handshakeHash,
+	}
+}
+
+func (ss *noiseSymmetricState) mixHash(data []byte) {
+	digest := sha256.New()
+	digest.Write(ss.handshakeHash)
+	digest.Write(data)
+	ss.handshakeHash = digest.Sum(nil)
+}
+
+func (ss *noiseSymmetricState) mixKey(inputKey []byte) {
+	outputs := noiseHKDF(ss.chainingKey, inputKey, 2)
+	ss.chainingKey = outputs[0]
+	ss.cipherState = noiseCipherState{key: outputs[1]}
+}
+
+func (ss *noiseSymmetricState) encryptAndHash(plaintext []byte) ([]byte, error) {
+	ciphertext := plaintext
+	if ss.cipherState.key != nil {
+		var err error
+		ciphertext, err = ss.cipherState.encrypt(ss.handshakeHash, plaintext)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ss.mixHash(ciphertext)
+	return ciphertext, nil
+}
+
+func (ss *noiseSymmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext := ciphertext
+	if ss.cipherState.key != nil {
+		var err error
+		plaintext, err = ss.cipherState.decrypt(ss.handshakeHash, ciphertext)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ss.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+func (cs *noiseCipherState) aead() (cipher.AEAD, []byte, error) {
+	block, err := aes.NewCipher(cs.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// AESGCM 的 nonce 为 32 位的 0 加上 64 位大端序计数
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], cs.nonce)
+	cs.nonce++
+
+	return aesGCM, nonce, nil
+}
+
+func (cs *noiseCipherState) encrypt(ad, plaintext []byte) ([]byte, error) {
+	aesGCM, nonce, err := cs.aead()
+	if err != nil {
+		return nil, err
+	}
+	return aesGCM.Seal(nil, nonce, plaintext, ad), nil
+}
+
+func (cs *noiseCipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	aesGCM, nonce, err := cs.aead()
+	if err != nil {
+		return nil, err
+	}
+	return aesGCM.Open(nil, nonce, ciphertext, ad)
+}
+
+// Noise 规范中基于 HMAC-SHA256 的 HKDF
+func noiseHKDF(chainingKey, inputKey []byte, count int) [][]byte {
+	mac := hmac.New(sha256.New, chainingKey)
+	mac.Write(inputKey)
+	tempKey := mac.Sum(nil)
+
+	outputs := make([][]byte, 0, count)
+	previous := []byte{}
+	for i := 1; i <= count; i++ {
+		mac = hmac.New(sha256.New, tempKey)
+		mac.Write(previous)
+		mac.Write([]byte{byte(i)})
+		previous = mac.Sum(nil)
+		outputs = append(outputs, previous)
+	}
+	return outputs
+}
@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"testing"
+)
+
+// 测试向量取自 github.com/flynn/noise v1.1.0 的 vectors.txt，prologue 为空，
+// 双方的静态密钥与临时密钥都是固定的，握手消息的 payload 为空
+var noiseVectors = []struct {
+	pattern         string
+	initStatic      string
+	respStatic      string
+	initEphemeral   string
+	respEphemeral   string
+	messages        []string
+	initiatorRemote bool // 发起方是否预先知道响应方的静态公钥
+}{
+	{
+		pattern:       NoiseXX,
+		initStatic:    "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		respStatic:    "0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20",
+		initEphemeral: "202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f",
+		respEphemeral: "4142434445464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f60",
+		messages: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd166254",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d484665393019dbd6f438795da206db0886610b26108e424142c2e9b5fd1f7ea70cde8767ce62d7e3c0e9bcefe4ab872c0505b9e824df091b74ffe10a2b32809cab21f",
+			"e610eadc4b00c17708bf223f29a66f02342fbedf6c0044736544b9271821ae40e70144cecd9d265dffdc5bb8e051c3f83db32a425e04d8f510c58a43325fbc56",
+		},
+	},
+	{
+		pattern:       NoiseIK,
+		initStatic:    "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		respStatic:    "0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20",
+		initEphemeral: "202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f",
+		respEphemeral: "4142434445464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f60",
+		messages: []string{
+			"358072d6365880d1aeea329adf9121383851ed21a28e3b75e965d0d2cd16625419d6fab175300a577115c701c41ed681373f0432f81d3bf8676bd05216cd1919ba2eaa418fdd8e09ae59d7cf57869de42789c3b9ca915c2cacf009f9d0e4436e",
+			"64b101b1d0be5a8704bd078f9895001fc03e8e9f9522f188dd128d9846d4846623c019a124da3f096e964fe624cf65db",
+		},
+		initiatorRemote: true,
+	},
+}
+
+// 由十六进制的 X25519 私钥构造密钥对
+func noiseKeyPair(t *testing.T, privateHex string) *DiffeHellmanKeyPair {
+	t.Helper()
+
+	privateBytes, err := hex.DecodeString(privateHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := ecdh.X25519().NewPrivateKey(privateBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &DiffeHellmanKeyPair{PublicKey: privateKey.PublicKey(), PrivateKey: privateKey}
+}
+
+// 依次交换握手消息直到双方都完成握手，返回每一轮的握手消息
+func runNoiseHandshake(t *testing.T, initiator, responder *NoiseHandshake) [][]byte {
+	t.Helper()
+
+	var messages [][]byte
+	sender, receiver := initiator, responder
+	for !initiator.IsComplete() {
+		if !sender.IsMyTurn() {
+			t.Fatal("握手轮次错误")
+		}
+		message, err := sender.WriteMessage(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := receiver.ReadMessage(message); err != nil {
+			t.Fatalf("第 %d 条握手消息解析失败: %v", len(messages), err)
+		}
+		messages = append(messages, message)
+		sender, receiver = receiver, sender
+	}
+	if !responder.IsComplete() {
+		t.Fatal("响应方没有完成握手")
+	}
+	return messages
+}
+
+func TestNoiseVectors(t *testing.T) {
+	for _, vector := range noiseVectors {
+		t.Run(vector.pattern, func(t *testing.T) {
+			initStatic := noiseKeyPair(t, vector.initStatic)
+			respStatic := noiseKeyPair(t, vector.respStatic)
+			var remoteStatic []byte
+			if vector.initiatorRemote {
+				remoteStatic = respStatic.PublicKey.Bytes()
+			}
+
+			initiator, err := NewNoiseHandshake(vector.pattern, true, nil, initStatic, remoteStatic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			initiator.newEphemeral = func() *DiffeHellmanKeyPair { return noiseKeyPair(t, vector.initEphemeral) }
+			responder, err := NewNoiseHandshake(vector.pattern, false, nil, respStatic, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			responder.newEphemeral = func() *DiffeHellmanKeyPair { return noiseKeyPair(t, vector.respEphemeral) }
+
+			messages := runNoiseHandshake(t, initiator, responder)
+			if len(messages) != len(vector.messages) {
+				t.Fatalf("握手消息数 %d，期望 %d", len(messages), len(vector.messages))
+			}
+			for i, message := range messages {
+				if hex.EncodeToString(message) != vector.messages[i] {
+					t.Fatalf("第 %d 条握手消息\n得到 %x\n期望 %s", i, message, vector.messages[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNoiseRoundTrip(t *testing.T) {
+	for _, pattern := range []string{NoiseXX, NoiseIK} {
+		t.Run(pattern, func(t *testing.T) {
+			initStatic := NewDiffeHellmanKeyPair()
+			respStatic := NewDiffeHellmanKeyPair()
+			var remoteStatic []byte
+			if pattern == NoiseIK {
+				remoteStatic = respStatic.PublicKey.Bytes()
+			}
+
+			prologue := []byte("DoubleRatchetDemo test")
+			initiator, err := NewNoiseHandshake(pattern, true, prologue, initStatic, remoteStatic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			responder, err := NewNoiseHandshake(pattern, false, prologue, respStatic, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runNoiseHandshake(t, initiator, responder)
+
+			if !bytes.Equal(initiator.HandshakeHash(), responder.HandshakeHash()) {
+				t.Fatal("双方的握手哈希不一致")
+			}
+			if !bytes.Equal(initiator.ChainingKey(), responder.ChainingKey()) {
+				t.Fatal("双方的链密钥不一致")
+			}
+			if !bytes.Equal(initiator.RemoteStatic(), respStatic.PublicKey.Bytes()) {
+				t.Fatal("发起方得到的对端静态公钥错误")
+			}
+			if !bytes.Equal(responder.RemoteStatic(), initStatic.PublicKey.Bytes()) {
+				t.Fatal("响应方得到的对端静态公钥错误")
+			}
+			if _, err := initiator.WriteMessage(nil); err != ErrNoiseComplete {
+				t.Fatalf("握手完成后继续写入返回 %v，期望 %v", err, ErrNoiseComplete)
+			}
+		})
+	}
+}
+
+// IK 模式下发起方预先使用的静态公钥与响应方不一致时，响应方无法解析第一条握手消息
+func TestNoiseIKWrongRemoteStatic(t *testing.T) {
+	initStatic := NewDiffeHellmanKeyPair()
+	respStatic := NewDiffeHellmanKeyPair()
+	otherStatic := NewDiffeHellmanKeyPair()
+
+	initiator, err := NewNoiseHandshake(NoiseIK, true, nil, initStatic, otherStatic.PublicKey.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, err := NewNoiseHandshake(NoiseIK, false, nil, respStatic, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := initiator.WriteMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := responder.ReadMessage(message); err == nil {
+		t.Fatal("错误的对端静态公钥没有导致握手失败")
+	}
+}
+
+// 不同的 prologue 使握手在第一条加密的消息处失败
+func TestNoisePrologueMismatch(t *testing.T) {
+	initiator, err := NewNoiseHandshake(NoiseXX, true, []byte("a"), NewDiffeHellmanKeyPair(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, err := NewNoiseHandshake(NoiseXX, false, []byte("b"), NewDiffeHellmanKeyPair(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, _ := initiator.WriteMessage(nil)
+	if _, err := responder.ReadMessage(message); err != nil {
+		t.Fatal(err)
+	}
+	message, _ = responder.WriteMessage(nil)
+	if _, err := initiator.ReadMessage(message); err == nil {
+		t.Fatal("prologue 不一致时握手没有失败")
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// 配对码使用的单词表，共 256 个易于拼写与朗读的单词
+var pairingWords = strings.Fields(`
+	acid agent album alpha amber anchor angel apple arrow atlas august
+	autumn bacon badge baker bamboo banana banjo barber basket beacon berry bicycle
+	bishop blanket blossom border bottle breeze bridge bronze bubble bucket buffalo
+	button cabin cactus camera candle canyon carbon carpet castle cello century
+	cherry chess circle citrus clover cobra coffee comet copper coral cotton
+	cowboy crayon cricket crystal cube dancer delta denim desert diamond dinner
+	dolphin domino dragon dream drum eagle echo eclipse elbow ember engine
+	falcon fiber fiddle finger flannel flute forest fossil fountain galaxy garden
+	garlic gate gecko ginger glacier globe gorilla gospel granite gravel guitar
+	hammer harbor harvest hazel helmet hermit honey horizon hotel husky igloo
+	indigo island ivory jacket jaguar jasmine jelly jersey jigsaw jungle kayak
+	kernel kettle kiwi koala ladder lagoon lantern laser lemon leopard lilac
+	lobster locket lotus magnet mango maple marble meadow melody mercury meteor
+	mirror mosaic motor muffin museum nectar needle nickel nomad noodle oasis
+	ocean olive onion opera orbit orchid otter oxygen paddle palace panda
+	parade parrot pepper piano pickle pilot pirate planet plaza pocket polar
+	potato pretzel prism puzzle quartz quiver rabbit radar rainbow raven recipe
+	remedy revenge ribbon river rocket rodeo ruby saddle salmon sandal saturn
+	scarlet scooter season shadow sierra silver sketch socket spider spiral
+	sponge spring statue stereo summit sunset swallow tango tapestry temple
+	thunder ticket tiger timber toast tomato tornado trumpet tulip tunnel turtle
+	umbrella unicorn uranium valley vanilla velvet venus violin vision volcano
+	voyage waffle walnut walrus wander whisper willow window winter wizard
+	yellow yoga zebra zenith zephyr zigzag
+`)
+
+// 生成形如 7-guitar-revenge 的配对码
+func NewPairingCode() string {
+	number, _ := rand.Int(rand.Reader, big.NewInt(99))
+	words := make([]string, 0, 2)
+	for range 2 {
+		index, _ := rand.Int(rand.Reader, big.NewInt(int64(len(pairingWords))))
+		words = append(words, pairingWords[index.Int64()])
+	}
+	return fmt.Sprintf("%d-%s", number.Int64()+1, strings.Join(words, "-"))
+}
+
+// 统一配对码的大小写与分隔符，避免输入习惯不同导致配对失败
+func NormalizePairingCode(code string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(code), func(r rune) bool {
+		return r == '-' || r == ' ' || r == '\t'
+	}), "-")
+}
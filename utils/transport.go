@@ -4,9 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
 )
 
+var ErrMessageTooLarge = errors.New("消息长度超过上限")
+
 // Encode 编码消息
 func EncodeMessage(message []byte) (msg []byte, err error) {
 	// 读取消息的长度
@@ -28,6 +31,12 @@ func EncodeMessage(message []byte) (msg []byte, err error) {
 
 // Decode 解码消息
 func DecodeMessage(reader *bufio.Reader) (msg []byte, err error) {
+	return DecodeMessageLimit(reader, -1)
+}
+
+// 解码长度不超过 maxLength 的消息，在分配缓冲之前检查长度，maxLength 为负数时不限制长度；
+// 读取未经认证的对端发送的消息时使用，避免对端声明的长度导致巨大的内存分配
+func DecodeMessageLimit(reader *bufio.Reader, maxLength int) (msg []byte, err error) {
 	length := uint32(0)            // 读取消息的长度
 	lengthBytes := make([]byte, 4) // 保存从reader中读取的数据
 
@@ -42,6 +51,10 @@ func DecodeMessage(reader *bufio.Reader) (msg []byte, err error) {
 		return nil, err
 	}
 
+	if maxLength >= 0 && uint64(length) > uint64(maxLength) {
+		return nil, ErrMessageTooLarge
+	}
+
 	// 读取消息实体
 	messageBytes := make([]byte, length)
 	if _, err = io.ReadFull(reader, messageBytes); err != nil {
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodeMessageLimit(t *testing.T) {
+	frame, err := EncodeMessage([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := DecodeMessageLimit(bufio.NewReader(bytes.NewReader(frame)), 5)
+	if err != nil || string(message) != "hello" {
+		t.Fatalf("解码得到 %q: %v", message, err)
+	}
+	if _, err := DecodeMessageLimit(bufio.NewReader(bytes.NewReader(frame)), 4); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("超过上限的消息返回 %v，期望 %v", err, ErrMessageTooLarge)
+	}
+
+	// 只有长度前缀而没有消息实体，超过上限时不能尝试分配 4 GiB 的缓冲
+	huge := []byte{0xff, 0xff, 0xff, 0xff}
+	if _, err := DecodeMessageLimit(bufio.NewReader(bytes.NewReader(huge)), 1<<20); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("声明 4 GiB 的消息返回 %v，期望 %v", err, ErrMessageTooLarge)
+	}
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+
+	"filippo.io/edwards25519"
+	"filippo.io/edwards25519/field"
+)
+
+const XEdDSASignatureSize = 64
+
+var ErrXEdDSAKey = errors.New("无效的 XEdDSA 私钥")
+
+// 使用 X25519 私钥生成 XEdDSA 签名 (Signal XEdDSA 规范)，
+// 签名可由对应的 X25519 公钥验证，无需另外保存 Ed25519 密钥
+func XEdDSASign(privateKey *ecdh.PrivateKey, message []byte) ([]byte, error) {
+	random := make([]byte, 64)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	return xeddsaSign(privateKey, message, random)
+}
+
+// 使用 X25519 公钥验证 XEdDSA 签名
+func XEdDSAVerify(publicKey, message, signature []byte) bool {
+	if len(publicKey) != 32 || len(signature) != XEdDSASignatureSize {
+		return false
+	}
+
+	// u 坐标必须是规范编码，且不能转换为无效的 Edwards 点
+	u, err := new(field.Element).SetBytes(publicKey)
+	if err != nil || !bytes.Equal(u.Bytes(), publicKey) {
+		return false
+	}
+	edPublicKey, err := new(edwards25519.Point).SetBytes(montgomeryToEdwards(u))
+	if err != nil {
+		return false
+	}
+
+	// R 必须是合法的点编码，s 必须小于群的阶
+	if _, err := new(edwards25519.Point).SetBytes(signature[:32]); err != nil {
+		return false
+	}
+	s, err := new(edwards25519.Scalar).SetCanonicalBytes(signature[32:])
+	if err != nil {
+		return false
+	}
+
+	// R' = sB - hA，与签名中的 R 比较编码
+	h := xeddsaChallenge(signature[:32], edPublicKey.Bytes(), message)
+	checkR := new(edwards25519.Point).VarTimeDoubleScalarBaseMult(new(edwards25519.Scalar).Negate(h), edPublicKey, s)
+	return bytes.Equal(checkR.Bytes(), signature[:32])
+}
+
+// random 为 64 字节的随机数，使相同消息的签名各不相同
+func xeddsaSign(privateKey *ecdh.PrivateKey, message, random []byte) ([]byte, error) {
+	k, err := new(edwards25519.Scalar).SetBytesWithClamping(privateKey.Bytes())
+	if err != nil {
+		return nil, ErrXEdDSAKey
+	}
+
+	// calculate_key_pair: 使公钥的符号位为 0，必要时对私钥取负
+	edPoint := new(edwards25519.Point).ScalarBaseMult(k)
+	edPublicKey := edPoint.Bytes()
+	a := k
+	if edPublicKey[31]&0x80 != 0 {
+		a = new(edwards25519.Scalar).Negate(k)
+		edPublicKey[31] &= 0x7f
+	}
+
+	// r = hash1(a || M || Z) mod q
+	prefix := bytes.Repeat([]byte{0xff}, 32)
+	prefix[0] = 0xfe
+	digest := sha512.New()
+	digest.Write(prefix)
+	digest.Write(a.Bytes())
+	digest.Write(message)
+	digest.Write(random)
+	r, err := new(edwards25519.Scalar).SetUniformBytes(digest.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	// s = r + h·a mod q
+	R := new(edwards25519.Point).ScalarBaseMult(r).Bytes()
+	h := xeddsaChallenge(R, edPublicKey, message)
+	s := new(edwards25519.Scalar).MultiplyAdd(h, a, r)
+
+	return append(R, s.Bytes()...), nil
+}
+
+// h = SHA512(R || A || M) mod q，与 Ed25519 的计算方式相同
+func xeddsaChallenge(R, edPublicKey, message []byte) *edwards25519.Scalar {
+	digest := sha512.New()
+	digest.Write(R)
+	digest.Write(edPublicKey)
+	digest.Write(message)
+	h, _ := new(edwards25519.Scalar).SetUniformBytes(digest.Sum(nil))
+	return h
+}
+
+// 将 Montgomery u 坐标转换为符号位为 0 的 Edwards 点编码: y = (u - 1) / (u + 1)
+func montgomeryToEdwards(u *field.Element) []byte {
+	one := new(field.Element).One()
+	numerator := new(field.Element).Subtract(u, one)
+	denominator := new(field.Element).Add(u, one)
+	y := new(field.Element).Multiply(numerator, denominator.Invert(denominator))
+	return y.Bytes()
+}
+
+// 使用身份密钥对签名，便于身份层只保存一种 X25519 密钥
+func (dfk *DiffeHellmanKeyPair) Sign(message []byte) ([]byte, error) {
+	return XEdDSASign(dfk.PrivateKey, message)
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	"filippo.io/edwards25519"
+	"filippo.io/edwards25519/field"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// 由 Ed25519 种子得到相同标量的 X25519 私钥 (RFC 8032 第 5.1.5 节)
+func x25519FromSeed(t *testing.T, seed []byte) *ecdh.PrivateKey {
+	t.Helper()
+	digest := sha512.Sum512(seed)
+	privateKey, err := ecdh.X25519().NewPrivateKey(digest[:32])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey
+}
+
+// 符号位为 0 的 Edwards 公钥，XEdDSA 验证时使用的就是这个点
+func edwardsPublicKey(t *testing.T, publicKey []byte) []byte {
+	t.Helper()
+	u, err := new(field.Element).SetBytes(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return montgomeryToEdwards(u)
+}
+
+func TestXEdDSAVector(t *testing.T) {
+	// RFC 7748 第 6.1 节中 Alice 的密钥对
+	privateKey, err := ecdh.X25519().NewPrivateKey(decodeHex(t, "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := decodeHex(t, "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a")
+	if !bytes.Equal(privateKey.PublicKey().Bytes(), publicKey) {
+		t.Fatalf("unexpected public key %x", privateKey.PublicKey().Bytes())
+	}
+
+	// 固定随机数时签名是确定的，用于发现签名算法的意外改动
+	message := []byte("DoubleRatchetDemo XEdDSA")
+	signature, err := xeddsaSign(privateKey, message, bytes.Repeat([]byte{0x5a}, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := decodeHex(t, "c26bb60e89a2503a98c952f74ced8ffc577182b855663ebdfb44184c3a9970c028849d2035aa2d923cf041a97d743a71a2c016ff9593d62ae10c3121931d4e0a")
+	if !bytes.Equal(signature, expected) {
+		t.Fatalf("unexpected signature %x", signature)
+	}
+	if !XEdDSAVerify(publicKey, message, expected) {
+		t.Fatal("failed to verify the known signature")
+	}
+	// XEdDSA 签名就是对符号位为 0 的 Edwards 公钥的 Ed25519 签名
+	if !ed25519.Verify(edwardsPublicKey(t, publicKey), message, expected) {
+		t.Fatal("the signature is not a valid Ed25519 signature")
+	}
+}
+
+func TestMontgomeryToEdwards(t *testing.T) {
+	// RFC 8032 第 7.1 节 TEST 1 与 TEST 2 的密钥
+	vectors := []struct{ seed, publicKey string }{
+		{"9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60", "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"},
+		{"4ccd089b28ff96da9db6c346ec114e0f5b8a319f35aba624da8cf6ed4fb8a6fb", "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c"},
+	}
+	for _, vector := range vectors {
+		edPublicKey := decodeHex(t, vector.publicKey)
+		if !bytes.Equal(ed25519.NewKeyFromSeed(decodeHex(t, vector.seed)).Public().(ed25519.PublicKey), edPublicKey) {
+			t.Fatalf("unexpected Ed25519 public key for seed %s", vector.seed)
+		}
+
+		// 同一标量的 X25519 公钥转换后应得到同一个点，仅符号位被清零
+		montgomery := x25519FromSeed(t, decodeHex(t, vector.seed)).PublicKey().Bytes()
+		expected := append([]byte(nil), edPublicKey...)
+		expected[31] &= 0x7f
+		if converted := edwardsPublicKey(t, montgomery); !bytes.Equal(converted, expected) {
+			t.Fatalf("got %x, want %x", converted, expected)
+		}
+
+		// 反向转换: u = (1 + y) / (1 - y)
+		point, err := new(edwards25519.Point).SetBytes(edPublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(point.BytesMontgomery(), montgomery) {
+			t.Fatalf("got %x, want %x", point.BytesMontgomery(), montgomery)
+		}
+	}
+}
+
+func TestXEdDSASignVerify(t *testing.T) {
+	message := []byte("hello")
+	for i := range 32 {
+		keyPair := NewDiffeHellmanKeyPair()
+		publicKey := keyPair.PublicKey.Bytes()
+		signature, err := keyPair.Sign(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !XEdDSAVerify(publicKey, message, signature) {
+			t.Fatal("failed to verify the signature")
+		}
+		if !ed25519.Verify(edwardsPublicKey(t, publicKey), message, signature) {
+			t.Fatal("the signature is not a valid Ed25519 signature")
+		}
+
+		if XEdDSAVerify(publicKey, []byte("hellO"), signature) {
+			t.Fatal("verified a signature over another message")
+		}
+		tampered := append([]byte(nil), signature...)
+		tampered[i*2] ^= 0x01
+		if XEdDSAVerify(publicKey, message, tampered) {
+			t.Fatal("verified a tampered signature")
+		}
+	}
+}
+
+func TestXEdDSARejectsNonCanonical(t *testing.T) {
+	keyPair := NewDiffeHellmanKeyPair()
+	message := []byte("hello")
+	signature, err := keyPair.Sign(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// s + q 与 s 等价，但不是规范编码
+	s, err := new(edwards25519.Scalar).SetCanonicalBytes(signature[32:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := decodeHex(t, "edd3f55c1a631258d69cf7a2def9de1400000000000000000000000000000010")
+	var carry uint16
+	sum := s.Bytes()
+	for i := range sum {
+		carry += uint16(sum[i]) + uint16(order[i])
+		sum[i] = byte(carry)
+		carry >>= 8
+	}
+	malleable := append(append([]byte(nil), signature[:32]...), sum...)
+	if XEdDSAVerify(keyPair.PublicKey.Bytes(), message, malleable) {
+		t.Fatal("verified a signature with a non-canonical s")
+	}
+
+	// u = p 与 u = 0 等价，但不是规范编码
+	publicKey := keyPair.PublicKey.Bytes()
+	nonCanonical := decodeHex(t, "edffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f")
+	if XEdDSAVerify(nonCanonical, message, signature) {
+		t.Fatal("verified with a non-canonical public key")
+	}
+	if XEdDSAVerify(publicKey[:31], message, signature) || XEdDSAVerify(publicKey, message, signature[:63]) {
+		t.Fatal("verified with truncated inputs")
+	}
+}